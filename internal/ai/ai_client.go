@@ -2,46 +2,381 @@ package ai_client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 
+	gl "cloud.google.com/go/ai/generativelanguage/apiv1beta"
+	pb "cloud.google.com/go/ai/generativelanguage/apiv1beta/generativelanguagepb"
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// defaultGeminiModel はモデル名が未設定の場合に使用するGeminiモデルです。
+const defaultGeminiModel = "gemini-1.5-flash"
+
+// defaultGeminiEmbeddingModel は埋め込みモデルが未設定の場合に使用するモデルです。
+const defaultGeminiEmbeddingModel = "text-embedding-004"
+
 // GeminiClient はGemini APIとの連携を担当します。
 type GeminiClient struct {
-	client *genai.GenerativeModel
-	ctx    context.Context
+	client *genai.Client
+	// generative はトークン数の計測に使用します。genai の CountTokens はロール付きの会話を数えられないため、APIを直接呼び出します。
+	generative     *gl.GenerativeClient
+	modelName      string
+	embeddingModel string
+	safetySettings []*genai.SafetySetting
 }
 
 // NewGeminiClient は新しいGeminiClientのインスタンスを作成します。
-func NewGeminiClient(apiKey string) (*GeminiClient, error) {
+// modelName が空の場合は既定のモデルを使用します。
+func NewGeminiClient(apiKey string, modelName string) (*GeminiClient, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("API key is missing")
 	}
+	return newGeminiClient(modelName, option.WithAPIKey(apiKey))
+}
+
+// newGeminiClient は opts で接続するGeminiClientを作成します。テストでは接続先を差し替えるために使用します。
+func newGeminiClient(modelName string, opts ...option.ClientOption) (*GeminiClient, error) {
+	if modelName == "" {
+		modelName = defaultGeminiModel
+	}
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	client, err := genai.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	generative, err := gl.NewGenerativeRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
 	}
-	model := client.GenerativeModel("gemini-1.5-flash")
-	return &GeminiClient{client: model, ctx: ctx}, nil
+	return &GeminiClient{client: client, generative: generative, modelName: modelName}, nil
+}
+
+// ModelInfo は使用中のモデル情報を返します。
+func (gc *GeminiClient) ModelInfo() ModelInfo {
+	return ModelInfo{Provider: ProviderGemini, Model: gc.modelName}
+}
+
+// SafetyConfig は設定ファイルの safety_settings から作成した安全性フィルタの設定を返します。
+func (gc *GeminiClient) SafetyConfig() []string {
+	return geminiSafetyConfig(gc.safetySettings)
+}
+
+// Generate は会話履歴を含むリクエストに基づいてAIコンテンツを生成します。
+func (gc *GeminiClient) Generate(ctx context.Context, req *Request) (*Response, error) {
+	if gc.client == nil {
+		return nil, fmt.Errorf("Gemini client is not initialized")
+	}
+	cs, parts, err := gc.startChat(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cs.SendMessage(ctx, parts...)
+	if err != nil {
+		var blocked *genai.BlockedError
+		if errors.As(err, &blocked) {
+			return gc.blockedResponse(req, "", Usage{}, geminiBlockedError(blocked))
+		}
+		return nil, fmt.Errorf("failed to generate content: %w", err)
+	}
+
+	answer := extractGeminiText(resp)
+	toolCalls := extractGeminiToolCalls(resp)
+	finishReason, safetyRatings := geminiFinish(resp)
+	result := &Response{Text: answer, Model: gc.requestModel(req), Usage: geminiUsage(resp), ToolCalls: toolCalls, FinishReason: finishReason, SafetyRatings: safetyRatings}
+	return gc.checkEmptyAnswer(result)
+}
+
+// GenerateStream は応答をストリーミングで生成し、チャンクごとに onChunk を呼び出します。
+func (gc *GeminiClient) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	if gc.client == nil {
+		return nil, fmt.Errorf("Gemini client is not initialized")
+	}
+	cs, parts, err := gc.startChat(req)
+	if err != nil {
+		return nil, err
+	}
+	iter := cs.SendMessageStream(ctx, parts...)
+
+	var answer string
+	var usage Usage
+	var toolCalls []ToolCall
+	var finishReason FinishReason
+	var safetyRatings []SafetyRating
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			var blocked *genai.BlockedError
+			if errors.As(err, &blocked) {
+				return gc.blockedResponse(req, answer, usage, geminiBlockedError(blocked))
+			}
+			return &Response{Text: answer, Model: gc.requestModel(req), Usage: usage}, fmt.Errorf("failed to stream content: %w", err)
+		}
+		// 使用量は最後のチャンクに累計で含まれます。
+		if u := geminiUsage(resp); !u.IsZero() {
+			usage = u
+		}
+		// 終了理由と安全性評価は最後のチャンクの候補に含まれます。
+		if reason, ratings := geminiFinish(resp); reason != "" {
+			finishReason, safetyRatings = reason, ratings
+		}
+		toolCalls = append(toolCalls, extractGeminiToolCalls(resp)...)
+		chunk := extractGeminiText(resp)
+		if chunk == "" {
+			continue
+		}
+		answer += chunk
+		if onChunk != nil {
+			onChunk(chunk)
+		}
+	}
+	result := &Response{Text: answer, Model: gc.requestModel(req), Usage: usage, ToolCalls: toolCalls, FinishReason: finishReason, SafetyRatings: safetyRatings}
+	return gc.checkEmptyAnswer(result)
+}
+
+// blockedResponse はブロックされた応答を、それまでに受信したテキストと終了理由付きのエラーとして返します。
+func (gc *GeminiClient) blockedResponse(req *Request, partial string, usage Usage, blocked *BlockedError) (*Response, error) {
+	log.Printf("Gemini API blocked the response: %v", blocked)
+	return &Response{
+		Text:          partial,
+		Model:         gc.requestModel(req),
+		Usage:         usage,
+		FinishReason:  blocked.Reason,
+		SafetyRatings: blocked.SafetyRatings,
+	}, blocked
+}
+
+// checkEmptyAnswer は回答もツール呼び出しもない応答を確認します。
+// 正常終了以外の理由で空になった場合は、空の回答を返す代わりに BlockedError を返します。
+func (gc *GeminiClient) checkEmptyAnswer(resp *Response) (*Response, error) {
+	if resp.Text != "" || len(resp.ToolCalls) > 0 {
+		return resp, nil
+	}
+	log.Printf("Gemini API returned an empty answer (finish reason: %q).", resp.FinishReason)
+	if resp.FinishReason == "" || resp.FinishReason == FinishReasonStop {
+		return resp, nil
+	}
+	return resp, &BlockedError{Reason: resp.FinishReason, SafetyRatings: resp.SafetyRatings}
+}
+
+// startChat は過去のメッセージを履歴に持つチャットセッションと、送信する最新の質問のパートを作成します。
+func (gc *GeminiClient) startChat(req *Request) (*genai.ChatSession, []genai.Part, error) {
+	history, last, err := splitLastUserMessage(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	cs := gc.newModel(req).StartChat()
+	for _, m := range history {
+		cs.History = append(cs.History, &genai.Content{
+			Role:  geminiRole(m.Role),
+			Parts: geminiParts(m),
+		})
+	}
+	log.Printf("Sending message to Gemini (%s, %d history turns, %d images): \n%s\n", gc.requestModel(req), len(cs.History), len(last.Images), last.Text)
+	return cs, geminiParts(last), nil
+}
+
+// geminiParts はメッセージのテキストと添付画像、ツール呼び出しとその結果をGeminiのパートに変換します。画像はBlobとして送信します。
+func geminiParts(m Message) []genai.Part {
+	parts := make([]genai.Part, 0, len(m.Images)+len(m.ToolCalls)+len(m.ToolResults)+1)
+	for _, img := range m.Images {
+		parts = append(parts, genai.Blob{MIMEType: img.MIMEType, Data: img.Data})
+	}
+	for _, call := range m.ToolCalls {
+		parts = append(parts, genai.FunctionCall{Name: call.Name, Args: call.Args})
+	}
+	for _, result := range m.ToolResults {
+		key := "result"
+		if result.IsError {
+			key = "error"
+		}
+		parts = append(parts, genai.FunctionResponse{Name: result.Name, Response: map[string]any{key: result.Content}})
+	}
+	if m.Text != "" || len(parts) == 0 {
+		parts = append(parts, genai.Text(m.Text))
+	}
+	return parts
+}
+
+// newModel はリクエストの設定を反映したモデルを作成します。
+// GenerativeModel の設定は共有すると競合するため、リクエストごとに作成します。
+func (gc *GeminiClient) newModel(req *Request) *genai.GenerativeModel {
+	model := gc.client.GenerativeModel(gc.requestModel(req))
+	model.Temperature = req.Params.Temperature
+	model.TopP = req.Params.TopP
+	model.TopK = req.Params.TopK
+	model.MaxOutputTokens = req.Params.MaxOutputTokens
+	if req.System != "" {
+		model.SystemInstruction = &genai.Content{Parts: []genai.Part{genai.Text(req.System)}}
+	}
+	model.SafetySettings = gc.safetySettings
+	if req.ResponseSchema != nil {
+		model.ResponseMIMEType = "application/json"
+		model.ResponseSchema = req.ResponseSchema.toGenai()
+	}
+	if len(req.Tools) > 0 {
+		decls := make([]*genai.FunctionDeclaration, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			decls = append(decls, &genai.FunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters.toGenai(),
+			})
+		}
+		model.Tools = []*genai.Tool{{FunctionDeclarations: decls}}
+	}
+	return model
+}
+
+// requestModel はリクエストで指定されたモデル名、未指定の場合は既定のモデル名を返します。
+func (gc *GeminiClient) requestModel(req *Request) string {
+	if req.Model != "" {
+		return strings.TrimPrefix(req.Model, "models/")
+	}
+	return gc.modelName
+}
+
+// ListModels はコンテンツ生成に対応したGeminiモデルの一覧を返します。
+func (gc *GeminiClient) ListModels(ctx context.Context) ([]string, error) {
+	var models []string
+	iter := gc.client.ListModels(ctx)
+	for {
+		info, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return models, fmt.Errorf("failed to list models: %w", err)
+		}
+		for _, method := range info.SupportedGenerationMethods {
+			if method == "generateContent" {
+				models = append(models, strings.TrimPrefix(info.Name, "models/"))
+				break
+			}
+		}
+	}
+	return models, nil
 }
 
-// Generate は指定されたプロンプトに基づいてAIコンテンツを生成します。
-func (gc *GeminiClient) Generate(prompt string) (string, error) {
+// EmbeddingModel は埋め込みに使用するモデル名を返します。
+func (gc *GeminiClient) EmbeddingModel() string {
+	if gc.embeddingModel == "" {
+		return defaultGeminiEmbeddingModel
+	}
+	return gc.embeddingModel
+}
+
+// Embed はGemini APIの埋め込みモデルで、テキストの埋め込みベクトルをまとめて計算します。
+func (gc *GeminiClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
 	if gc.client == nil {
-		var emptyString string = ""
-		return emptyString, fmt.Errorf("Gemini client is not initialized")
+		return nil, fmt.Errorf("Gemini client is not initialized")
+	}
+	em := gc.client.EmbeddingModel(gc.EmbeddingModel())
+	batch := em.NewBatch()
+	for _, t := range texts {
+		batch.AddContent(genai.Text(t))
 	}
-	log.Printf("Sending prompt to Gemini: \n%s\n", prompt)
-	resp, err := gc.client.GenerateContent(gc.ctx, genai.Text(prompt))
+	resp, err := em.BatchEmbedContents(ctx, batch)
 	if err != nil {
-		var emptyString string = ""
-		return emptyString, fmt.Errorf("failed to generate content: %w", err)
+		return nil, fmt.Errorf("failed to embed contents: %w", err)
 	}
+	vectors := make([][]float32, 0, len(resp.Embeddings))
+	for _, e := range resp.Embeddings {
+		vectors = append(vectors, e.Values)
+	}
+	return vectors, nil
+}
 
+// CountTokens はシステム指示と会話履歴を含むリクエストのトークン数をGemini APIで数えます。
+// Generate と同じく、メッセージをロール付きの会話として、システム指示を別に送信して数えます。
+func (gc *GeminiClient) CountTokens(ctx context.Context, req *Request) (int, error) {
+	if gc.client == nil || gc.generative == nil {
+		return 0, fmt.Errorf("Gemini client is not initialized")
+	}
+	if len(req.Messages) == 0 {
+		return 0, nil
+	}
+	model := "models/" + gc.requestModel(req)
+	generateReq := &pb.GenerateContentRequest{Model: model}
+	for _, m := range req.Messages {
+		content, err := geminiContentProto(&genai.Content{Role: geminiRole(m.Role), Parts: geminiParts(m)})
+		if err != nil {
+			return 0, fmt.Errorf("failed to count tokens: %w", err)
+		}
+		generateReq.Contents = append(generateReq.Contents, content)
+	}
+	if req.System != "" {
+		generateReq.SystemInstruction = &pb.Content{Parts: []*pb.Part{{Data: &pb.Part_Text{Text: req.System}}}}
+	}
+	resp, err := gc.generative.CountTokens(ctx, &pb.CountTokensRequest{Model: model, GenerateContentRequest: generateReq})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// geminiContentProto は genai のContentをAPIのリクエストの形式に変換します。
+func geminiContentProto(c *genai.Content) (*pb.Content, error) {
+	content := &pb.Content{Role: c.Role, Parts: make([]*pb.Part, 0, len(c.Parts))}
+	for _, part := range c.Parts {
+		var pbPart *pb.Part
+		switch p := part.(type) {
+		case genai.Text:
+			pbPart = &pb.Part{Data: &pb.Part_Text{Text: string(p)}}
+		case genai.Blob:
+			pbPart = &pb.Part{Data: &pb.Part_InlineData{InlineData: &pb.Blob{MimeType: p.MIMEType, Data: p.Data}}}
+		case genai.FunctionCall:
+			args, err := structpb.NewStruct(p.Args)
+			if err != nil {
+				return nil, fmt.Errorf("invalid arguments for %s: %w", p.Name, err)
+			}
+			pbPart = &pb.Part{Data: &pb.Part_FunctionCall{FunctionCall: &pb.FunctionCall{Name: p.Name, Args: args}}}
+		case genai.FunctionResponse:
+			response, err := structpb.NewStruct(p.Response)
+			if err != nil {
+				return nil, fmt.Errorf("invalid response of %s: %w", p.Name, err)
+			}
+			pbPart = &pb.Part{Data: &pb.Part_FunctionResponse{FunctionResponse: &pb.FunctionResponse{Name: p.Name, Response: response}}}
+		default:
+			return nil, fmt.Errorf("unsupported part type %T", part)
+		}
+		content.Parts = append(content.Parts, pbPart)
+	}
+	return content, nil
+}
+
+// geminiRole は Role をGemini APIのロール名に変換します。
+func geminiRole(role Role) string {
+	if role == RoleModel {
+		return "model"
+	}
+	return "user"
+}
+
+// geminiUsage はレスポンスの UsageMetadata からトークン数を取り出します。
+func geminiUsage(resp *genai.GenerateContentResponse) Usage {
+	if resp == nil || resp.UsageMetadata == nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:   int(resp.UsageMetadata.PromptTokenCount),
+		ResponseTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+	}
+}
+
+// extractGeminiText はレスポンスの全候補からテキストパートを連結して返します。
+func extractGeminiText(resp *genai.GenerateContentResponse) string {
+	if resp == nil {
+		return ""
+	}
 	var answer string
 	for _, cand := range resp.Candidates {
 		if cand.Content != nil {
@@ -52,9 +387,23 @@ func (gc *GeminiClient) Generate(prompt string) (string, error) {
 			}
 		}
 	}
-	if answer == "" {
-		log.Println("Gemini API returned an empty answer.")
-		return "", nil
+	return answer
+}
+
+// extractGeminiToolCalls はレスポンスの全候補から関数呼び出しパートを取り出します。
+func extractGeminiToolCalls(resp *genai.GenerateContentResponse) []ToolCall {
+	if resp == nil {
+		return nil
+	}
+	var calls []ToolCall
+	for _, cand := range resp.Candidates {
+		if cand.Content != nil {
+			for _, part := range cand.Content.Parts {
+				if fc, ok := part.(genai.FunctionCall); ok {
+					calls = append(calls, ToolCall{Name: fc.Name, Args: fc.Args})
+				}
+			}
+		}
 	}
-	return answer, nil
+	return calls
 }
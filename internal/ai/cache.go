@@ -0,0 +1,259 @@
+package ai_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"AI-Dialogue-Map/internal/config"
+)
+
+type cacheDisabledKey struct{}
+
+// WithCacheDisabled は ctx を使うリクエストで応答キャッシュを使わないようにします。
+// キャッシュを読まずに必ずプロバイダに問い合わせ、新しい応答でキャッシュを更新します。
+func WithCacheDisabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheDisabledKey{}, true)
+}
+
+func cacheDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(cacheDisabledKey{}).(bool)
+	return disabled
+}
+
+// cacheEntry はキャッシュファイル1つ分の内容です。
+type cacheEntry struct {
+	CreatedAt time.Time `json:"created_at"`
+	Text      string    `json:"text"`
+	Model     string    `json:"model,omitempty"`
+	// FinishReason は最大出力トークン数による打ち切りなどを、キャッシュから返す応答でも表示するために保存します。
+	FinishReason FinishReason `json:"finish_reason,omitempty"`
+	// SafetyRatings は安全性の注意表示を、キャッシュから返す応答でも表示するために保存します。
+	SafetyRatings []SafetyRating `json:"safety_ratings,omitempty"`
+}
+
+// CachingProvider はモデル、生成パラメータ、安全性フィルタの設定、組み立て済みのプロンプト全体をキーに、応答をディスクにキャッシュします。
+// 同じ祖先の経路で同じ質問を再送・再生成した場合に、同一のリクエストを送らずに済みます。
+type CachingProvider struct {
+	inner    Provider
+	dir      string
+	ttl      time.Duration // 0以下の場合は期限なし
+	maxBytes int64         // 0以下の場合は制限なし
+
+	mu sync.Mutex // protects the cache directory
+}
+
+// NewCachingProvider は新しいCachingProviderを作成します。
+func NewCachingProvider(inner Provider, dir string, ttl time.Duration, maxBytes int64) *CachingProvider {
+	return &CachingProvider{inner: inner, dir: dir, ttl: ttl, maxBytes: maxBytes}
+}
+
+// newCachingProvider は設定で応答キャッシュが有効な場合に、プロバイダをCachingProviderで包みます。
+func newCachingProvider(cfg config.Config, inner Provider) Provider {
+	if !cfg.ResponseCache {
+		return inner
+	}
+	ttl := time.Duration(cfg.ResponseCacheTTLHours * float64(time.Hour))
+	return NewCachingProvider(inner, cfg.ResponseCacheDir, ttl, int64(cfg.ResponseCacheMaxMB)*1024*1024)
+}
+
+// ModelInfo は内側のプロバイダの情報を返します。
+func (cp *CachingProvider) ModelInfo() ModelInfo {
+	return cp.inner.ModelInfo()
+}
+
+// ListModels は内側のプロバイダがモデル一覧に対応していればそれを返します。
+func (cp *CachingProvider) ListModels(ctx context.Context) ([]string, error) {
+	lister, ok := cp.inner.(ModelLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.ListModels(ctx)
+}
+
+// EmbeddingModel は内側のプロバイダの埋め込みモデル名を返します。
+func (cp *CachingProvider) EmbeddingModel() string {
+	return EmbeddingModelOf(cp.inner)
+}
+
+// Embed は内側のプロバイダで埋め込みベクトルを計算します。対応していない場合は ErrEmbeddingUnsupported を返します。
+func (cp *CachingProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embedder, ok := cp.inner.(Embedder)
+	if !ok {
+		return nil, ErrEmbeddingUnsupported
+	}
+	return embedder.Embed(ctx, texts)
+}
+
+// CountTokens は内側のプロバイダでトークン数を数えます。対応していない場合は ErrTokenCountUnsupported を返します。
+func (cp *CachingProvider) CountTokens(ctx context.Context, req *Request) (int, error) {
+	tc, ok := cp.inner.(TokenCounter)
+	if !ok {
+		return 0, ErrTokenCountUnsupported
+	}
+	return tc.CountTokens(ctx, req)
+}
+
+// Generate はキャッシュされた応答があればそれを返し、なければ内側のプロバイダで生成してキャッシュします。
+func (cp *CachingProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	key := cp.key(req)
+	if resp := cp.lookup(ctx, key); resp != nil {
+		return resp, nil
+	}
+	resp, err := cp.inner.Generate(ctx, req)
+	if err == nil {
+		cp.store(key, resp)
+	}
+	return resp, err
+}
+
+// GenerateStream はキャッシュされた応答があれば1つのチャンクとして返し、なければ内側のプロバイダで生成してキャッシュします。
+func (cp *CachingProvider) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	key := cp.key(req)
+	if resp := cp.lookup(ctx, key); resp != nil {
+		if onChunk != nil {
+			onChunk(resp.Text)
+		}
+		return resp, nil
+	}
+	resp, err := cp.inner.GenerateStream(ctx, req, onChunk)
+	if err == nil {
+		cp.store(key, resp)
+	}
+	return resp, err
+}
+
+// key はプロバイダ、実際に使うモデル、安全性フィルタの設定、リクエスト全体からキャッシュのキーを作成します。
+// 安全性フィルタのしきい値を変えると、以前の設定で生成した応答は使いません。エンコードできない場合は空文字列です。
+func (cp *CachingProvider) key(req *Request) string {
+	info := cp.inner.ModelInfo()
+	model := info.Model
+	if req != nil && req.Model != "" {
+		model = req.Model
+	}
+	// 既存のキャッシュのキーが変わらないよう、安全性フィルタが既定の場合はJSONに含めません。
+	data, err := json.Marshal(struct {
+		Provider string
+		Model    string
+		Safety   []string `json:",omitempty"`
+		Request  *Request
+	}{info.Provider, model, SafetyConfigOf(cp.inner), req})
+	if err != nil {
+		log.Printf("Response cache: failed to encode request, bypassing cache: %v", err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (cp *CachingProvider) path(key string) string {
+	return filepath.Join(cp.dir, key+".json")
+}
+
+// lookup は有効期限内のキャッシュされた応答を返します。ない場合は nil です。
+// キャッシュから返した応答はトークンを消費していないため、Usage はゼロです。
+func (cp *CachingProvider) lookup(ctx context.Context, key string) *Response {
+	if key == "" || cacheDisabled(ctx) {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	data, err := os.ReadFile(cp.path(key))
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Printf("Response cache: discarding unreadable entry %s: %v", key, err)
+		os.Remove(cp.path(key))
+		return nil
+	}
+	if cp.ttl > 0 && time.Since(entry.CreatedAt) > cp.ttl {
+		os.Remove(cp.path(key))
+		return nil
+	}
+	log.Printf("Response cache hit (%s)", key[:12])
+	return &Response{Text: entry.Text, Model: entry.Model, FinishReason: entry.FinishReason, SafetyRatings: entry.SafetyRatings, CacheHit: true}
+}
+
+// store は応答をキャッシュに書き込み、サイズの上限を超えた場合は古いエントリから削除します。
+// キャッシュへの書き込みに失敗しても応答は返せるため、エラーはログに記録するだけです。
+func (cp *CachingProvider) store(key string, resp *Response) {
+	// ツール呼び出しを含む応答は、ツールの実行結果に応じて続きが変わるためキャッシュしません。
+	if key == "" || resp == nil || strings.TrimSpace(resp.Text) == "" || len(resp.ToolCalls) > 0 {
+		return
+	}
+	data, err := json.Marshal(cacheEntry{CreatedAt: time.Now(), Text: resp.Text, Model: resp.Model, FinishReason: resp.FinishReason, SafetyRatings: resp.SafetyRatings})
+	if err != nil {
+		log.Printf("Response cache: failed to encode response: %v", err)
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if err := os.MkdirAll(cp.dir, 0755); err != nil {
+		log.Printf("Response cache: failed to create %s: %v", cp.dir, err)
+		return
+	}
+	if err := os.WriteFile(cp.path(key), data, 0644); err != nil {
+		log.Printf("Response cache: failed to write entry: %v", err)
+		return
+	}
+	if err := cp.pruneLocked(); err != nil {
+		log.Printf("Response cache: failed to prune %s: %v", cp.dir, err)
+	}
+}
+
+// pruneLocked は期限切れのエントリを削除し、合計サイズが上限を超える場合は古いエントリから削除します。
+// mu を保持した状態で呼び出します。
+func (cp *CachingProvider) pruneLocked() error {
+	entries, err := os.ReadDir(cp.dir)
+	if err != nil {
+		return err
+	}
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cacheFile
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(cp.dir, e.Name())
+		if cp.ttl > 0 && time.Since(info.ModTime()) > cp.ttl {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	if cp.maxBytes <= 0 || total <= cp.maxBytes {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= cp.maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", f.path, err)
+		}
+		total -= f.size
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package ai_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeProvider は呼び出し回数を数え、決まった応答を返すテスト用のプロバイダです。
+type fakeProvider struct {
+	calls  int
+	resp   Response
+	safety []string
+}
+
+func (p *fakeProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	p.calls++
+	resp := p.resp
+	return &resp, nil
+}
+
+func (p *fakeProvider) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	resp, err := p.Generate(ctx, req)
+	if onChunk != nil {
+		onChunk(resp.Text)
+	}
+	return resp, err
+}
+
+func (p *fakeProvider) ModelInfo() ModelInfo {
+	return ModelInfo{Provider: "fake", Model: "fake-model"}
+}
+
+func (p *fakeProvider) SafetyConfig() []string {
+	return p.safety
+}
+
+func TestCacheKeyIncludesSafetyConfig(t *testing.T) {
+	inner := &fakeProvider{resp: Response{Text: "回答", Model: "fake-model", FinishReason: FinishReasonStop}}
+	cp := NewCachingProvider(inner, t.TempDir(), 0, 0)
+	req := userRequest("質問")
+
+	// 安全性フィルタが既定の場合、キーは安全性の設定を含めずに作成します。
+	data, _ := json.Marshal(struct {
+		Provider string
+		Model    string
+		Request  *Request
+	}{"fake", "fake-model", req})
+	sum := sha256.Sum256(data)
+	if got, want := cp.key(req), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("key without safety settings = %s, want %s", got, want)
+	}
+
+	if _, err := cp.Generate(context.Background(), req); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	inner.safety = []string{"harassment=3"}
+	resp, err := cp.Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.CacheHit || inner.calls != 2 {
+		t.Errorf("changed safety settings should bypass the old entry (CacheHit=%v, calls=%d)", resp.CacheHit, inner.calls)
+	}
+	if resp, _ := cp.Generate(context.Background(), req); !resp.CacheHit || inner.calls != 2 {
+		t.Errorf("same safety settings should hit the cache (CacheHit=%v, calls=%d)", resp.CacheHit, inner.calls)
+	}
+}
+
+func TestCacheKeepsSafetyRatings(t *testing.T) {
+	ratings := []SafetyRating{{Category: "harassment", Probability: "medium"}}
+	inner := &fakeProvider{resp: Response{Text: "途中まで", Model: "fake-model", FinishReason: FinishReasonMaxTokens, SafetyRatings: ratings}}
+	cp := NewCachingProvider(inner, t.TempDir(), 0, 0)
+
+	if _, err := cp.GenerateStream(context.Background(), userRequest("質問"), nil); err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	resp, err := cp.GenerateStream(context.Background(), userRequest("質問"), nil)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if !resp.CacheHit || inner.calls != 1 {
+		t.Fatalf("second request should hit the cache (CacheHit=%v, calls=%d)", resp.CacheHit, inner.calls)
+	}
+	if resp.FinishReason != FinishReasonMaxTokens || !reflect.DeepEqual(resp.SafetyRatings, ratings) {
+		t.Errorf("cached response = %+v, want the finish reason and safety ratings", resp)
+	}
+}
+
+// cacheFiles はキャッシュディレクトリのエントリ数を返します。
+func cacheFiles(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestCacheHit(t *testing.T) {
+	inner := &fakeProvider{resp: Response{Text: "回答", Model: "fake-model", Usage: Usage{PromptTokens: 10, ResponseTokens: 5}}}
+	cp := NewCachingProvider(inner, t.TempDir(), 0, 0)
+
+	first, err := cp.GenerateStream(context.Background(), userRequest("質問"), nil)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if first.CacheHit {
+		t.Error("first response should not be a cache hit")
+	}
+	var chunks []string
+	second, err := cp.GenerateStream(context.Background(), userRequest("質問"), func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if !second.CacheHit || inner.calls != 1 {
+		t.Errorf("second response should come from the cache (CacheHit=%v, calls=%d)", second.CacheHit, inner.calls)
+	}
+	if second.Text != "回答" || second.Model != "fake-model" || second.Usage != (Usage{}) {
+		t.Errorf("cached response = %+v, want the text and model without usage", second)
+	}
+	if !reflect.DeepEqual(chunks, []string{"回答"}) {
+		t.Errorf("chunks = %q, want the whole answer as one chunk", chunks)
+	}
+
+	other := userRequest("質問")
+	other.Params.Temperature = new(float32)
+	if resp, _ := cp.Generate(context.Background(), other); resp.CacheHit || inner.calls != 2 {
+		t.Errorf("different generation parameters should not hit the cache (CacheHit=%v, calls=%d)", resp.CacheHit, inner.calls)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	inner := &fakeProvider{resp: Response{Text: "回答"}}
+	cp := NewCachingProvider(inner, t.TempDir(), 0, 0)
+	disabled := WithCacheDisabled(context.Background())
+
+	for i := 0; i < 2; i++ {
+		resp, err := cp.Generate(disabled, userRequest("質問"))
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if resp.CacheHit {
+			t.Error("WithCacheDisabled should not read the cache")
+		}
+	}
+	if inner.calls != 2 {
+		t.Errorf("calls = %d, want 2", inner.calls)
+	}
+	// キャッシュを使わなかったリクエストの応答でもキャッシュは更新されます。
+	if resp, _ := cp.Generate(context.Background(), userRequest("質問")); !resp.CacheHit || inner.calls != 2 {
+		t.Errorf("response should have been stored (CacheHit=%v, calls=%d)", resp.CacheHit, inner.calls)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	inner := &fakeProvider{resp: Response{Text: "回答"}}
+	dir := t.TempDir()
+	cp := NewCachingProvider(inner, dir, time.Hour, 0)
+	req := userRequest("質問")
+
+	if _, err := cp.Generate(context.Background(), req); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp, _ := cp.Generate(context.Background(), req); !resp.CacheHit {
+		t.Fatal("fresh entry should hit the cache")
+	}
+
+	// エントリの作成日時を有効期限より前にします。
+	path := cp.path(cp.key(req))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.CreatedAt = time.Now().Add(-2 * time.Hour)
+	data, _ = json.Marshal(entry)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp, _ := cp.Generate(context.Background(), req); resp.CacheHit || inner.calls != 2 {
+		t.Errorf("expired entry should not be used (CacheHit=%v, calls=%d)", resp.CacheHit, inner.calls)
+	}
+	if resp, _ := cp.Generate(context.Background(), req); !resp.CacheHit || inner.calls != 2 {
+		t.Errorf("refreshed entry should hit the cache (CacheHit=%v, calls=%d)", resp.CacheHit, inner.calls)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	inner := &fakeProvider{resp: Response{Text: "回答"}}
+	dir := t.TempDir()
+	cp := NewCachingProvider(inner, dir, time.Hour, 0)
+
+	oldest, older, expired := userRequest("q1"), userRequest("q2"), userRequest("q3")
+	for _, req := range []*Request{oldest, older, expired} {
+		if _, err := cp.Generate(context.Background(), req); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+	}
+	now := time.Now()
+	for req, age := range map[*Request]time.Duration{oldest: 30 * time.Minute, older: 20 * time.Minute, expired: 2 * time.Hour} {
+		at := now.Add(-age)
+		if err := os.Chtimes(cp.path(cp.key(req)), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(cp.path(cp.key(oldest)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2エントリ分だけ入る上限にすると、期限切れのエントリと最も古いエントリが削除されます。
+	cp.maxBytes = info.Size()*2 + info.Size()/2
+	newest := userRequest("q4")
+	if _, err := cp.Generate(context.Background(), newest); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	if n := cacheFiles(t, dir); n != 2 {
+		t.Errorf("%d cache files remain, want 2", n)
+	}
+	for req, want := range map[*Request]bool{oldest: false, older: true, expired: false, newest: true} {
+		_, err := os.Stat(cp.path(cp.key(req)))
+		if exists := err == nil; exists != want {
+			t.Errorf("entry for %q exists = %v, want %v", req.Messages[0].Text, exists, want)
+		}
+	}
+}
+
+func TestCacheSkipsToolCallsAndEmptyAnswers(t *testing.T) {
+	tests := []struct {
+		name string
+		resp Response
+	}{
+		{name: "tool calls", resp: Response{Text: "調べます", ToolCalls: []ToolCall{{Name: "calculate", Args: map[string]interface{}{"expression": "1+1"}}}}},
+		{name: "empty", resp: Response{Text: "  \n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeProvider{resp: tt.resp}
+			dir := t.TempDir()
+			cp := NewCachingProvider(inner, dir, 0, 0)
+			for i := 0; i < 2; i++ {
+				resp, err := cp.Generate(context.Background(), userRequest("1+1は?"))
+				if err != nil {
+					t.Fatalf("Generate: %v", err)
+				}
+				if resp.CacheHit {
+					t.Error("response should not come from the cache")
+				}
+			}
+			if inner.calls != 2 {
+				t.Errorf("calls = %d, want 2", inner.calls)
+			}
+			if n := cacheFiles(t, dir); n != 0 {
+				t.Errorf("%d cache files written, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package ai_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// embedBatchSize は1回のリクエストで埋め込むテキスト数の上限です (Gemini APIの上限は100件)。
+const embedBatchSize = 64
+
+// Embedder はテキストの埋め込みベクトルを計算できるプロバイダが実装します。
+type Embedder interface {
+	// Embed は texts の各テキストの埋め込みベクトルを、同じ順序で返します。
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// EmbeddingModel は埋め込みに使用するモデル名を返します。
+	EmbeddingModel() string
+}
+
+// ErrEmbeddingUnsupported はプロバイダが埋め込みに対応していないことを表します。
+var ErrEmbeddingUnsupported = errors.New("embeddings are not supported by this provider")
+
+// EmbedTexts はプロバイダでテキストの埋め込みベクトルを計算します。件数が多い場合は分割して送信します。
+// プロバイダが Embedder を実装していない場合は ErrEmbeddingUnsupported を返します。
+func EmbedTexts(ctx context.Context, p Provider, texts []string) ([][]float32, error) {
+	embedder, ok := p.(Embedder)
+	if !ok {
+		return nil, ErrEmbeddingUnsupported
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := embedder.Embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", end-start, len(batch))
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// EmbeddingModelOf はプロバイダの埋め込みモデル名を返します。埋め込みに対応していない場合は空文字列です。
+func EmbeddingModelOf(p Provider) string {
+	if embedder, ok := p.(Embedder); ok {
+		return embedder.EmbeddingModel()
+	}
+	return ""
+}
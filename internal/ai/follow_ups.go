@@ -0,0 +1,55 @@
+package ai_client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// followUpsSchema はフォローアップ質問の提案で要求する構造化出力のスキーマです。
+var followUpsSchema = &Schema{
+	Type: SchemaObject,
+	Properties: map[string]*Schema{
+		"questions": {
+			Type:        SchemaArray,
+			Description: "会話の続きとしてユーザーが次に尋ねると有益な質問",
+			Items:       &Schema{Type: SchemaString},
+		},
+	},
+	Required: []string{"questions"},
+}
+
+// followUpsResponse はフォローアップ質問の提案の構造化出力です。
+type followUpsResponse struct {
+	Questions []string `json:"questions"`
+}
+
+// SuggestFollowUps は会話履歴に続けてユーザーが尋ねるとよいフォローアップ質問を count 件まで提案します。
+// history は古い順のユーザー/モデルのメッセージで、最後は回答済みのモデルのメッセージです。
+func SuggestFollowUps(ctx context.Context, p Provider, system string, history []Message, count int) ([]string, error) {
+	prompt := fmt.Sprintf("ここまでの会話を踏まえて、ユーザーが次に尋ねると理解が深まる、互いに異なる観点のフォローアップ質問を%d個提案してください。"+
+		"各質問はそのまま送信できる1文の質問にしてください。", count)
+	messages := append(append([]Message{}, history...), Message{Role: RoleUser, Text: prompt})
+	resp, err := p.Generate(ctx, &Request{
+		System:         system,
+		Messages:       messages,
+		ResponseSchema: followUpsSchema,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest follow-up questions: %w", err)
+	}
+	var parsed followUpsResponse
+	if err := DecodeJSONResponse(resp.Text, &parsed); err != nil {
+		return nil, err
+	}
+	questions := make([]string, 0, count)
+	for _, q := range parsed.Questions {
+		if q = strings.TrimSpace(q); q != "" && len(questions) < count {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		return nil, fmt.Errorf("model returned no follow-up questions")
+	}
+	return questions, nil
+}
@@ -0,0 +1,367 @@
+package ai_client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultOllamaHost はホストが未設定の場合に接続するローカルのOllamaデーモンです。
+const defaultOllamaHost = "http://localhost:11434"
+
+// OllamaClient はローカルのOllamaデーモン (/api/chat) との連携を担当します。
+// ネットワークに接続できない環境でも対話ツリーを作成できます。
+type OllamaClient struct {
+	httpClient *http.Client
+	host       string
+
+	modelMutex sync.Mutex // protects modelName
+	modelName  string
+
+	embeddingModel string
+}
+
+// NewOllamaClient は新しいOllamaClientのインスタンスを作成します。
+// modelName が空の場合は、最初のリクエスト時に /api/tags の先頭のモデルを使用します。
+func NewOllamaClient(host string, modelName string) (*OllamaClient, error) {
+	if host == "" {
+		host = defaultOllamaHost
+	}
+	return &OllamaClient{
+		httpClient: &http.Client{},
+		host:       strings.TrimRight(host, "/"),
+		modelName:  modelName,
+	}, nil
+}
+
+// ModelInfo は使用中のモデル情報を返します。
+func (oc *OllamaClient) ModelInfo() ModelInfo {
+	oc.modelMutex.Lock()
+	defer oc.modelMutex.Unlock()
+	return ModelInfo{Provider: ProviderOllama, Model: oc.modelName}
+}
+
+// ollamaMessage は /api/chat のメッセージ形式です。Images はbase64エンコードした画像です。
+// ツールの実行結果は tool ロールのメッセージとして、ToolName で呼び出したツールを示します。
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// ollamaToolCall はモデルが要求した関数呼び出しです。OpenAI互換APIと異なり、Arguments はJSONオブジェクトです。
+type ollamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaChatRequest は /api/chat のリクエストボディです。
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   *Schema         `json:"format,omitempty"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    []functionTool  `json:"tools,omitempty"`
+}
+
+// ollamaOptions は /api/chat の生成パラメータです。
+type ollamaOptions struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	TopK        *int32   `json:"top_k,omitempty"`
+	NumPredict  *int32   `json:"num_predict,omitempty"`
+}
+
+// ollamaChatResponse は /api/chat の応答です。ストリーミング時は1行ごとに返されます。
+type ollamaChatResponse struct {
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	Error      string        `json:"error"`
+
+	// 最後の応答 (done=true) に含まれるトークン数です。
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+// ollamaTagsResponse は /api/tags の応答です。
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// ListModels はローカルにインストールされているモデルの一覧を返します。
+func (oc *OllamaClient) ListModels(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oc.host+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := oc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama at %s: %w", oc.host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newAPIError("/api/tags", resp, msg)
+	}
+
+	var tags ollamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode model list: %w", err)
+	}
+	models := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
+
+// defaultOllamaEmbeddingModel は埋め込みモデルが未設定の場合に使用するモデルです (ollama pull nomic-embed-text)。
+const defaultOllamaEmbeddingModel = "nomic-embed-text"
+
+// ollamaEmbedRequest は /api/embed のリクエストです。
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// ollamaEmbedResponse は /api/embed の応答です。
+type ollamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// EmbeddingModel は埋め込みに使用するモデル名を返します。
+func (oc *OllamaClient) EmbeddingModel() string {
+	if oc.embeddingModel == "" {
+		return defaultOllamaEmbeddingModel
+	}
+	return oc.embeddingModel
+}
+
+// Embed はローカルの埋め込みモデル (/api/embed) でテキストの埋め込みベクトルをまとめて計算します。
+func (oc *OllamaClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(ollamaEmbedRequest{Model: oc.EmbeddingModel(), Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oc.host+"/api/embed", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := oc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama at %s: %w", oc.host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newAPIError("/api/embed", resp, msg)
+	}
+
+	var parsed ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	return parsed.Embeddings, nil
+}
+
+// resolveModel は使用するモデル名を返します。未設定の場合はインストール済みの先頭のモデルを選びます。
+func (oc *OllamaClient) resolveModel(ctx context.Context) (string, error) {
+	oc.modelMutex.Lock()
+	name := oc.modelName
+	oc.modelMutex.Unlock()
+	if name != "" {
+		return name, nil
+	}
+
+	models, err := oc.ListModels(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(models) == 0 {
+		return "", fmt.Errorf("no models are installed in Ollama (run `ollama pull <model>`)")
+	}
+	oc.modelMutex.Lock()
+	oc.modelName = models[0]
+	oc.modelMutex.Unlock()
+	log.Printf("Ollama model not configured, using %s", models[0])
+	return models[0], nil
+}
+
+// Generate は会話履歴を含むリクエストに基づいてAIコンテンツを生成します。
+func (oc *OllamaClient) Generate(ctx context.Context, req *Request) (*Response, error) {
+	return oc.chat(ctx, req, false, nil)
+}
+
+// GenerateStream は応答をストリーミングで生成し、チャンクごとに onChunk を呼び出します。
+func (oc *OllamaClient) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	return oc.chat(ctx, req, true, onChunk)
+}
+
+// chat は /api/chat を呼び出します。stream が true の場合はNDJSONを1行ずつ読み取ります。
+func (oc *OllamaClient) chat(ctx context.Context, chatReq *Request, stream bool, onChunk func(chunk string)) (*Response, error) {
+	if _, _, err := splitLastUserMessage(chatReq); err != nil {
+		return nil, err
+	}
+	modelName := chatReq.Model
+	if modelName == "" {
+		resolved, err := oc.resolveModel(ctx)
+		if err != nil {
+			return nil, err
+		}
+		modelName = resolved
+	}
+	messages := make([]ollamaMessage, 0, len(chatReq.Messages)+1)
+	if chatReq.System != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: chatReq.System})
+	}
+	for _, m := range chatReq.Messages {
+		messages = append(messages, ollamaMessages(m)...)
+	}
+	log.Printf("Sending chat request to Ollama (%s, %s, %d messages)", oc.host, modelName, len(messages))
+
+	payload, err := json.Marshal(ollamaChatRequest{
+		Model:    modelName,
+		Messages: messages,
+		Stream:   stream,
+		Format:   chatReq.ResponseSchema,
+		Options:  newOllamaOptions(chatReq.Params),
+		Tools:    functionTools(chatReq.Tools),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oc.host+"/api/chat", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := oc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama at %s: %w", oc.host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newAPIError("/api/chat", resp, msg)
+	}
+
+	var answer string
+	var usage Usage
+	var finishReason FinishReason
+	var toolCalls []ToolCall
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return &Response{Text: answer, Model: modelName}, fmt.Errorf("failed to decode chat response: %w", err)
+		}
+		if chunk.Error != "" {
+			return &Response{Text: answer, Model: modelName}, fmt.Errorf("Ollama returned an error: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			answer += chunk.Message.Content
+			if onChunk != nil {
+				onChunk(chunk.Message.Content)
+			}
+		}
+		for _, call := range chunk.Message.ToolCalls {
+			toolCalls = append(toolCalls, ToolCall{Name: call.Function.Name, Args: call.Function.Arguments})
+		}
+		if chunk.Done {
+			usage = Usage{PromptTokens: chunk.PromptEvalCount, ResponseTokens: chunk.EvalCount}
+			finishReason = ollamaFinishReason(chunk.DoneReason)
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &Response{Text: answer, Model: modelName}, fmt.Errorf("failed to read chat response: %w", err)
+	}
+	if answer == "" && len(toolCalls) == 0 {
+		log.Println("Ollama returned an empty answer.")
+	}
+	return &Response{Text: answer, Model: modelName, Usage: usage, ToolCalls: toolCalls, FinishReason: finishReason}, nil
+}
+
+// ollamaMessages は Message を /api/chat のメッセージに変換します。
+// ツールの実行結果は、結果ごとにツール名を付けた tool ロールのメッセージにします。
+func ollamaMessages(m Message) []ollamaMessage {
+	if m.Role == RoleTool {
+		messages := make([]ollamaMessage, 0, len(m.ToolResults))
+		for _, result := range m.ToolResults {
+			messages = append(messages, ollamaMessage{Role: ollamaRole(RoleTool), Content: result.Content, ToolName: result.Name})
+		}
+		return messages
+	}
+	msg := ollamaMessage{Role: ollamaRole(m.Role), Content: m.Text}
+	for _, img := range m.Images {
+		msg.Images = append(msg.Images, base64.StdEncoding.EncodeToString(img.Data))
+	}
+	for _, call := range m.ToolCalls {
+		var tc ollamaToolCall
+		tc.Function.Name = call.Name
+		tc.Function.Arguments = call.Args
+		msg.ToolCalls = append(msg.ToolCalls, tc)
+	}
+	return []ollamaMessage{msg}
+}
+
+// ollamaFinishReason は done_reason を FinishReason に変換します。
+func ollamaFinishReason(reason string) FinishReason {
+	switch reason {
+	case "":
+		return ""
+	case "stop":
+		return FinishReasonStop
+	case "length":
+		return FinishReasonMaxTokens
+	default:
+		return FinishReasonOther
+	}
+}
+
+// newOllamaOptions は生成パラメータをOllamaの options に変換します。すべて未指定の場合はnilを返します。
+func newOllamaOptions(params GenerationParams) *ollamaOptions {
+	if params.Temperature == nil && params.TopP == nil && params.TopK == nil && params.MaxOutputTokens == nil {
+		return nil
+	}
+	return &ollamaOptions{
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		TopK:        params.TopK,
+		NumPredict:  params.MaxOutputTokens,
+	}
+}
+
+// ollamaRole は Role をOllamaのロール名に変換します。
+func ollamaRole(role Role) string {
+	switch role {
+	case RoleModel:
+		return "assistant"
+	case RoleTool:
+		return "tool"
+	default:
+		return "user"
+	}
+}
@@ -0,0 +1,298 @@
+package ai_client
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestOllamaClient は handler で応答するローカルサーバーに接続するクライアントを作成します。
+func newTestOllamaClient(t *testing.T, modelName string, handler http.HandlerFunc) *OllamaClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	oc, err := NewOllamaClient(server.URL+"/", modelName)
+	if err != nil {
+		t.Fatalf("NewOllamaClient: %v", err)
+	}
+	return oc
+}
+
+func TestOllamaGenerateStream(t *testing.T) {
+	oc := newTestOllamaClient(t, "llama3.2", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("path = %q, want /api/chat", r.URL.Path)
+		}
+		body := decodeChatRequest(t, r)
+		if body["model"] != "llama3.2" {
+			t.Errorf("model = %v, want llama3.2", body["model"])
+		}
+		if body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		options, _ := body["options"].(map[string]interface{})
+		if options["num_predict"] != float64(64) {
+			t.Errorf("options.num_predict = %v, want 64", options["num_predict"])
+		}
+		messages, _ := body["messages"].([]interface{})
+		var roles []string
+		for _, m := range messages {
+			roles = append(roles, m.(map[string]interface{})["role"].(string))
+		}
+		if want := []string{"system", "user", "assistant", "user"}; !reflect.DeepEqual(roles, want) {
+			t.Errorf("roles = %v, want %v", roles, want)
+		}
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hello"},"done":false}`)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":", world"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":9,"eval_count":2}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"after done"},"done":false}`)
+	})
+
+	maxTokens := int32(64)
+	var chunks []string
+	resp, err := oc.GenerateStream(context.Background(), &Request{
+		System: "system",
+		Params: GenerationParams{MaxOutputTokens: &maxTokens},
+		Messages: []Message{
+			{Role: RoleUser, Text: "q1"},
+			{Role: RoleModel, Text: "a1"},
+			{Role: RoleUser, Text: "q2"},
+		},
+	}, func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if !reflect.DeepEqual(chunks, []string{"Hello", ", world"}) {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if resp.Text != "Hello, world" || resp.Model != "llama3.2" {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.Usage != (Usage{PromptTokens: 9, ResponseTokens: 2}) {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+	if resp.FinishReason != FinishReasonMaxTokens {
+		t.Errorf("FinishReason = %q, want %q", resp.FinishReason, FinishReasonMaxTokens)
+	}
+}
+
+func TestOllamaGenerate(t *testing.T) {
+	image := []byte{0x89, 'P', 'N', 'G'}
+	oc := newTestOllamaClient(t, "llama3.2", func(w http.ResponseWriter, r *http.Request) {
+		body := decodeChatRequest(t, r)
+		if body["stream"] != false {
+			t.Errorf("stream = %v, want false", body["stream"])
+		}
+		if body["model"] != "llava" {
+			t.Errorf("model = %v, want the requested llava", body["model"])
+		}
+		if _, ok := body["options"]; ok {
+			t.Errorf("options should be omitted without generation parameters")
+		}
+		messages, _ := body["messages"].([]interface{})
+		images, _ := messages[0].(map[string]interface{})["images"].([]interface{})
+		if len(images) != 1 || images[0] != base64.StdEncoding.EncodeToString(image) {
+			t.Errorf("images = %v", images)
+		}
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"PNG画像です"},"done":true,"done_reason":"stop"}`)
+	})
+
+	resp, err := oc.Generate(context.Background(), &Request{
+		Model:    "llava",
+		Messages: []Message{{Role: RoleUser, Text: "この画像は?", Images: []Image{{MIMEType: "image/png", Data: image}}}},
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Text != "PNG画像です" || resp.Model != "llava" || resp.FinishReason != FinishReasonStop {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOllamaErrors(t *testing.T) {
+	t.Run("status", func(t *testing.T) {
+		oc := newTestOllamaClient(t, "missing", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model \"missing\" not found"}`)
+		})
+		_, err := oc.GenerateStream(context.Background(), userRequest("hi"), nil)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("err = %v, want *APIError", err)
+		}
+		if apiErr.StatusCode != http.StatusNotFound || apiErr.Path != "/api/chat" || !strings.Contains(apiErr.Message, "not found") {
+			t.Errorf("APIError = %+v", apiErr)
+		}
+		if retry, _ := retryableError(err); retry {
+			t.Errorf("404 should not be retried")
+		}
+	})
+	t.Run("overloaded", func(t *testing.T) {
+		oc := newTestOllamaClient(t, "llama3.2", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		_, err := oc.Generate(context.Background(), userRequest("hi"))
+		if retry, _ := retryableError(err); !retry {
+			t.Errorf("err = %v, want a retryable error", err)
+		}
+	})
+	t.Run("stream", func(t *testing.T) {
+		oc := newTestOllamaClient(t, "llama3.2", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"途中"},"done":false}`)
+			fmt.Fprintln(w, `{"error":"out of memory"}`)
+		})
+		resp, err := oc.GenerateStream(context.Background(), userRequest("hi"), nil)
+		if err == nil || !strings.Contains(err.Error(), "out of memory") {
+			t.Fatalf("err = %v, want the streamed error", err)
+		}
+		if resp == nil || resp.Text != "途中" {
+			t.Errorf("partial response = %+v, want text 途中", resp)
+		}
+	})
+	t.Run("invalid line", func(t *testing.T) {
+		oc := newTestOllamaClient(t, "llama3.2", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `not json`)
+		})
+		if _, err := oc.GenerateStream(context.Background(), userRequest("hi"), nil); err == nil {
+			t.Fatal("expected a decode error")
+		}
+	})
+}
+
+func TestOllamaListModels(t *testing.T) {
+	var chatModel string
+	oc := newTestOllamaClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			if r.Method != http.MethodGet {
+				t.Errorf("method = %s, want GET", r.Method)
+			}
+			fmt.Fprint(w, `{"models":[{"name":"llama3.2:latest"},{"name":"qwen2.5:7b"}]}`)
+		case "/api/chat":
+			chatModel, _ = decodeChatRequest(t, r)["model"].(string)
+			fmt.Fprint(w, `{"message":{"role":"assistant","content":"ok"},"done":true,"done_reason":"stop"}`)
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	})
+
+	models, err := oc.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	if want := []string{"llama3.2:latest", "qwen2.5:7b"}; !reflect.DeepEqual(models, want) {
+		t.Errorf("models = %v, want %v", models, want)
+	}
+
+	// モデル未設定の場合は、インストール済みの先頭のモデルを使用します。
+	if _, err := oc.Generate(context.Background(), userRequest("hi")); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if chatModel != "llama3.2:latest" {
+		t.Errorf("chat model = %q, want llama3.2:latest", chatModel)
+	}
+	if info := oc.ModelInfo(); info.Model != "llama3.2:latest" {
+		t.Errorf("ModelInfo = %+v", info)
+	}
+}
+
+func TestOllamaNoModelsInstalled(t *testing.T) {
+	oc := newTestOllamaClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"models":[]}`)
+	})
+	if _, err := oc.Generate(context.Background(), userRequest("hi")); err == nil || !strings.Contains(err.Error(), "ollama pull") {
+		t.Errorf("err = %v, want a hint to pull a model", err)
+	}
+}
+
+func TestOllamaEmbed(t *testing.T) {
+	oc := newTestOllamaClient(t, "llama3.2", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("path = %q, want /api/embed", r.URL.Path)
+		}
+		body := decodeChatRequest(t, r)
+		if body["model"] != defaultOllamaEmbeddingModel {
+			t.Errorf("model = %v, want %s", body["model"], defaultOllamaEmbeddingModel)
+		}
+		if input, _ := body["input"].([]interface{}); len(input) != 2 {
+			t.Errorf("input = %v", body["input"])
+		}
+		fmt.Fprint(w, `{"embeddings":[[0.1,0.2],[0.3,0.4]]}`)
+	})
+
+	vectors, err := oc.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if want := [][]float32{{0.1, 0.2}, {0.3, 0.4}}; !reflect.DeepEqual(vectors, want) {
+		t.Errorf("vectors = %v, want %v", vectors, want)
+	}
+}
+
+func TestOllamaToolCalls(t *testing.T) {
+	round := 0
+	oc := newTestOllamaClient(t, "llama3.2", func(w http.ResponseWriter, r *http.Request) {
+		round++
+		body := decodeChatRequest(t, r)
+		tools, _ := body["tools"].([]interface{})
+		if len(tools) != 1 || tools[0].(map[string]interface{})["type"] != "function" {
+			t.Errorf("tools = %v", body["tools"])
+		}
+		if round == 1 {
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"calculate","arguments":{"expression":"6*7"}}}]},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`)
+			return
+		}
+		messages, _ := body["messages"].([]interface{})
+		if len(messages) != 3 {
+			t.Fatalf("got %d messages in the second round, want 3", len(messages))
+		}
+		calls, _ := messages[1].(map[string]interface{})["tool_calls"].([]interface{})
+		if len(calls) != 1 {
+			t.Fatalf("assistant message = %v, want one tool call", messages[1])
+		}
+		fn := calls[0].(map[string]interface{})["function"].(map[string]interface{})
+		if fn["name"] != "calculate" || !reflect.DeepEqual(fn["arguments"], map[string]interface{}{"expression": "6*7"}) {
+			t.Errorf("tool call function = %v", fn)
+		}
+		result := messages[2].(map[string]interface{})
+		if result["role"] != "tool" || result["tool_name"] != "calculate" || result["content"] != "42" {
+			t.Errorf("tool result message = %v", result)
+		}
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"答えは42です"},"done":true,"done_reason":"stop"}`)
+	})
+
+	req := userRequest("6かける7は?")
+	req.Tools = []*Tool{{
+		Name: "calculate",
+		Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			return "42", nil
+		},
+	}}
+	var observed []ToolCall
+	resp, err := GenerateStreamWithTools(context.Background(), oc, req, nil, func(call ToolCall, result ToolResult) {
+		observed = append(observed, call)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStreamWithTools: %v", err)
+	}
+	if resp.Text != "答えは42です" {
+		t.Errorf("Text = %q", resp.Text)
+	}
+	want := []ToolCall{{Name: "calculate", Args: map[string]interface{}{"expression": "6*7"}}}
+	if !reflect.DeepEqual(observed, want) {
+		t.Errorf("observed calls = %+v, want %+v", observed, want)
+	}
+}
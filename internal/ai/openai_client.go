@@ -0,0 +1,530 @@
+package ai_client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultOpenAIBaseURL はベースURLが未設定の場合に使用するエンドポイントです。
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIClient はOpenAI互換の /chat/completions API との連携を担当します。
+// vLLM、llama.cpp server、LM Studio などOpenAI互換のサーバーに接続できます。
+type OpenAIClient struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+	modelName  string
+
+	embeddingModel string
+}
+
+// NewOpenAIClient は新しいOpenAIClientのインスタンスを作成します。
+// baseURL は "/v1" までを含むURLを指定します (例: http://localhost:8000/v1)。
+// ローカルサーバー向けに apiKey は空でも構いません。
+func NewOpenAIClient(baseURL string, apiKey string, modelName string) (*OpenAIClient, error) {
+	if modelName == "" {
+		return nil, fmt.Errorf("OpenAI model name is missing")
+	}
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	return &OpenAIClient{
+		httpClient: &http.Client{},
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		modelName:  modelName,
+	}, nil
+}
+
+// ModelInfo は使用中のモデル情報を返します。
+func (oc *OpenAIClient) ModelInfo() ModelInfo {
+	return ModelInfo{Provider: ProviderOpenAI, Model: oc.modelName}
+}
+
+// openAIChatMessage は /chat/completions のメッセージ形式です。
+type openAIChatMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+// openAIRequestMessage はリクエストのメッセージです。
+// 画像を添付する場合、Content はテキストと画像のパートの配列になります。
+// ツールの実行結果は tool ロールのメッセージとして、ToolCallID で呼び出しに対応付けます。
+type openAIRequestMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// openAIToolCall はモデルが要求した関数呼び出しです。Arguments はJSON文字列です。
+type openAIToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAIToolCallDelta はストリーミング応答の関数呼び出しの断片です。
+// Index ごとに ID と名前、引数が分割して届くため、連結して組み立てます。
+type openAIToolCallDelta struct {
+	Index int `json:"index"`
+	openAIToolCall
+}
+
+// openAIContentPart はマルチモーダルなメッセージの1パートです。
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+// openAIImageURL は画像パートのURLです。添付画像はdata URLで送信します。
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+// openAIChatRequest は /chat/completions のリクエストボディです。
+type openAIChatRequest struct {
+	Model          string                 `json:"model"`
+	Messages       []openAIRequestMessage `json:"messages"`
+	Stream         bool                   `json:"stream,omitempty"`
+	Temperature    *float32               `json:"temperature,omitempty"`
+	TopP           *float32               `json:"top_p,omitempty"`
+	TopK           *int32                 `json:"top_k,omitempty"` // vLLM、llama.cpp server などの拡張パラメータ (公式のOpenAI APIには送信しません)
+	MaxTokens      *int32                 `json:"max_tokens,omitempty"`
+	ResponseFormat *openAIResponseFormat  `json:"response_format,omitempty"`
+	StreamOptions  *openAIStreamOptions   `json:"stream_options,omitempty"`
+	Tools          []functionTool         `json:"tools,omitempty"`
+}
+
+// openAIStreamOptions はストリーミング時のオプションです。最後のチャンクで使用量を受け取るために指定します。
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIUsage は応答に含まれるトークン使用量です。
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u *openAIUsage) toUsage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{PromptTokens: u.PromptTokens, ResponseTokens: u.CompletionTokens}
+}
+
+// openAIResponseFormat は構造化出力 (json_schema) の指定です。
+type openAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string  `json:"name"`
+		Schema *Schema `json:"schema"`
+	} `json:"json_schema"`
+}
+
+// openAIChatResponse は /chat/completions の非ストリーミング応答です。
+type openAIChatResponse struct {
+	Choices []struct {
+		Message      openAIChatMessage `json:"message"`
+		FinishReason string            `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// openAIChatChunk はストリーミング応答 (Server-Sent Events) の1チャンクです。
+type openAIChatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string                `json:"content"`
+			ToolCalls []openAIToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// openAIModelsResponse は /models の応答です。
+type openAIModelsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+// ListModels はサーバーが提供するモデルの一覧を返します。
+func (oc *OpenAIClient) ListModels(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oc.baseURL+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if oc.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+oc.apiKey)
+	}
+	resp, err := oc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newAPIError("/models", resp, msg)
+	}
+
+	var parsed openAIModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode model list: %w", err)
+	}
+	models := make([]string, 0, len(parsed.Data))
+	for _, m := range parsed.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+// defaultOpenAIEmbeddingModel は埋め込みモデルが未設定の場合に使用するモデルです。
+const defaultOpenAIEmbeddingModel = "text-embedding-3-small"
+
+// openAIEmbeddingRequest は /embeddings のリクエストです。
+type openAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// openAIEmbeddingResponse は /embeddings の応答です。Index は入力の順序です。
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// EmbeddingModel は埋め込みに使用するモデル名を返します。
+func (oc *OpenAIClient) EmbeddingModel() string {
+	if oc.embeddingModel == "" {
+		return defaultOpenAIEmbeddingModel
+	}
+	return oc.embeddingModel
+}
+
+// Embed は /embeddings でテキストの埋め込みベクトルをまとめて計算します。
+func (oc *OpenAIClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := oc.post(ctx, "/embeddings", openAIEmbeddingRequest{Model: oc.EmbeddingModel(), Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed openAIEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range parsed.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// Generate は会話履歴を含むリクエストに基づいてAIコンテンツを生成します。
+func (oc *OpenAIClient) Generate(ctx context.Context, req *Request) (*Response, error) {
+	body, err := oc.newChatRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oc.post(ctx, "/chat/completions", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode chat completion: %w", err)
+	}
+	var answer string
+	var finishReason FinishReason
+	var toolCalls []ToolCall
+	if len(parsed.Choices) > 0 {
+		answer = parsed.Choices[0].Message.Content
+		finishReason = openAIFinishReason(parsed.Choices[0].FinishReason)
+		if toolCalls, err = openAIToolCalls(parsed.Choices[0].Message.ToolCalls); err != nil {
+			return &Response{Text: answer, Model: oc.requestModel(req)}, err
+		}
+	}
+	if answer == "" && len(toolCalls) == 0 {
+		log.Println("OpenAI-compatible API returned an empty answer.")
+	}
+	return checkOpenAIFinish(&Response{Text: answer, Model: oc.requestModel(req), Usage: parsed.Usage.toUsage(), ToolCalls: toolCalls, FinishReason: finishReason})
+}
+
+// GenerateStream は応答をストリーミングで生成し、チャンクごとに onChunk を呼び出します。
+func (oc *OpenAIClient) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	body, err := oc.newChatRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oc.post(ctx, "/chat/completions", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var answer string
+	var usage Usage
+	var finishReason FinishReason
+	var streamedCalls []openAIToolCallDelta
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		var chunk openAIChatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return &Response{Text: answer, Model: oc.requestModel(req)}, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage.toUsage()
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				finishReason = openAIFinishReason(choice.FinishReason)
+			}
+			streamedCalls = mergeOpenAIToolCallDeltas(streamedCalls, choice.Delta.ToolCalls)
+			if choice.Delta.Content == "" {
+				continue
+			}
+			answer += choice.Delta.Content
+			if onChunk != nil {
+				onChunk(choice.Delta.Content)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &Response{Text: answer, Model: oc.requestModel(req)}, fmt.Errorf("failed to read stream: %w", err)
+	}
+	calls := make([]openAIToolCall, 0, len(streamedCalls))
+	for _, call := range streamedCalls {
+		calls = append(calls, call.openAIToolCall)
+	}
+	toolCalls, err := openAIToolCalls(calls)
+	if err != nil {
+		return &Response{Text: answer, Model: oc.requestModel(req)}, err
+	}
+	if answer == "" && len(toolCalls) == 0 {
+		log.Println("OpenAI-compatible API returned an empty answer.")
+	}
+	return checkOpenAIFinish(&Response{Text: answer, Model: oc.requestModel(req), Usage: usage, ToolCalls: toolCalls, FinishReason: finishReason})
+}
+
+// mergeOpenAIToolCallDeltas はストリーミングで届いた関数呼び出しの断片を Index ごとに連結します。
+func mergeOpenAIToolCallDeltas(calls []openAIToolCallDelta, deltas []openAIToolCallDelta) []openAIToolCallDelta {
+	for _, delta := range deltas {
+		i := -1
+		for j := range calls {
+			if calls[j].Index == delta.Index {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			calls = append(calls, openAIToolCallDelta{Index: delta.Index})
+			i = len(calls) - 1
+		}
+		if delta.ID != "" {
+			calls[i].ID = delta.ID
+		}
+		calls[i].Function.Name += delta.Function.Name
+		calls[i].Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
+
+// openAIToolCalls は関数呼び出しを ToolCall に変換します。
+func openAIToolCalls(calls []openAIToolCall) ([]ToolCall, error) {
+	var toolCalls []ToolCall
+	for _, call := range calls {
+		args, err := decodeToolArgs(call.Function.Name, call.Function.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		toolCalls = append(toolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Args: args})
+	}
+	return toolCalls, nil
+}
+
+// openAIFinishReason は finish_reason を FinishReason に変換します。
+func openAIFinishReason(reason string) FinishReason {
+	switch reason {
+	case "":
+		return ""
+	case "stop", "tool_calls", "function_call":
+		return FinishReasonStop
+	case "length":
+		return FinishReasonMaxTokens
+	case "content_filter":
+		return FinishReasonSafety
+	default:
+		return FinishReasonOther
+	}
+}
+
+// checkOpenAIFinish はコンテンツフィルタで打ち切られた応答を、受信したテキスト付きの BlockedError として返します。
+func checkOpenAIFinish(resp *Response) (*Response, error) {
+	if resp.FinishReason == FinishReasonSafety {
+		return resp, &BlockedError{Reason: FinishReasonSafety}
+	}
+	return resp, nil
+}
+
+// supportsTopK は拡張パラメータの top_k を送信できるかどうかを返します。
+// 公式のOpenAI APIは top_k を受け付けないため、api.openai.com には送信しません。
+func (oc *OpenAIClient) supportsTopK() bool {
+	u, err := url.Parse(oc.baseURL)
+	return err != nil || !strings.EqualFold(u.Hostname(), "api.openai.com")
+}
+
+// newChatRequest は Request を /chat/completions のリクエストボディに変換します。
+func (oc *OpenAIClient) newChatRequest(req *Request, stream bool) (openAIChatRequest, error) {
+	if _, _, err := splitLastUserMessage(req); err != nil {
+		return openAIChatRequest{}, err
+	}
+	messages := make([]openAIRequestMessage, 0, len(req.Messages)+1)
+	if req.System != "" {
+		messages = append(messages, openAIRequestMessage{Role: "system", Content: req.System})
+	}
+	for _, m := range req.Messages {
+		messages = append(messages, openAIMessages(m)...)
+	}
+	modelName := oc.requestModel(req)
+	log.Printf("Sending chat request to OpenAI-compatible API (%s, %s, %d messages)", oc.baseURL, modelName, len(messages))
+	body := openAIChatRequest{
+		Model:       modelName,
+		Messages:    messages,
+		Stream:      stream,
+		Temperature: req.Params.Temperature,
+		TopP:        req.Params.TopP,
+		MaxTokens:   req.Params.MaxOutputTokens,
+		Tools:       functionTools(req.Tools),
+	}
+	if req.Params.TopK != nil && oc.supportsTopK() {
+		body.TopK = req.Params.TopK
+	}
+	if stream {
+		body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	if req.ResponseSchema != nil {
+		body.ResponseFormat = &openAIResponseFormat{Type: "json_schema"}
+		body.ResponseFormat.JSONSchema.Name = "response"
+		body.ResponseFormat.JSONSchema.Schema = req.ResponseSchema
+	}
+	return body, nil
+}
+
+// requestModel はリクエストで指定されたモデル名、未指定の場合は既定のモデル名を返します。
+func (oc *OpenAIClient) requestModel(req *Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return oc.modelName
+}
+
+// openAIMessages は Message をリクエストのメッセージに変換します。
+// ツールの実行結果は、結果ごとに呼び出しのIDを付けた tool ロールのメッセージにします。
+func openAIMessages(m Message) []openAIRequestMessage {
+	if m.Role == RoleTool {
+		messages := make([]openAIRequestMessage, 0, len(m.ToolResults))
+		for _, result := range m.ToolResults {
+			messages = append(messages, openAIRequestMessage{Role: openAIRole(RoleTool), Content: result.Content, ToolCallID: result.CallID})
+		}
+		return messages
+	}
+	msg := openAIRequestMessage{Role: openAIRole(m.Role), Content: openAIContent(m)}
+	for _, call := range m.ToolCalls {
+		tc := openAIToolCall{ID: call.ID, Type: "function"}
+		tc.Function.Name = call.Name
+		tc.Function.Arguments = encodeToolArgs(call.Args)
+		msg.ToolCalls = append(msg.ToolCalls, tc)
+	}
+	if len(msg.ToolCalls) > 0 && m.Text == "" {
+		msg.Content = nil
+	}
+	return []openAIRequestMessage{msg}
+}
+
+// openAIContent はメッセージの content を返します。画像がある場合はdata URLの image_url パートを含む配列にします。
+func openAIContent(m Message) interface{} {
+	if len(m.Images) == 0 {
+		return m.Text
+	}
+	parts := make([]openAIContentPart, 0, len(m.Images)+1)
+	parts = append(parts, openAIContentPart{Type: "text", Text: m.Text})
+	for _, img := range m.Images {
+		parts = append(parts, openAIContentPart{
+			Type:     "image_url",
+			ImageURL: &openAIImageURL{URL: "data:" + img.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)},
+		})
+	}
+	return parts
+}
+
+// openAIRole は Role をOpenAI互換APIのロール名に変換します。
+func openAIRole(role Role) string {
+	switch role {
+	case RoleModel:
+		return "assistant"
+	case RoleTool:
+		return "tool"
+	default:
+		return "user"
+	}
+}
+
+// post はJSONボディを指定パスへPOSTし、2xx以外の応答は APIError として返します。
+func (oc *OpenAIClient) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oc.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if oc.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+oc.apiKey)
+	}
+	resp, err := oc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newAPIError(path, resp, msg)
+	}
+	return resp, nil
+}
@@ -0,0 +1,378 @@
+package ai_client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestOpenAIClient は handler で応答するローカルサーバーに接続するクライアントを作成します。
+func newTestOpenAIClient(t *testing.T, handler http.HandlerFunc) *OpenAIClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	oc, err := NewOpenAIClient(server.URL+"/v1", "test-key", "test-model")
+	if err != nil {
+		t.Fatalf("NewOpenAIClient: %v", err)
+	}
+	return oc
+}
+
+// decodeChatRequest はサーバー側で受け取ったリクエストボディを読み取ります。
+func decodeChatRequest(t *testing.T, r *http.Request) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Errorf("failed to decode request body: %v", err)
+	}
+	return body
+}
+
+func userRequest(text string) *Request {
+	return &Request{Messages: []Message{{Role: RoleUser, Text: text}}}
+}
+
+func TestOpenAIGenerate(t *testing.T) {
+	oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %q, want /v1/chat/completions", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		body := decodeChatRequest(t, r)
+		if body["model"] != "test-model" {
+			t.Errorf("model = %v, want test-model", body["model"])
+		}
+		if _, ok := body["stream"]; ok {
+			t.Errorf("stream should be omitted for a non-streaming request")
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"こんにちは"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`)
+	})
+
+	resp, err := oc.Generate(context.Background(), &Request{
+		System: "丁寧に答えてください",
+		Messages: []Message{
+			{Role: RoleUser, Text: "前の質問"},
+			{Role: RoleModel, Text: "前の回答"},
+			{Role: RoleUser, Text: "挨拶して"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Text != "こんにちは" {
+		t.Errorf("Text = %q", resp.Text)
+	}
+	if resp.Model != "test-model" {
+		t.Errorf("Model = %q", resp.Model)
+	}
+	if resp.Usage != (Usage{PromptTokens: 12, ResponseTokens: 3}) {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+	if resp.FinishReason != FinishReasonStop {
+		t.Errorf("FinishReason = %q", resp.FinishReason)
+	}
+}
+
+func TestOpenAIGenerateSendsRoles(t *testing.T) {
+	oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := decodeChatRequest(t, r)
+		messages, _ := body["messages"].([]interface{})
+		want := []string{"system", "user", "assistant", "user"}
+		if len(messages) != len(want) {
+			t.Errorf("got %d messages, want %d", len(messages), len(want))
+			return
+		}
+		for i, m := range messages {
+			if role := m.(map[string]interface{})["role"]; role != want[i] {
+				t.Errorf("messages[%d].role = %v, want %s", i, role, want[i])
+			}
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	})
+
+	_, err := oc.Generate(context.Background(), &Request{
+		System: "system",
+		Messages: []Message{
+			{Role: RoleUser, Text: "q1"},
+			{Role: RoleModel, Text: "a1"},
+			{Role: RoleUser, Text: "q2"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+}
+
+func TestOpenAITopKOnlyForCompatibleServers(t *testing.T) {
+	topK := int32(40)
+	req := userRequest("q")
+	req.Params.TopK = &topK
+	tests := []struct {
+		baseURL  string
+		wantTopK bool
+	}{
+		{baseURL: "", wantTopK: false},
+		{baseURL: "https://API.openai.com/v1/", wantTopK: false},
+		{baseURL: "http://localhost:8000/v1", wantTopK: true},
+		{baseURL: "https://openai.example.com/v1", wantTopK: true},
+	}
+	for _, tt := range tests {
+		oc, err := NewOpenAIClient(tt.baseURL, "test-key", "test-model")
+		if err != nil {
+			t.Fatalf("NewOpenAIClient(%q): %v", tt.baseURL, err)
+		}
+		body, err := oc.newChatRequest(req, false)
+		if err != nil {
+			t.Fatalf("newChatRequest: %v", err)
+		}
+		if got := body.TopK != nil; got != tt.wantTopK {
+			t.Errorf("%q: top_k sent = %v, want %v", tt.baseURL, got, tt.wantTopK)
+		}
+	}
+}
+
+func TestOpenAIGenerateStream(t *testing.T) {
+	oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := decodeChatRequest(t, r)
+		if body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		options, _ := body["stream_options"].(map[string]interface{})
+		if options["include_usage"] != true {
+			t.Errorf("stream_options.include_usage = %v, want true", options["include_usage"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\", world\"},\"finish_reason\":\"length\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"after done\"}}]}\n\n")
+	})
+
+	var chunks []string
+	resp, err := oc.GenerateStream(context.Background(), userRequest("hi"), func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0] != "Hello" || chunks[1] != ", world" {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if resp.Text != "Hello, world" {
+		t.Errorf("Text = %q", resp.Text)
+	}
+	if resp.Usage != (Usage{PromptTokens: 5, ResponseTokens: 2}) {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+	if resp.FinishReason != FinishReasonMaxTokens {
+		t.Errorf("FinishReason = %q, want %q", resp.FinishReason, FinishReasonMaxTokens)
+	}
+}
+
+func TestOpenAIRateLimitError(t *testing.T) {
+	oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"rate limited"}}`)
+	})
+
+	_, err := oc.Generate(context.Background(), userRequest("hi"))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("StatusCode = %d", apiErr.StatusCode)
+	}
+	if apiErr.RetryAfter != 7*time.Second {
+		t.Errorf("RetryAfter = %v, want 7s", apiErr.RetryAfter)
+	}
+	if retry, wait := retryableError(err); !retry || wait != 7*time.Second {
+		t.Errorf("retryableError = (%v, %v), want (true, 7s)", retry, wait)
+	}
+}
+
+func TestOpenAIContentFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream bool
+		body   string
+	}{
+		{
+			name: "generate",
+			body: `{"choices":[{"message":{"role":"assistant","content":"途中"},"finish_reason":"content_filter"}]}`,
+		},
+		{
+			name:   "stream",
+			stream: true,
+			body:   "data: {\"choices\":[{\"delta\":{\"content\":\"途中\"},\"finish_reason\":\"content_filter\"}]}\n\ndata: [DONE]\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			})
+			var resp *Response
+			var err error
+			if tt.stream {
+				resp, err = oc.GenerateStream(context.Background(), userRequest("hi"), nil)
+			} else {
+				resp, err = oc.Generate(context.Background(), userRequest("hi"))
+			}
+			var blocked *BlockedError
+			if !errors.As(err, &blocked) {
+				t.Fatalf("err = %v, want *BlockedError", err)
+			}
+			if blocked.Reason != FinishReasonSafety {
+				t.Errorf("Reason = %q, want %q", blocked.Reason, FinishReasonSafety)
+			}
+			if resp == nil || resp.Text != "途中" {
+				t.Errorf("partial response = %+v, want text 途中", resp)
+			}
+		})
+	}
+}
+
+func TestOpenAIImageParts(t *testing.T) {
+	image := []byte{0x89, 'P', 'N', 'G'}
+	oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := decodeChatRequest(t, r)
+		messages, _ := body["messages"].([]interface{})
+		if len(messages) != 1 {
+			t.Errorf("got %d messages, want 1", len(messages))
+			return
+		}
+		parts, ok := messages[0].(map[string]interface{})["content"].([]interface{})
+		if !ok || len(parts) != 2 {
+			t.Errorf("content = %v, want text and image parts", messages[0])
+			return
+		}
+		text := parts[0].(map[string]interface{})
+		if text["type"] != "text" || text["text"] != "この画像は?" {
+			t.Errorf("text part = %v", text)
+		}
+		img := parts[1].(map[string]interface{})
+		if img["type"] != "image_url" {
+			t.Errorf("image part type = %v", img["type"])
+		}
+		wantURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(image)
+		if url := img["image_url"].(map[string]interface{})["url"]; url != wantURL {
+			t.Errorf("image url = %v, want %s", url, wantURL)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"PNG画像です"},"finish_reason":"stop"}]}`)
+	})
+
+	_, err := oc.Generate(context.Background(), &Request{Messages: []Message{{
+		Role:   RoleUser,
+		Text:   "この画像は?",
+		Images: []Image{{MIMEType: "image/png", Data: image}},
+	}}})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+}
+
+func TestOpenAIToolCalls(t *testing.T) {
+	round := 0
+	oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		round++
+		body := decodeChatRequest(t, r)
+		tools, _ := body["tools"].([]interface{})
+		if len(tools) != 1 {
+			t.Errorf("tools = %v, want the calculate tool", body["tools"])
+		} else if fn := tools[0].(map[string]interface{})["function"].(map[string]interface{}); fn["name"] != "calculate" {
+			t.Errorf("tool function = %v", fn)
+		}
+		messages, _ := body["messages"].([]interface{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		if round == 1 {
+			// 関数呼び出しの ID・名前・引数は断片に分かれて届きます。
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"calculate\",\"arguments\":\"\"}}]}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"expression\\\":\"}}]}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"6*7\\\"}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		if len(messages) != 3 {
+			t.Fatalf("got %d messages in the second round, want 3", len(messages))
+		}
+		assistant := messages[1].(map[string]interface{})
+		if assistant["role"] != "assistant" || assistant["content"] != nil {
+			t.Errorf("assistant message = %v, want null content", assistant)
+		}
+		calls, _ := assistant["tool_calls"].([]interface{})
+		if len(calls) != 1 {
+			t.Fatalf("tool_calls = %v", assistant["tool_calls"])
+		}
+		call := calls[0].(map[string]interface{})
+		if _, ok := call["index"]; ok || call["id"] != "call_1" || call["type"] != "function" {
+			t.Errorf("tool call = %v", call)
+		}
+		if args := call["function"].(map[string]interface{})["arguments"]; args != `{"expression":"6*7"}` {
+			t.Errorf("arguments = %v", args)
+		}
+		result := messages[2].(map[string]interface{})
+		if result["role"] != "tool" || result["tool_call_id"] != "call_1" || result["content"] != "42" {
+			t.Errorf("tool result message = %v", result)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"答えは42です\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	})
+
+	var observed []ToolCall
+	req := userRequest("6かける7は?")
+	req.Tools = []*Tool{{
+		Name:       "calculate",
+		Parameters: &Schema{Type: SchemaObject, Properties: map[string]*Schema{"expression": {Type: SchemaString}}},
+		Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			if args["expression"] != "6*7" {
+				t.Errorf("args = %v", args)
+			}
+			return "42", nil
+		},
+	}}
+	resp, err := GenerateStreamWithTools(context.Background(), oc, req, nil, func(call ToolCall, result ToolResult) {
+		observed = append(observed, call)
+		if result.CallID != "call_1" || result.IsError {
+			t.Errorf("result = %+v", result)
+		}
+	})
+	if err != nil {
+		t.Fatalf("GenerateStreamWithTools: %v", err)
+	}
+	if resp.Text != "答えは42です" {
+		t.Errorf("Text = %q", resp.Text)
+	}
+	if len(observed) != 1 || observed[0].ID != "call_1" || observed[0].Name != "calculate" {
+		t.Errorf("observed calls = %+v", observed)
+	}
+}
+
+func TestOpenAIToolCallsGenerate(t *testing.T) {
+	oc := newTestOpenAIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_9","type":"function","function":{"name":"search_nodes","arguments":"{\"query\":\"go\"}"}}]},"finish_reason":"tool_calls"}]}`)
+	})
+	resp, err := oc.Generate(context.Background(), userRequest("goについて"))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := []ToolCall{{ID: "call_9", Name: "search_nodes", Args: map[string]interface{}{"query": "go"}}}
+	if !reflect.DeepEqual(resp.ToolCalls, want) {
+		t.Errorf("ToolCalls = %+v, want %+v", resp.ToolCalls, want)
+	}
+	if resp.FinishReason != FinishReasonStop {
+		t.Errorf("FinishReason = %q", resp.FinishReason)
+	}
+}
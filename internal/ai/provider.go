@@ -0,0 +1,219 @@
+package ai_client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"AI-Dialogue-Map/internal/config"
+)
+
+// プロバイダ名の定義です。config.Config の Provider に指定します。
+const (
+	ProviderGemini = "gemini"
+	ProviderOpenAI = "openai"
+	ProviderOllama = "ollama"
+	// ProviderReplay は記録済みの応答を返すネットワーク不要のプロバイダです (テスト・デモ用)。
+	ProviderReplay = "replay"
+)
+
+// ModelInfo はプロバイダと使用中のモデルの情報を保持します。
+type ModelInfo struct {
+	Provider string
+	Model    string
+}
+
+// String は "provider/model" 形式の表示用文字列を返します。
+func (mi ModelInfo) String() string {
+	if mi.Model == "" {
+		return mi.Provider
+	}
+	return fmt.Sprintf("%s/%s", mi.Provider, mi.Model)
+}
+
+// Role は会話メッセージの発言者を表します。
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleModel Role = "model"
+	// RoleTool はモデルが呼び出したツールの実行結果を返すメッセージです。
+	RoleTool Role = "tool"
+)
+
+// Message は会話の1ターン分のメッセージです。
+type Message struct {
+	Role Role
+	Text string
+	// Images はユーザーのメッセージに添付された画像です。
+	Images []Image
+	// ToolCalls はモデルのメッセージで要求されたツール呼び出しです。
+	ToolCalls []ToolCall
+	// ToolResults は RoleTool のメッセージで返すツールの実行結果です。
+	ToolResults []ToolResult
+	// Pinned は TrimToBudget で取り除かないメッセージです (古い祖先を置き換えた会話履歴の要約など)。
+	// 既存の応答キャッシュや記録のキーが変わらないよう、false の場合はJSONに含めません。
+	Pinned bool `json:",omitempty"`
+}
+
+// Image はメッセージに添付する画像データです。
+type Image struct {
+	MIMEType string
+	Data     []byte
+}
+
+// GenerationParams は生成パラメータです。nilの項目はプロバイダの既定値を使用します。
+type GenerationParams struct {
+	Temperature     *float32
+	TopP            *float32
+	TopK            *int32
+	MaxOutputTokens *int32
+}
+
+// Request はプロバイダへの生成リクエストです。
+// Messages は古い順に並べ、最後の要素をユーザーの新しい質問とします。
+type Request struct {
+	// Model は使用するモデル名です。空の場合はプロバイダの既定モデルを使用します。
+	Model  string
+	Params GenerationParams
+	// System はモデルに与えるシステム指示 (ペルソナ) です。空の場合は指定しません。
+	System   string
+	Messages []Message
+	// ResponseSchema が指定された場合、スキーマに従うJSONで応答するよう要求します。
+	ResponseSchema *Schema
+	// Tools はモデルが呼び出せるツールです。対応していないプロバイダは無視します。
+	Tools []*Tool
+}
+
+// Response はプロバイダからの生成結果です。
+type Response struct {
+	Text string
+	// Model は実際に応答を生成したモデル名です。
+	Model string
+	// Usage はリクエストで消費したトークン数です。プロバイダが返さない場合はゼロ値です。
+	Usage Usage
+	// CacheHit は応答キャッシュから返した応答かどうかです。
+	CacheHit bool
+	// ToolCalls はモデルが要求したツール呼び出しです。ある場合は実行結果を返して生成を続けます。
+	ToolCalls []ToolCall
+	// FinishReason は生成が終了した理由です。プロバイダが返さない場合は空文字列です。
+	FinishReason FinishReason
+	// SafetyRatings は応答の安全性評価です (Geminiのみ)。
+	SafetyRatings []SafetyRating
+}
+
+// Provider はLLMバックエンドとの連携を抽象化するインターフェースです。
+type Provider interface {
+	// Generate はリクエストに対する応答全体を生成して返します。
+	// ctx がキャンセルされるとリクエストを中断します。
+	Generate(ctx context.Context, req *Request) (*Response, error)
+	// GenerateStream は応答を逐次生成し、受信したチャンクごとに onChunk を呼び出します。
+	// 戻り値の Text は連結済みの応答全体です。
+	GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error)
+	// ModelInfo は使用中のプロバイダとモデルの情報を返します。
+	ModelInfo() ModelInfo
+}
+
+// ModelLister は利用可能なモデルの一覧を取得できるプロバイダが実装します。
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// splitLastUserMessage はリクエストを過去の履歴と最後のユーザーメッセージに分割します。
+// ツール呼び出しの途中では、最後のメッセージはツールの実行結果になります。
+func splitLastUserMessage(req *Request) ([]Message, Message, error) {
+	if req == nil || len(req.Messages) == 0 {
+		return nil, Message{}, fmt.Errorf("request has no messages")
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != RoleUser && last.Role != RoleTool {
+		return nil, Message{}, fmt.Errorf("last message must be from the user, got %q", last.Role)
+	}
+	return req.Messages[:len(req.Messages)-1], last, nil
+}
+
+// NewProvider は設定に基づいてプロバイダを作成します。
+// Provider が未指定の場合はGeminiを使用します。
+func NewProvider(cfg config.Config) (Provider, error) {
+	return NewProviderByName(cfg, cfg.Provider)
+}
+
+// NewProviderByName は指定された名前のプロバイダを、設定の接続情報を使って作成します。
+// 作成したプロバイダは、設定に従ってレート制限と一時的なエラーの再試行を行い、応答をキャッシュします。
+func NewProviderByName(cfg config.Config, providerName string) (Provider, error) {
+	name := strings.ToLower(strings.TrimSpace(providerName))
+	var client Provider
+	switch name {
+	case "", ProviderGemini:
+		gc, err := NewGeminiClient(cfg.GeminiAPIKey, cfg.GeminiModel)
+		if err != nil {
+			return nil, err
+		}
+		gc.embeddingModel = cfg.GeminiEmbeddingModel
+		if gc.safetySettings, err = geminiSafetySettings(cfg.SafetySettings); err != nil {
+			return nil, err
+		}
+		name, client = ProviderGemini, gc
+	case ProviderOpenAI:
+		oc, err := NewOpenAIClient(cfg.OpenAIBaseURL, cfg.OpenAIAPIKey, cfg.OpenAIModel)
+		if err != nil {
+			return nil, err
+		}
+		oc.embeddingModel = cfg.OpenAIEmbeddingModel
+		client = oc
+	case ProviderOllama:
+		oc, err := NewOllamaClient(cfg.OllamaHost, cfg.OllamaModel)
+		if err != nil {
+			return nil, err
+		}
+		oc.embeddingModel = cfg.OllamaEmbeddingModel
+		client = oc
+	case ProviderReplay:
+		return newReplayProvider(cfg)
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", providerName)
+	}
+	return newCachingProvider(cfg, NewRetryingProvider(client, newRetryPolicy(cfg), limiterFor(cfg, name))), nil
+}
+
+// newReplayProvider は replay_mode に従って、記録を再生するプロバイダか、
+// replay_record_provider の応答をフィクスチャファイルに記録するプロバイダを作成します。
+func newReplayProvider(cfg config.Config) (Provider, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.ReplayMode)) {
+	case "", ReplayModeReplay:
+		rc, err := NewReplayClient(cfg.ReplayFixtures)
+		if err != nil {
+			return nil, err
+		}
+		return NewRetryingProvider(rc, newRetryPolicy(cfg), limiterFor(cfg, ProviderReplay)), nil
+	case ReplayModeRecord:
+		if strings.EqualFold(strings.TrimSpace(cfg.ReplayRecordProvider), ProviderReplay) {
+			return nil, fmt.Errorf("replay_record_provider must be a real provider, got %q", cfg.ReplayRecordProvider)
+		}
+		inner, err := NewProviderByName(cfg, cfg.ReplayRecordProvider)
+		if err != nil {
+			return nil, err
+		}
+		return NewRecordingProvider(inner, cfg.ReplayFixtures)
+	default:
+		return nil, fmt.Errorf("unknown replay mode: %s", cfg.ReplayMode)
+	}
+}
+
+// NewProviderFromSpec は "provider" または "provider:model" 形式の指定からプロバイダを作成します。
+// モデルを指定した場合は設定ファイルの既定モデルより優先します。
+// Ollamaのモデル名は ":" を含むことがあるため、最初の ":" でのみ分割します (例: "ollama:llama3.2:latest")。
+func NewProviderFromSpec(cfg config.Config, spec string) (Provider, error) {
+	name, model, hasModel := strings.Cut(strings.TrimSpace(spec), ":")
+	if hasModel && model != "" {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "", ProviderGemini:
+			cfg.GeminiModel = model
+		case ProviderOpenAI:
+			cfg.OpenAIModel = model
+		case ProviderOllama:
+			cfg.OllamaModel = model
+		}
+	}
+	return NewProviderByName(cfg, name)
+}
@@ -0,0 +1,298 @@
+package ai_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// リプレイプロバイダの動作モードです。config.Config の ReplayMode に指定します。
+const (
+	ReplayModeReplay = "replay"
+	ReplayModeRecord = "record"
+)
+
+// replayChunkRunes はリプレイ時のストリーミングで1チャンクに含める文字数です。
+const replayChunkRunes = 16
+
+// ErrNoFixture はリクエストに対応する応答がフィクスチャファイルにないことを表します。
+var ErrNoFixture = errors.New("no recorded response for this request")
+
+// replayFixtures はフィクスチャファイル (YAML) の内容です。
+type replayFixtures struct {
+	Interactions []replayInteraction `yaml:"interactions"`
+}
+
+// replayInteraction は記録した1回のリクエストと応答です。
+// Key はリクエスト全体のハッシュで、LastMessage はキーが一致しない場合の照合と、ファイルを読む人のために残します。
+type replayInteraction struct {
+	Key         string         `yaml:"key"`
+	LastMessage string         `yaml:"last_message"`
+	Response    replayResponse `yaml:"response"`
+}
+
+type replayResponse struct {
+	Text           string         `yaml:"text"`
+	Model          string         `yaml:"model,omitempty"`
+	PromptTokens   int            `yaml:"prompt_tokens,omitempty"`
+	ResponseTokens int            `yaml:"response_tokens,omitempty"`
+	ToolCalls      []ToolCall     `yaml:"tool_calls,omitempty"`
+	FinishReason   FinishReason   `yaml:"finish_reason,omitempty"`
+	SafetyRatings  []SafetyRating `yaml:"safety_ratings,omitempty"`
+}
+
+// ReplayClient はフィクスチャファイルに記録された応答を返す、ネットワークを使わないプロバイダです。
+// テストやデモで、APIキーなしに決まった応答で動作させるために使用します。
+type ReplayClient struct {
+	path     string
+	fixtures replayFixtures
+}
+
+// NewReplayClient はフィクスチャファイルを読み込んで新しいReplayClientを作成します。
+func NewReplayClient(path string) (*ReplayClient, error) {
+	fixtures, err := loadReplayFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayClient{path: path, fixtures: fixtures}, nil
+}
+
+func loadReplayFixtures(path string) (replayFixtures, error) {
+	var fixtures replayFixtures
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fixtures, fmt.Errorf("failed to read replay fixtures %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &fixtures); err != nil {
+		return fixtures, fmt.Errorf("failed to parse replay fixtures %s: %w", path, err)
+	}
+	return fixtures, nil
+}
+
+// ModelInfo は使用中のプロバイダとモデルの情報を返します。
+func (rc *ReplayClient) ModelInfo() ModelInfo {
+	return ModelInfo{Provider: ProviderReplay, Model: filepath.Base(rc.path)}
+}
+
+// ListModels は記録された応答のモデル名の一覧を返します。
+func (rc *ReplayClient) ListModels(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	var models []string
+	for _, in := range rc.fixtures.Interactions {
+		if in.Response.Model != "" && !seen[in.Response.Model] {
+			seen[in.Response.Model] = true
+			models = append(models, in.Response.Model)
+		}
+	}
+	return models, nil
+}
+
+// Generate はリクエストに対応する記録済みの応答を返します。
+// リクエスト全体が一致する記録を優先し、ない場合は最後のユーザーメッセージが一致する記録を返します。
+func (rc *ReplayClient) Generate(ctx context.Context, req *Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key, err := replayKey(req)
+	if err != nil {
+		return nil, err
+	}
+	_, last, err := splitLastUserMessage(req)
+	if err != nil {
+		return nil, err
+	}
+	var fallback *replayInteraction
+	for i := range rc.fixtures.Interactions {
+		in := &rc.fixtures.Interactions[i]
+		if in.Key == key {
+			return in.Response.toResponse(), nil
+		}
+		if fallback == nil && in.LastMessage == last.Text {
+			fallback = in
+		}
+	}
+	if fallback != nil {
+		return fallback.Response.toResponse(), nil
+	}
+	return nil, fmt.Errorf("%w (key %s)", ErrNoFixture, key)
+}
+
+// GenerateStream は記録済みの応答を数文字ずつのチャンクに分けて返します。
+func (rc *ReplayClient) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	resp, err := rc.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(resp.Text)
+	for start := 0; start < len(runes); start += replayChunkRunes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := min(start+replayChunkRunes, len(runes))
+		if onChunk != nil {
+			onChunk(string(runes[start:end]))
+		}
+	}
+	return resp, nil
+}
+
+func (r replayResponse) toResponse() *Response {
+	return &Response{
+		Text:          r.Text,
+		Model:         r.Model,
+		Usage:         Usage{PromptTokens: r.PromptTokens, ResponseTokens: r.ResponseTokens},
+		ToolCalls:     r.ToolCalls,
+		FinishReason:  r.FinishReason,
+		SafetyRatings: r.SafetyRatings,
+	}
+}
+
+// replayKey はリクエストの内容 (モデル、パラメータ、システム指示、メッセージ、スキーマ) から記録を照合するキーを作成します。
+func replayKey(req *Request) (string, error) {
+	if req == nil {
+		return "", fmt.Errorf("request is nil")
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// RecordingProvider は内側のプロバイダの応答をフィクスチャファイルに記録します。
+// 記録したファイルは ReplayClient でそのまま再生できます。
+type RecordingProvider struct {
+	inner Provider
+	path  string
+
+	mu       sync.Mutex // protects fixtures and the file
+	fixtures replayFixtures
+}
+
+// NewRecordingProvider は新しいRecordingProviderを作成します。フィクスチャファイルが既にある場合は記録を追記します。
+func NewRecordingProvider(inner Provider, path string) (*RecordingProvider, error) {
+	rp := &RecordingProvider{inner: inner, path: path}
+	if _, err := os.Stat(path); err == nil {
+		fixtures, err := loadReplayFixtures(path)
+		if err != nil {
+			return nil, err
+		}
+		rp.fixtures = fixtures
+	}
+	return rp, nil
+}
+
+// ModelInfo は内側のプロバイダの情報を返します。
+func (rp *RecordingProvider) ModelInfo() ModelInfo {
+	return rp.inner.ModelInfo()
+}
+
+// ListModels は内側のプロバイダがモデル一覧に対応していればそれを返します。
+func (rp *RecordingProvider) ListModels(ctx context.Context) ([]string, error) {
+	lister, ok := rp.inner.(ModelLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.ListModels(ctx)
+}
+
+// EmbeddingModel は内側のプロバイダの埋め込みモデル名を返します。
+func (rp *RecordingProvider) EmbeddingModel() string {
+	return EmbeddingModelOf(rp.inner)
+}
+
+// Embed は内側のプロバイダで埋め込みベクトルを計算します。対応していない場合は ErrEmbeddingUnsupported を返します。
+func (rp *RecordingProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embedder, ok := rp.inner.(Embedder)
+	if !ok {
+		return nil, ErrEmbeddingUnsupported
+	}
+	return embedder.Embed(ctx, texts)
+}
+
+// CountTokens は内側のプロバイダでトークン数を数えます。対応していない場合は ErrTokenCountUnsupported を返します。
+func (rp *RecordingProvider) CountTokens(ctx context.Context, req *Request) (int, error) {
+	tc, ok := rp.inner.(TokenCounter)
+	if !ok {
+		return 0, ErrTokenCountUnsupported
+	}
+	return tc.CountTokens(ctx, req)
+}
+
+// Generate は内側のプロバイダで応答を生成し、成功した応答を記録します。
+func (rp *RecordingProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := rp.inner.Generate(ctx, req)
+	if err == nil {
+		rp.record(req, resp)
+	}
+	return resp, err
+}
+
+// GenerateStream は内側のプロバイダで応答をストリーミング生成し、成功した応答全体を記録します。
+func (rp *RecordingProvider) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	resp, err := rp.inner.GenerateStream(ctx, req, onChunk)
+	if err == nil {
+		rp.record(req, resp)
+	}
+	return resp, err
+}
+
+// record は応答をフィクスチャに追加 (同じリクエストの記録は置き換え) し、ファイルに書き込みます。
+// 記録に失敗しても応答は返せるため、エラーはログに記録するだけです。
+func (rp *RecordingProvider) record(req *Request, resp *Response) {
+	key, err := replayKey(req)
+	if err != nil {
+		log.Printf("Failed to record response to %s: %v", rp.path, err)
+		return
+	}
+	_, last, _ := splitLastUserMessage(req)
+	interaction := replayInteraction{
+		Key:         key,
+		LastMessage: last.Text,
+		Response: replayResponse{
+			Text:           resp.Text,
+			Model:          resp.Model,
+			PromptTokens:   resp.Usage.PromptTokens,
+			ResponseTokens: resp.Usage.ResponseTokens,
+			ToolCalls:      resp.ToolCalls,
+			FinishReason:   resp.FinishReason,
+			SafetyRatings:  resp.SafetyRatings,
+		},
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	replaced := false
+	for i := range rp.fixtures.Interactions {
+		if rp.fixtures.Interactions[i].Key == key {
+			rp.fixtures.Interactions[i] = interaction
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		rp.fixtures.Interactions = append(rp.fixtures.Interactions, interaction)
+	}
+	data, err := yaml.Marshal(&rp.fixtures)
+	if err != nil {
+		log.Printf("Failed to record response to %s: %v", rp.path, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(rp.path), 0755); err != nil {
+		log.Printf("Failed to record response to %s: %v", rp.path, err)
+		return
+	}
+	if err := os.WriteFile(rp.path, data, 0644); err != nil {
+		log.Printf("Failed to record response to %s: %v", rp.path, err)
+	}
+}
@@ -0,0 +1,143 @@
+package ai_client
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"AI-Dialogue-Map/internal/config"
+)
+
+// sampleFixtures はリポジトリに同梱しているサンプルのフィクスチャファイルです。
+const sampleFixtures = "../../fixtures/replay.yaml"
+
+// scriptedProvider は最後のユーザーメッセージに対応する回答を返すテスト用のプロバイダです。
+type scriptedProvider struct {
+	answers map[string]string
+	calls   int
+}
+
+func (p *scriptedProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	p.calls++
+	_, last, err := splitLastUserMessage(req)
+	if err != nil {
+		return nil, err
+	}
+	answer, ok := p.answers[last.Text]
+	if !ok {
+		return nil, errors.New("unexpected question: " + last.Text)
+	}
+	return &Response{Text: answer, Model: "scripted", Usage: Usage{PromptTokens: 10, ResponseTokens: 5}, FinishReason: FinishReasonStop}, nil
+}
+
+func (p *scriptedProvider) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	resp, err := p.Generate(ctx, req)
+	if err == nil && onChunk != nil {
+		onChunk(resp.Text)
+	}
+	return resp, err
+}
+
+func (p *scriptedProvider) ModelInfo() ModelInfo {
+	return ModelInfo{Provider: "scripted", Model: "scripted"}
+}
+
+func TestSampleFixtures(t *testing.T) {
+	rc, err := NewReplayClient(sampleFixtures)
+	if err != nil {
+		t.Fatalf("NewReplayClient: %v", err)
+	}
+	if len(rc.fixtures.Interactions) == 0 {
+		t.Fatal("sample fixtures have no interactions")
+	}
+	for _, in := range rc.fixtures.Interactions {
+		resp, err := rc.Generate(context.Background(), &Request{
+			System:   "任意のシステムプロンプト",
+			Messages: []Message{{Role: RoleUser, Text: in.LastMessage}},
+		})
+		if err != nil {
+			t.Errorf("Generate(%q): %v", in.LastMessage, err)
+			continue
+		}
+		if resp.Text == "" || resp.Text != in.Response.Text {
+			t.Errorf("Generate(%q) = %q, want the recorded answer", in.LastMessage, resp.Text)
+		}
+	}
+	models, err := rc.ListModels(context.Background())
+	if err != nil || len(models) != 1 || models[0] != "replay-demo" {
+		t.Errorf("ListModels = %v, %v", models, err)
+	}
+}
+
+func TestNewProviderByNameReplaysSampleFixtures(t *testing.T) {
+	p, err := NewProviderByName(config.Config{ReplayFixtures: sampleFixtures}, ProviderReplay)
+	if err != nil {
+		t.Fatalf("NewProviderByName: %v", err)
+	}
+	resp, err := p.Generate(context.Background(), &Request{Messages: []Message{{Role: RoleUser, Text: "AI Dialogue Mapとは何ですか?"}}})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !strings.Contains(resp.Text, "AI Dialogue Map") {
+		t.Errorf("Text = %q", resp.Text)
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures", "recorded.yaml")
+	inner := &scriptedProvider{answers: map[string]string{
+		"first question":  "first answer",
+		"second question": "second answer",
+	}}
+	recorder, err := NewRecordingProvider(inner, path)
+	if err != nil {
+		t.Fatalf("NewRecordingProvider: %v", err)
+	}
+
+	first := &Request{System: "system", Messages: []Message{{Role: RoleUser, Text: "first question"}}}
+	second := &Request{System: "system", Messages: []Message{
+		{Role: RoleUser, Text: "first question"},
+		{Role: RoleModel, Text: "first answer"},
+		{Role: RoleUser, Text: "second question"},
+	}}
+	if _, err := recorder.Generate(context.Background(), first); err != nil {
+		t.Fatalf("record first: %v", err)
+	}
+	if _, err := recorder.GenerateStream(context.Background(), second, nil); err != nil {
+		t.Fatalf("record second: %v", err)
+	}
+
+	rc, err := NewReplayClient(path)
+	if err != nil {
+		t.Fatalf("NewReplayClient: %v", err)
+	}
+	var chunks []string
+	resp, err := rc.GenerateStream(context.Background(), second, func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if resp.Text != "second answer" || strings.Join(chunks, "") != "second answer" {
+		t.Errorf("replayed %q (chunks %q), want second answer", resp.Text, chunks)
+	}
+	if resp.Usage != (Usage{PromptTokens: 10, ResponseTokens: 5}) || resp.FinishReason != FinishReasonStop {
+		t.Errorf("replayed response = %+v", resp)
+	}
+	if inner.calls != 2 {
+		t.Errorf("inner provider called %d times, want 2", inner.calls)
+	}
+
+	// キーが一致しない場合も、最後の質問が同じ記録を返します。
+	resp, err = rc.Generate(context.Background(), &Request{Messages: []Message{{Role: RoleUser, Text: "first question"}}})
+	if err != nil || resp.Text != "first answer" {
+		t.Errorf("fallback replay = %v, %v", resp, err)
+	}
+
+	_, err = rc.Generate(context.Background(), &Request{Messages: []Message{{Role: RoleUser, Text: "unknown"}}})
+	if !errors.Is(err, ErrNoFixture) {
+		t.Errorf("err = %v, want ErrNoFixture", err)
+	}
+}
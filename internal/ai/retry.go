@@ -0,0 +1,315 @@
+package ai_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+
+	"AI-Dialogue-Map/internal/config"
+)
+
+// 再試行の既定値です。設定ファイルで上書きできます。
+const (
+	defaultRetryBaseDelay = 1 * time.Second
+	defaultRetryMaxDelay  = 30 * time.Second
+)
+
+// APIError はHTTP APIが2xx以外のステータスを返したことを表します。
+// RetryAfter はサーバーが Retry-After ヘッダーで指定した待ち時間です (指定がない場合は0)。
+type APIError struct {
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request to %s failed: %s: %s", e.Path, e.Status, e.Message)
+}
+
+// newAPIError はHTTP応答から APIError を作成します。応答ボディは先頭のみを読み取ります。
+func newAPIError(path string, resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		Path:       path,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter は Retry-After ヘッダー (秒数またはHTTP日付) を待ち時間に変換します。
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isRetryableStatus は一時的なエラーとして再試行すべきHTTPステータスかどうかを返します。
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableError はエラーが再試行可能かどうかと、サーバーが指定した待ち時間を返します。
+func retryableError(err error) (bool, time.Duration) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.StatusCode), apiErr.RetryAfter
+	}
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		var retryAfter time.Duration
+		if gErr.Header != nil {
+			retryAfter = parseRetryAfter(gErr.Header.Get("Retry-After"))
+		}
+		return isRetryableStatus(gErr.Code), retryAfter
+	}
+	return false, 0
+}
+
+// RetryNotifier は再試行の待機を開始するときに呼ばれます。
+// attempt は何回目の再試行か (1始まり)、wait は次の試行までの待ち時間です。
+type RetryNotifier func(attempt int, maxRetries int, wait time.Duration, err error)
+
+type retryNotifierKey struct{}
+
+// WithRetryNotifier は再試行の進行状況を通知する関数をコンテキストに設定します。
+func WithRetryNotifier(ctx context.Context, notify RetryNotifier) context.Context {
+	return context.WithValue(ctx, retryNotifierKey{}, notify)
+}
+
+func retryNotifierFrom(ctx context.Context) RetryNotifier {
+	notify, _ := ctx.Value(retryNotifierKey{}).(RetryNotifier)
+	return notify
+}
+
+// RetryPolicy は再試行の回数と待ち時間の設定です。
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// newRetryPolicy は設定ファイルの値から RetryPolicy を作成します。
+func newRetryPolicy(cfg config.Config) RetryPolicy {
+	policy := RetryPolicy{
+		MaxRetries: cfg.MaxRetries,
+		BaseDelay:  time.Duration(cfg.RetryBaseDelayMs) * time.Millisecond,
+		MaxDelay:   time.Duration(cfg.RetryMaxDelayMs) * time.Millisecond,
+	}
+	if policy.MaxRetries < 0 {
+		policy.MaxRetries = 0
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultRetryBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	return policy
+}
+
+// backoff は attempt 回目の再試行までの待ち時間を返します。
+// サーバーが Retry-After を指定した場合はそれに従い、それ以外は指数的に増やしてジッターを加えます。
+// どちらの場合も MaxDelay を超えて待つことはありません。
+func (p RetryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+			return p.MaxDelay
+		}
+		return retryAfter
+	}
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// providerLimiters はプロバイダ名ごとのレート制限です。
+// 比較送信などで同じプロバイダのクライアントが複数あっても制限を共有します。
+var (
+	providerLimitersMutex sync.Mutex
+	providerLimiters      = make(map[string]*rate.Limiter)
+)
+
+// limiterFor はプロバイダのトークンバケットを返します。設定がない場合はnilを返します。
+func limiterFor(cfg config.Config, providerName string) *rate.Limiter {
+	limit, ok := cfg.RateLimits[providerName]
+	if !ok || limit.RequestsPerMinute <= 0 {
+		return nil
+	}
+	providerLimitersMutex.Lock()
+	defer providerLimitersMutex.Unlock()
+	if l, ok := providerLimiters[providerName]; ok {
+		return l
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	l := rate.NewLimiter(rate.Limit(limit.RequestsPerMinute/60), burst)
+	providerLimiters[providerName] = l
+	return l
+}
+
+// RetryingProvider は別のプロバイダを包み、レート制限と一時的なエラーの再試行を行います。
+type RetryingProvider struct {
+	inner   Provider
+	policy  RetryPolicy
+	limiter *rate.Limiter
+}
+
+// NewRetryingProvider は新しいRetryingProviderのインスタンスを作成します。limiter がnilの場合はレート制限を行いません。
+func NewRetryingProvider(inner Provider, policy RetryPolicy, limiter *rate.Limiter) *RetryingProvider {
+	return &RetryingProvider{inner: inner, policy: policy, limiter: limiter}
+}
+
+// ModelInfo は内側のプロバイダのモデル情報を返します。
+func (rp *RetryingProvider) ModelInfo() ModelInfo {
+	return rp.inner.ModelInfo()
+}
+
+// ListModels は内側のプロバイダがモデル一覧に対応していればそれを返します。
+func (rp *RetryingProvider) ListModels(ctx context.Context) ([]string, error) {
+	lister, ok := rp.inner.(ModelLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.ListModels(ctx)
+}
+
+// EmbeddingModel は内側のプロバイダの埋め込みモデル名を返します。
+func (rp *RetryingProvider) EmbeddingModel() string {
+	return EmbeddingModelOf(rp.inner)
+}
+
+// Embed は一時的なエラーを再試行しながら、内側のプロバイダで埋め込みベクトルを計算します。対応していない場合は ErrEmbeddingUnsupported を返します。
+func (rp *RetryingProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embedder, ok := rp.inner.(Embedder)
+	if !ok {
+		return nil, ErrEmbeddingUnsupported
+	}
+	var vectors [][]float32
+	err := rp.do(ctx, func() (bool, error) {
+		var err error
+		vectors, err = embedder.Embed(ctx, texts)
+		return true, err
+	})
+	return vectors, err
+}
+
+// SafetyConfig は内側のプロバイダの安全性フィルタの設定を返します。
+func (rp *RetryingProvider) SafetyConfig() []string {
+	return SafetyConfigOf(rp.inner)
+}
+
+// CountTokens はレート制限に従い、一時的なエラーを再試行しながら内側のプロバイダでトークン数を数えます。
+// 対応していない場合は ErrTokenCountUnsupported を返します。
+func (rp *RetryingProvider) CountTokens(ctx context.Context, req *Request) (int, error) {
+	tc, ok := rp.inner.(TokenCounter)
+	if !ok {
+		return 0, ErrTokenCountUnsupported
+	}
+	var count int
+	err := rp.do(ctx, func() (bool, error) {
+		var err error
+		count, err = tc.CountTokens(ctx, req)
+		return true, err
+	})
+	return count, err
+}
+
+// Generate は一時的なエラーを再試行しながら応答を生成します。
+func (rp *RetryingProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	var resp *Response
+	err := rp.do(ctx, func() (bool, error) {
+		var err error
+		resp, err = rp.inner.Generate(ctx, req)
+		return true, err
+	})
+	if err == nil {
+		recordUsage(ctx, rp.inner.ModelInfo(), resp)
+	}
+	return resp, err
+}
+
+// GenerateStream は一時的なエラーを再試行しながら応答をストリーミングで生成します。
+// チャンクを受信した後のエラーは、応答が重複するため再試行しません。
+func (rp *RetryingProvider) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	var resp *Response
+	err := rp.do(ctx, func() (bool, error) {
+		received := false
+		var err error
+		resp, err = rp.inner.GenerateStream(ctx, req, func(chunk string) {
+			received = true
+			if onChunk != nil {
+				onChunk(chunk)
+			}
+		})
+		return !received, err
+	})
+	if err == nil {
+		recordUsage(ctx, rp.inner.ModelInfo(), resp)
+	}
+	return resp, err
+}
+
+// do はレート制限を待ってから call を実行し、再試行可能なエラーであれば待機して繰り返します。
+// call は、エラー時に再試行してよいかどうかを返します。
+func (rp *RetryingProvider) do(ctx context.Context, call func() (bool, error)) error {
+	for attempt := 0; ; attempt++ {
+		if rp.limiter != nil {
+			if err := rp.limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		canRetry, err := call()
+		if err == nil || ctx.Err() != nil || !canRetry || attempt >= rp.policy.MaxRetries {
+			return err
+		}
+		retryable, retryAfter := retryableError(err)
+		if !retryable {
+			return err
+		}
+
+		wait := rp.policy.backoff(attempt+1, retryAfter)
+		log.Printf("%s: transient error, retrying in %s (%d/%d): %v", rp.inner.ModelInfo(), wait, attempt+1, rp.policy.MaxRetries, err)
+		if notify := retryNotifierFrom(ctx); notify != nil {
+			notify(attempt+1, rp.policy.MaxRetries, wait, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package ai_client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"AI-Dialogue-Map/internal/config"
+
+	"golang.org/x/time/rate"
+)
+
+// flakyProvider は errs の順にエラーを返し、使い切った後は成功するテスト用のプロバイダです。
+// chunkBeforeError が true の場合、ストリーミングではエラーの前に1チャンクを返します。
+type flakyProvider struct {
+	errs             []error
+	chunkBeforeError bool
+	calls            int
+}
+
+func (p *flakyProvider) next() error {
+	p.calls++
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
+}
+
+func (p *flakyProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return &Response{Text: "ok", Model: "flaky-model"}, nil
+}
+
+func (p *flakyProvider) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	if err := p.next(); err != nil {
+		if p.chunkBeforeError && onChunk != nil {
+			onChunk("partial")
+		}
+		return nil, err
+	}
+	if onChunk != nil {
+		onChunk("ok")
+	}
+	return &Response{Text: "ok", Model: "flaky-model"}, nil
+}
+
+func (p *flakyProvider) CountTokens(ctx context.Context, req *Request) (int, error) {
+	if err := p.next(); err != nil {
+		return 0, err
+	}
+	return 42, nil
+}
+
+func (p *flakyProvider) ModelInfo() ModelInfo {
+	return ModelInfo{Provider: "flaky", Model: "flaky-model"}
+}
+
+// fastPolicy は待ち時間を短くした再試行の設定です。
+var fastPolicy = RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+
+func apiError(status int) *APIError {
+	return &APIError{Path: "/test", StatusCode: status, Status: http.StatusText(status)}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{" 12 ", 12 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	future := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got < 80*time.Second || got > 90*time.Second {
+		t.Errorf("parseRetryAfter(%q) = %v, want about 90s", future, got)
+	}
+}
+
+func TestBackoffBounds(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{80, time.Second}, // シフトであふれても上限を使います
+	}
+	for _, tt := range tests {
+		for i := 0; i < 200; i++ {
+			got := policy.backoff(tt.attempt, 0)
+			if got < tt.max/2 || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.max/2, tt.max)
+			}
+		}
+	}
+	if got := policy.backoff(1, 500*time.Millisecond); got != 500*time.Millisecond {
+		t.Errorf("backoff with Retry-After = %v, want 500ms", got)
+	}
+	// 長すぎる Retry-After は MaxDelay までに抑えます。
+	if got := policy.backoff(1, 24*time.Hour); got != time.Second {
+		t.Errorf("backoff with a day of Retry-After = %v, want the 1s maximum", got)
+	}
+}
+
+func TestRetryingProviderRetriesTransientErrors(t *testing.T) {
+	rateLimited := apiError(http.StatusTooManyRequests)
+	rateLimited.RetryAfter = 2 * time.Millisecond
+	inner := &flakyProvider{errs: []error{rateLimited, apiError(http.StatusServiceUnavailable)}}
+	rp := NewRetryingProvider(inner, fastPolicy, nil)
+
+	var waits []time.Duration
+	ctx := WithRetryNotifier(context.Background(), func(attempt int, maxRetries int, wait time.Duration, err error) {
+		if attempt != len(waits)+1 || maxRetries != fastPolicy.MaxRetries {
+			t.Errorf("notify(%d, %d), want attempt %d of %d", attempt, maxRetries, len(waits)+1, fastPolicy.MaxRetries)
+		}
+		waits = append(waits, wait)
+	})
+	resp, err := rp.Generate(ctx, userRequest("hi"))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Text != "ok" || inner.calls != 3 {
+		t.Errorf("response = %+v after %d calls, want ok after 3", resp, inner.calls)
+	}
+	if len(waits) != 2 || waits[0] != 2*time.Millisecond {
+		t.Errorf("waits = %v, want the Retry-After of the 429 first", waits)
+	}
+}
+
+func TestRetryingProviderGivesUp(t *testing.T) {
+	t.Run("max retries", func(t *testing.T) {
+		inner := &flakyProvider{errs: []error{
+			apiError(http.StatusInternalServerError), apiError(http.StatusBadGateway),
+			apiError(http.StatusServiceUnavailable), apiError(http.StatusGatewayTimeout),
+			apiError(http.StatusInternalServerError),
+		}}
+		_, err := NewRetryingProvider(inner, fastPolicy, nil).Generate(context.Background(), userRequest("hi"))
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("err = %v, want the last 504", err)
+		}
+		if inner.calls != fastPolicy.MaxRetries+1 {
+			t.Errorf("calls = %d, want %d", inner.calls, fastPolicy.MaxRetries+1)
+		}
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		inner := &flakyProvider{errs: []error{apiError(http.StatusBadRequest)}}
+		if _, err := NewRetryingProvider(inner, fastPolicy, nil).Generate(context.Background(), userRequest("hi")); err == nil {
+			t.Error("expected the 400 error")
+		}
+		if inner.calls != 1 {
+			t.Errorf("calls = %d, want 1", inner.calls)
+		}
+	})
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		rateLimited := apiError(http.StatusTooManyRequests)
+		rateLimited.RetryAfter = time.Hour
+		inner := &flakyProvider{errs: []error{rateLimited}}
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = WithRetryNotifier(ctx, func(int, int, time.Duration, error) { cancel() })
+		_, err := NewRetryingProvider(inner, fastPolicy, nil).Generate(ctx, userRequest("hi"))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+		if inner.calls != 1 {
+			t.Errorf("calls = %d, want 1", inner.calls)
+		}
+	})
+}
+
+func TestRetryingProviderCountTokens(t *testing.T) {
+	inner := &flakyProvider{errs: []error{apiError(http.StatusTooManyRequests)}}
+	count, err := NewRetryingProvider(inner, fastPolicy, nil).CountTokens(context.Background(), userRequest("hi"))
+	if err != nil || count != 42 || inner.calls != 2 {
+		t.Errorf("CountTokens = %d, %v after %d calls, want 42 after 2", count, err, inner.calls)
+	}
+
+	// トークン数の計測も生成と同じレート制限を待ちます。
+	limiter := rate.NewLimiter(rate.Every(time.Minute), 1)
+	rp := NewRetryingProvider(&flakyProvider{}, fastPolicy, limiter)
+	if _, err := rp.Generate(context.Background(), userRequest("hi")); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := rp.CountTokens(ctx, userRequest("hi")); err == nil {
+		t.Error("CountTokens should wait for the rate limit")
+	}
+}
+
+func TestRetryingProviderStream(t *testing.T) {
+	t.Run("before first chunk", func(t *testing.T) {
+		inner := &flakyProvider{errs: []error{apiError(http.StatusServiceUnavailable)}}
+		var chunks []string
+		resp, err := NewRetryingProvider(inner, fastPolicy, nil).GenerateStream(context.Background(), userRequest("hi"), func(chunk string) {
+			chunks = append(chunks, chunk)
+		})
+		if err != nil || resp.Text != "ok" || inner.calls != 2 {
+			t.Errorf("GenerateStream = %+v, %v after %d calls, want ok after 2", resp, err, inner.calls)
+		}
+		if len(chunks) != 1 || chunks[0] != "ok" {
+			t.Errorf("chunks = %q", chunks)
+		}
+	})
+	t.Run("after a chunk", func(t *testing.T) {
+		inner := &flakyProvider{errs: []error{apiError(http.StatusServiceUnavailable)}, chunkBeforeError: true}
+		var chunks []string
+		_, err := NewRetryingProvider(inner, fastPolicy, nil).GenerateStream(context.Background(), userRequest("hi"), func(chunk string) {
+			chunks = append(chunks, chunk)
+		})
+		if err == nil {
+			t.Error("expected the stream error")
+		}
+		if inner.calls != 1 || len(chunks) != 1 {
+			t.Errorf("calls = %d, chunks = %q; a stream that already returned chunks must not be retried", inner.calls, chunks)
+		}
+	})
+}
+
+func TestLimiterIsSharedPerProvider(t *testing.T) {
+	cfg := config.Config{RateLimits: map[string]config.RateLimit{
+		"limited-a": {RequestsPerMinute: 1},
+		"limited-b": {RequestsPerMinute: 60, Burst: 3},
+	}}
+	a1, a2 := limiterFor(cfg, "limited-a"), limiterFor(cfg, "limited-a")
+	if a1 == nil || a1 != a2 {
+		t.Fatalf("limiterFor should return the same limiter for a provider name (%p, %p)", a1, a2)
+	}
+	if b := limiterFor(cfg, "limited-b"); b == nil || b == a1 || b.Burst() != 3 {
+		t.Errorf("limited-b limiter = %v, want its own limiter with burst 3", b)
+	}
+	if l := limiterFor(cfg, "unlimited"); l != nil {
+		t.Errorf("limiterFor without a limit = %v, want nil", l)
+	}
+
+	// 1分に1回の制限を2つのプロバイダで共有するため、2つ目のリクエストは待たされます。
+	// limiterFor の制限はプロセス全体で共有されるため、ここでは専用の制限を使います。
+	shared := rate.NewLimiter(rate.Every(time.Minute), 1)
+	first := NewRetryingProvider(&flakyProvider{}, fastPolicy, shared)
+	second := NewRetryingProvider(&flakyProvider{}, fastPolicy, shared)
+	if _, err := first.Generate(context.Background(), userRequest("hi")); err != nil {
+		t.Fatalf("first Generate: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := second.Generate(ctx, userRequest("hi")); err == nil {
+		t.Error("second provider should wait for the shared rate limit")
+	}
+}
@@ -0,0 +1,198 @@
+package ai_client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// FinishReason は応答の生成が終了した理由です。
+type FinishReason string
+
+const (
+	FinishReasonStop       FinishReason = "stop"       // 回答を最後まで生成した
+	FinishReasonMaxTokens  FinishReason = "max_tokens" // 最大出力トークン数に達して打ち切られた
+	FinishReasonSafety     FinishReason = "safety"     // 安全性フィルタでブロックされた
+	FinishReasonRecitation FinishReason = "recitation" // 学習データの引用 (著作物の再現) としてブロックされた
+	FinishReasonPrompt     FinishReason = "prompt"     // 質問 (プロンプト) がブロックされた
+	FinishReasonOther      FinishReason = "other"      // その他の理由で中断された
+)
+
+// SafetyRating はカテゴリごとの有害性の評価です。
+type SafetyRating struct {
+	// Category は harassment、hate_speech、sexually_explicit、dangerous_content などです。
+	Category string `yaml:"category"`
+	// Probability は negligible、low、medium、high のいずれかです。
+	Probability string `yaml:"probability"`
+	// Blocked はこの評価によって応答がブロックされたかどうかです。
+	Blocked bool `yaml:"blocked,omitempty"`
+}
+
+// BlockedError は安全性フィルタなどによって応答がブロックされたか、回答が得られないまま生成が中断されたことを表します。
+type BlockedError struct {
+	Reason        FinishReason
+	SafetyRatings []SafetyRating
+}
+
+func (e *BlockedError) Error() string {
+	var blocked []string
+	for _, r := range e.SafetyRatings {
+		if r.Blocked {
+			blocked = append(blocked, r.Category+"="+r.Probability)
+		}
+	}
+	if len(blocked) == 0 {
+		return fmt.Sprintf("response blocked (%s)", e.Reason)
+	}
+	return fmt.Sprintf("response blocked (%s: %s)", e.Reason, strings.Join(blocked, ", "))
+}
+
+// SafetyConfigurer は応答の内容に影響する安全性フィルタの設定を持つプロバイダが実装します。
+type SafetyConfigurer interface {
+	// SafetyConfig は安全性フィルタの設定を "カテゴリ=しきい値" の並びで返します。既定の設定の場合は空です。
+	SafetyConfig() []string
+}
+
+// SafetyConfigOf はプロバイダの安全性フィルタの設定を返します。設定を持たないプロバイダでは nil です。
+func SafetyConfigOf(p Provider) []string {
+	if sc, ok := p.(SafetyConfigurer); ok {
+		return sc.SafetyConfig()
+	}
+	return nil
+}
+
+// geminiHarmCategories はGeminiの有害カテゴリと、安全性評価の記録で使用する名前の対応です。
+// 旧来のカテゴリ (derogatory など) は評価の表示のために残しています。設定ファイルで指定できるのは geminiSafetyCategories だけです。
+var geminiHarmCategories = map[genai.HarmCategory]string{
+	genai.HarmCategoryHarassment:       "harassment",
+	genai.HarmCategoryHateSpeech:       "hate_speech",
+	genai.HarmCategorySexuallyExplicit: "sexually_explicit",
+	genai.HarmCategoryDangerousContent: "dangerous_content",
+	genai.HarmCategoryDerogatory:       "derogatory",
+	genai.HarmCategoryToxicity:         "toxicity",
+	genai.HarmCategoryViolence:         "violence",
+	genai.HarmCategorySexual:           "sexual",
+	genai.HarmCategoryMedical:          "medical",
+	genai.HarmCategoryDangerous:        "dangerous",
+}
+
+// geminiSafetyCategories は設定ファイルの safety_settings で指定できるカテゴリです。
+// Geminiのモデルが安全性設定として受け付けるカテゴリに限ります。名前は config.SafetyCategories と一致させます。
+var geminiSafetyCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+}
+
+// geminiHarmProbabilities はGeminiの有害性の確率の名前です。
+var geminiHarmProbabilities = map[genai.HarmProbability]string{
+	genai.HarmProbabilityNegligible: "negligible",
+	genai.HarmProbabilityLow:        "low",
+	genai.HarmProbabilityMedium:     "medium",
+	genai.HarmProbabilityHigh:       "high",
+}
+
+// geminiHarmThresholds は設定ファイルで指定できるブロックのしきい値です。名前は config.SafetyThresholds と一致させます。
+var geminiHarmThresholds = map[string]genai.HarmBlockThreshold{
+	"block_none":             genai.HarmBlockNone,
+	"block_only_high":        genai.HarmBlockOnlyHigh,
+	"block_medium_and_above": genai.HarmBlockMediumAndAbove,
+	"block_low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+// geminiSafetySettings は設定ファイルの safety_settings (カテゴリ名 → しきい値) をGeminiの安全性設定に変換します。
+// 指定のないカテゴリはAPIの既定のしきい値を使用します。
+func geminiSafetySettings(settings map[string]string) ([]*genai.SafetySetting, error) {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*genai.SafetySetting, 0, len(settings))
+	for _, name := range names {
+		category, ok := geminiSafetyCategories[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown safety category: %s", name)
+		}
+		threshold, ok := geminiHarmThresholds[strings.ToLower(strings.TrimSpace(settings[name]))]
+		if !ok {
+			return nil, fmt.Errorf("unknown safety threshold for %s: %s", name, settings[name])
+		}
+		result = append(result, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+	return result, nil
+}
+
+// geminiSafetyConfig はGeminiの安全性設定を "カテゴリ=しきい値" の並びに変換します。
+func geminiSafetyConfig(settings []*genai.SafetySetting) []string {
+	if len(settings) == 0 {
+		return nil
+	}
+	config := make([]string, 0, len(settings))
+	for _, s := range settings {
+		config = append(config, fmt.Sprintf("%s=%d", geminiHarmCategories[s.Category], s.Threshold))
+	}
+	return config
+}
+
+// geminiFinishReason はGeminiの終了理由を変換します。未指定 (生成途中) の場合は空文字列を返します。
+func geminiFinishReason(r genai.FinishReason) FinishReason {
+	switch r {
+	case genai.FinishReasonUnspecified:
+		return ""
+	case genai.FinishReasonStop:
+		return FinishReasonStop
+	case genai.FinishReasonMaxTokens:
+		return FinishReasonMaxTokens
+	case genai.FinishReasonSafety:
+		return FinishReasonSafety
+	case genai.FinishReasonRecitation:
+		return FinishReasonRecitation
+	default:
+		return FinishReasonOther
+	}
+}
+
+// geminiSafetyRatings はGeminiの安全性評価を変換します。
+func geminiSafetyRatings(ratings []*genai.SafetyRating) []SafetyRating {
+	var result []SafetyRating
+	for _, r := range ratings {
+		if r == nil {
+			continue
+		}
+		category, ok := geminiHarmCategories[r.Category]
+		if !ok {
+			category = strings.ToLower(r.Category.String())
+		}
+		probability, ok := geminiHarmProbabilities[r.Probability]
+		if !ok {
+			probability = "unspecified"
+		}
+		result = append(result, SafetyRating{Category: category, Probability: probability, Blocked: r.Blocked})
+	}
+	return result
+}
+
+// geminiFinish はレスポンスの最初の候補から終了理由と安全性評価を取り出します。
+func geminiFinish(resp *genai.GenerateContentResponse) (FinishReason, []SafetyRating) {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0] == nil {
+		return "", nil
+	}
+	cand := resp.Candidates[0]
+	return geminiFinishReason(cand.FinishReason), geminiSafetyRatings(cand.SafetyRatings)
+}
+
+// geminiBlockedError はGeminiクライアントの genai.BlockedError を BlockedError に変換します。
+func geminiBlockedError(be *genai.BlockedError) *BlockedError {
+	if be.Candidate != nil {
+		return &BlockedError{Reason: geminiFinishReason(be.Candidate.FinishReason), SafetyRatings: geminiSafetyRatings(be.Candidate.SafetyRatings)}
+	}
+	if be.PromptFeedback != nil {
+		return &BlockedError{Reason: FinishReasonPrompt, SafetyRatings: geminiSafetyRatings(be.PromptFeedback.SafetyRatings)}
+	}
+	return &BlockedError{Reason: FinishReasonOther}
+}
@@ -0,0 +1,57 @@
+package ai_client
+
+import (
+	"slices"
+	"sort"
+	"testing"
+
+	"AI-Dialogue-Map/internal/config"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// TestGeminiSafetyNamesMatchConfig は設定の読み込み時に確認する名前と、Geminiの設定に変換できる名前が一致することを確認します。
+func TestGeminiSafetyNamesMatchConfig(t *testing.T) {
+	var categories, thresholds []string
+	for name := range geminiSafetyCategories {
+		categories = append(categories, name)
+	}
+	for name := range geminiHarmThresholds {
+		thresholds = append(thresholds, name)
+	}
+	sort.Strings(categories)
+	sort.Strings(thresholds)
+	wantCategories := append([]string{}, config.SafetyCategories...)
+	wantThresholds := append([]string{}, config.SafetyThresholds...)
+	sort.Strings(wantCategories)
+	sort.Strings(wantThresholds)
+	if !slices.Equal(categories, wantCategories) {
+		t.Errorf("categories = %q, config.SafetyCategories = %q", categories, wantCategories)
+	}
+	if !slices.Equal(thresholds, wantThresholds) {
+		t.Errorf("thresholds = %q, config.SafetyThresholds = %q", thresholds, wantThresholds)
+	}
+}
+
+func TestGeminiSafetySettings(t *testing.T) {
+	settings, err := geminiSafetySettings(map[string]string{
+		"harassment":        "block_only_high",
+		"dangerous_content": "block_none",
+	})
+	if err != nil {
+		t.Fatalf("geminiSafetySettings: %v", err)
+	}
+	if len(settings) != 2 ||
+		settings[0].Category != genai.HarmCategoryDangerousContent || settings[0].Threshold != genai.HarmBlockNone ||
+		settings[1].Category != genai.HarmCategoryHarassment || settings[1].Threshold != genai.HarmBlockOnlyHigh {
+		t.Errorf("settings = %+v", settings)
+	}
+
+	// 評価の表示には使う旧来のカテゴリも、設定としては受け付けません。
+	if _, err := geminiSafetySettings(map[string]string{"medical": "block_none"}); err == nil {
+		t.Errorf("legacy category was accepted")
+	}
+	if ratings := geminiSafetyRatings([]*genai.SafetyRating{{Category: genai.HarmCategoryMedical, Probability: genai.HarmProbabilityLow}}); len(ratings) != 1 || ratings[0].Category != "medical" {
+		t.Errorf("ratings = %+v", ratings)
+	}
+}
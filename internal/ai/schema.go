@@ -0,0 +1,80 @@
+package ai_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// SchemaType はJSONスキーマの型名です。
+type SchemaType string
+
+const (
+	SchemaObject  SchemaType = "object"
+	SchemaArray   SchemaType = "array"
+	SchemaString  SchemaType = "string"
+	SchemaNumber  SchemaType = "number"
+	SchemaInteger SchemaType = "integer"
+	SchemaBoolean SchemaType = "boolean"
+)
+
+// Schema は構造化出力に使用するJSONスキーマのサブセットです。
+// 各プロバイダの形式 (Geminiの genai.Schema、OpenAI/OllamaのJSON Schema) に変換して使用します。
+type Schema struct {
+	Type        SchemaType         `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+}
+
+// toGenai は Schema をGemini APIのスキーマに変換します。
+func (s *Schema) toGenai() *genai.Schema {
+	if s == nil {
+		return nil
+	}
+	gs := &genai.Schema{
+		Description: s.Description,
+		Required:    s.Required,
+		Items:       s.Items.toGenai(),
+	}
+	switch s.Type {
+	case SchemaObject:
+		gs.Type = genai.TypeObject
+	case SchemaArray:
+		gs.Type = genai.TypeArray
+	case SchemaNumber:
+		gs.Type = genai.TypeNumber
+	case SchemaInteger:
+		gs.Type = genai.TypeInteger
+	case SchemaBoolean:
+		gs.Type = genai.TypeBoolean
+	default:
+		gs.Type = genai.TypeString
+	}
+	if len(s.Properties) > 0 {
+		gs.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			gs.Properties[name] = prop.toGenai()
+		}
+	}
+	return gs
+}
+
+// DecodeJSONResponse は構造化出力の応答テキストを v にデコードします。
+// モデルがコードブロック (```json ... ```) で囲んで返した場合も受け付けます。
+func DecodeJSONResponse(text string, v interface{}) error {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "```") {
+		trimmed = strings.TrimPrefix(trimmed, "```json")
+		trimmed = strings.TrimPrefix(trimmed, "```")
+		trimmed = strings.TrimSuffix(trimmed, "```")
+		trimmed = strings.TrimSpace(trimmed)
+	}
+	if err := json.Unmarshal([]byte(trimmed), v); err != nil {
+		return fmt.Errorf("failed to decode structured response: %w", err)
+	}
+	return nil
+}
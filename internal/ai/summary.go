@@ -0,0 +1,42 @@
+package ai_client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SummarizeConversation は会話ターンを、以前の要約に続けて1つの要約にまとめます。
+// previousSummary が空の場合は turns のみを要約します。turns は古い順のユーザー/モデルのメッセージです。
+func SummarizeConversation(ctx context.Context, p Provider, previousSummary string, turns []Message) (string, error) {
+	var b strings.Builder
+	b.WriteString("次の会話を、後続の質問に答えるための文脈として使えるように要約してください。")
+	b.WriteString("重要な事実、決定事項、前提条件、未解決の論点を漏らさず、簡潔な箇条書きで書いてください。要約のみを出力してください。\n\n")
+	if previousSummary != "" {
+		b.WriteString("# これまでの要約\n")
+		b.WriteString(previousSummary)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("# 続きの会話\n")
+	for _, m := range turns {
+		if m.Role == RoleModel {
+			b.WriteString("## AI\n")
+		} else {
+			b.WriteString("## ユーザー\n")
+		}
+		b.WriteString(m.Text)
+		b.WriteString("\n\n")
+	}
+
+	resp, err := p.Generate(ctx, &Request{
+		Messages: []Message{{Role: RoleUser, Text: b.String()}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+	summary := strings.TrimSpace(resp.Text)
+	if summary == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return summary, nil
+}
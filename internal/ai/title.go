@@ -0,0 +1,43 @@
+package ai_client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// titleSchema はタイトル生成で要求する構造化出力のスキーマです。
+var titleSchema = &Schema{
+	Type: SchemaObject,
+	Properties: map[string]*Schema{
+		"title": {Type: SchemaString, Description: "質問と回答の内容を要約した短いタイトル"},
+	},
+	Required: []string{"title"},
+}
+
+// titleResponse はタイトル生成の構造化出力です。
+type titleResponse struct {
+	Title string `json:"title"`
+}
+
+// GenerateTitle は質問と回答から、ノードに表示する短いタイトルを生成します。
+// 回答本文とは別の軽量なリクエストとして構造化出力 (JSON) で取得します。
+func GenerateTitle(ctx context.Context, p Provider, question string, answer string) (string, error) {
+	prompt := fmt.Sprintf("次の質問と回答の内容を表す、30文字程度までの簡潔なタイトルを1つ付けてください。\n\n# 質問\n%s\n\n# 回答\n%s", question, answer)
+	resp, err := p.Generate(ctx, &Request{
+		Messages:       []Message{{Role: RoleUser, Text: prompt}},
+		ResponseSchema: titleSchema,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate title: %w", err)
+	}
+	var parsed titleResponse
+	if err := DecodeJSONResponse(resp.Text, &parsed); err != nil {
+		return "", err
+	}
+	title := strings.TrimSpace(parsed.Title)
+	if title == "" {
+		return "", fmt.Errorf("model returned an empty title")
+	}
+	return title, nil
+}
@@ -0,0 +1,126 @@
+package ai_client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"unicode/utf8"
+)
+
+// messageOverheadTokens はロールや区切りのために1メッセージごとに加算する推定トークン数です。
+const messageOverheadTokens = 4
+
+// imageTokens は添付画像1枚あたりの推定トークン数です (Geminiの画像1枚あたりのトークン数)。
+const imageTokens = 258
+
+// TokenCounter はリクエストのトークン数をAPIで数えられるプロバイダが実装します。
+type TokenCounter interface {
+	CountTokens(ctx context.Context, req *Request) (int, error)
+}
+
+// ErrTokenCountUnsupported はプロバイダがトークン数の計測に対応していないことを表します。
+var ErrTokenCountUnsupported = errors.New("token counting is not supported by this provider")
+
+// CountRequestTokens はリクエストのトークン数を返します。
+// プロバイダが TokenCounter を実装していない場合は推定値を返し、exact=false とします。
+func CountRequestTokens(ctx context.Context, p Provider, req *Request) (count int, exact bool, err error) {
+	if tc, ok := p.(TokenCounter); ok {
+		count, err := tc.CountTokens(ctx, req)
+		if err == nil {
+			return count, true, nil
+		}
+		if errors.Is(err, ErrTokenCountUnsupported) {
+			return EstimateRequestTokens(req), false, nil
+		}
+		return EstimateRequestTokens(req), false, err
+	}
+	return EstimateRequestTokens(req), false, nil
+}
+
+// EstimateTokens はテキストのおおよそのトークン数を推定します。
+// ASCII文字は4文字で1トークン、それ以外 (日本語など) は1文字で1トークンとして数えます。
+func EstimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// EstimateRequestTokens はシステム指示とすべてのメッセージを含むリクエスト全体のトークン数を推定します。
+func EstimateRequestTokens(req *Request) int {
+	if req == nil {
+		return 0
+	}
+	total := 0
+	if req.System != "" {
+		total += EstimateTokens(req.System) + messageOverheadTokens
+	}
+	for _, m := range req.Messages {
+		total += EstimateTokens(m.Text) + messageOverheadTokens + len(m.Images)*imageTokens
+	}
+	return total
+}
+
+// TrimToBudget はリクエストのトークン数が budget 以下になるまで、古い会話ターンから順に取り除きます。
+// プロバイダが TokenCounter を実装している場合はAPIで1回だけ数え、取り除いた後のトークン数は TrimCountedToBudget で推定します。
+// 最後のユーザーメッセージと Pinned のメッセージ (会話履歴の要約) は常に残します。
+// 取り除いたメッセージ数を返します。budget が0以下の場合は何もしません。
+func TrimToBudget(ctx context.Context, p Provider, req *Request, budget int) int {
+	if req == nil || budget <= 0 {
+		return 0
+	}
+	count, _, err := CountRequestTokens(ctx, p, req)
+	if err != nil {
+		log.Printf("Failed to count tokens, trimming by estimate: %v", err)
+	}
+	dropped, _ := TrimCountedToBudget(req, count, budget)
+	return dropped
+}
+
+// TrimCountedToBudget は数え済みのトークン数 count を基に、リクエストが budget 以下になるまで古い会話ターンから取り除きます。
+// 取り除いた後のトークン数はAPIで数え直さず、推定値の変化を count と推定値の比率で換算して求めます (切り上げ)。
+// 取り除いたメッセージ数と、取り除いた後のトークン数を返します。budget が0以下の場合は何もしません。
+func TrimCountedToBudget(req *Request, count int, budget int) (dropped int, remaining int) {
+	if req == nil || budget <= 0 || count <= budget {
+		return 0, count
+	}
+	estimate := EstimateRequestTokens(req)
+	if estimate <= 0 {
+		return 0, count
+	}
+	remaining = count
+	for remaining > budget {
+		n := dropOldestTurn(req)
+		if n == 0 {
+			break
+		}
+		dropped += n
+		remaining = (EstimateRequestTokens(req)*count + estimate - 1) / estimate
+	}
+	return dropped, remaining
+}
+
+// dropOldestTurn は Pinned でない最も古いメッセージを取り除き、取り除いたメッセージ数を返します。
+// ユーザー/モデルの組を崩さないよう、続くメッセージがモデルの発言の場合は一緒に取り除きます。
+// 最後のメッセージは取り除かないため、取り除けるメッセージがない場合は0を返します。
+func dropOldestTurn(req *Request) int {
+	last := len(req.Messages) - 1
+	i := 0
+	for i < last && req.Messages[i].Pinned {
+		i++
+	}
+	if i >= last {
+		return 0
+	}
+	n := 1
+	if i+1 < last && req.Messages[i+1].Role == RoleModel {
+		n = 2
+	}
+	req.Messages = append(req.Messages[:i:i], req.Messages[i+n:]...)
+	return n
+}
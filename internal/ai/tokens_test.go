@@ -0,0 +1,216 @@
+package ai_client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+// countingProvider はメッセージ1件を perMessage トークンとして数える TokenCounter です。
+type countingProvider struct {
+	perMessage int
+	calls      int
+}
+
+func (p *countingProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	return &Response{}, nil
+}
+
+func (p *countingProvider) GenerateStream(ctx context.Context, req *Request, onChunk func(chunk string)) (*Response, error) {
+	return &Response{}, nil
+}
+
+func (p *countingProvider) ModelInfo() ModelInfo {
+	return ModelInfo{Provider: "test"}
+}
+
+func (p *countingProvider) CountTokens(ctx context.Context, req *Request) (int, error) {
+	p.calls++
+	return len(req.Messages) * p.perMessage, nil
+}
+
+// conversation は n 組のユーザー/モデルのターンと最後の質問からなるリクエストを作成します。
+func conversation(n int) *Request {
+	req := &Request{}
+	for i := 0; i < n; i++ {
+		req.Messages = append(req.Messages,
+			Message{Role: RoleUser, Text: "question"},
+			Message{Role: RoleModel, Text: "answer"},
+		)
+	}
+	req.Messages = append(req.Messages, Message{Role: RoleUser, Text: "new question"})
+	return req
+}
+
+func TestTrimToBudgetUsesTokenCounter(t *testing.T) {
+	tests := []struct {
+		name        string
+		budget      int
+		wantDropped int
+	}{
+		{name: "within budget", budget: 600, wantDropped: 0},
+		{name: "exactly at budget", budget: 500, wantDropped: 0},
+		{name: "one turn over", budget: 400, wantDropped: 2},
+		{name: "only the question fits", budget: 100, wantDropped: 4},
+		{name: "nothing fits", budget: 10, wantDropped: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 推定値では予算内に収まる短いメッセージでも、APIで数えた値 (1件100トークン) で判定します。
+			p := &countingProvider{perMessage: 100}
+			req := conversation(2)
+			if got := TrimToBudget(context.Background(), p, req, tt.budget); got != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", got, tt.wantDropped)
+			}
+			if last := req.Messages[len(req.Messages)-1]; last.Text != "new question" {
+				t.Errorf("last message = %q, want the new question", last.Text)
+			}
+			if p.calls != 1 {
+				t.Errorf("CountTokens was called %d times, want once", p.calls)
+			}
+		})
+	}
+}
+
+func TestTrimCountedToBudget(t *testing.T) {
+	// 推定値の16倍を数えた値として渡すと、取り除いた後も同じ比率で換算します。
+	req := conversation(2)
+	estimate := EstimateRequestTokens(req)
+	count := estimate * 16
+	dropped, remaining := TrimCountedToBudget(req, count, count-1)
+	if dropped != 2 {
+		t.Errorf("dropped = %d, want 2", dropped)
+	}
+	if want := EstimateRequestTokens(req) * 16; remaining != want {
+		t.Errorf("remaining = %d, want %d", remaining, want)
+	}
+
+	req = conversation(2)
+	if dropped, remaining := TrimCountedToBudget(req, 100, 0); dropped != 0 || remaining != 100 {
+		t.Errorf("without a budget: dropped = %d, remaining = %d, want 0 and 100", dropped, remaining)
+	}
+	if len(req.Messages) != 5 {
+		t.Errorf("request was trimmed without a budget: %d messages", len(req.Messages))
+	}
+}
+
+func TestTrimToBudgetEstimatesWithoutTokenCounter(t *testing.T) {
+	req := conversation(3)
+	budget := EstimateRequestTokens(req) - 1
+	dropped := TrimToBudget(context.Background(), nil, req, budget)
+	if dropped != 2 {
+		t.Errorf("dropped = %d, want 2", dropped)
+	}
+	if EstimateRequestTokens(req) > budget {
+		t.Errorf("estimate %d still exceeds budget %d", EstimateRequestTokens(req), budget)
+	}
+}
+
+func TestTrimToBudgetKeepsPinnedSummary(t *testing.T) {
+	req := conversation(3)
+	summary := []Message{
+		{Role: RoleUser, Text: "summary", Pinned: true},
+		{Role: RoleModel, Text: "ok", Pinned: true},
+	}
+	req.Messages = append(summary, req.Messages...)
+
+	p := &countingProvider{perMessage: 100}
+	if dropped := TrimToBudget(context.Background(), p, req, 500); dropped != 4 {
+		t.Errorf("dropped = %d, want 4", dropped)
+	}
+	want := []string{"summary", "ok", "question", "answer", "new question"}
+	if len(req.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(req.Messages), len(want))
+	}
+	for i, m := range req.Messages {
+		if m.Text != want[i] {
+			t.Errorf("messages[%d] = %q, want %q", i, m.Text, want[i])
+		}
+	}
+
+	// 要約以外に取り除けるターンがない場合は、予算を超えていても要約を残します。
+	if dropped := TrimToBudget(context.Background(), p, req, 100); dropped != 2 {
+		t.Errorf("dropped = %d, want 2", dropped)
+	}
+	if len(req.Messages) != 3 || !req.Messages[0].Pinned {
+		t.Errorf("summary was removed: %+v", req.Messages)
+	}
+}
+
+func TestTrimToBudgetDoesNotModifySharedMessages(t *testing.T) {
+	base := conversation(2)
+	req := *base
+	TrimToBudget(context.Background(), &countingProvider{perMessage: 100}, &req, 300)
+	if base.Messages[0].Text != "question" || len(base.Messages) != 5 {
+		t.Errorf("base request was modified: %+v", base.Messages)
+	}
+}
+
+func TestGeminiCountTokensSendsConversation(t *testing.T) {
+	var body struct {
+		Model                  string `json:"model"`
+		GenerateContentRequest struct {
+			Contents []struct {
+				Role  string `json:"role"`
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+			SystemInstruction struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"systemInstruction"`
+		} `json:"generateContentRequest"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/models/gemini-test:countTokens") {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid request body %s: %v", data, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"totalTokens": 42}`)
+	}))
+	defer srv.Close()
+
+	gc, err := newGeminiClient("gemini-test", option.WithAPIKey("test-key"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatalf("newGeminiClient: %v", err)
+	}
+	req := conversation(1)
+	req.System = "system prompt"
+	count, err := gc.CountTokens(context.Background(), req)
+	if err != nil || count != 42 {
+		t.Fatalf("CountTokens = %d, %v, want 42", count, err)
+	}
+
+	if body.Model != "models/gemini-test" {
+		t.Errorf("model = %q", body.Model)
+	}
+	var roles, texts []string
+	for _, c := range body.GenerateContentRequest.Contents {
+		roles = append(roles, c.Role)
+		for _, p := range c.Parts {
+			texts = append(texts, p.Text)
+		}
+	}
+	if want := []string{"user", "model", "user"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("roles = %v, want %v", roles, want)
+	}
+	if want := []string{"question", "answer", "new question"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("texts = %v, want %v", texts, want)
+	}
+	if parts := body.GenerateContentRequest.SystemInstruction.Parts; len(parts) != 1 || parts[0].Text != "system prompt" {
+		t.Errorf("system instruction = %+v, want the system prompt", parts)
+	}
+}
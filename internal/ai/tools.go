@@ -0,0 +1,147 @@
+package ai_client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// maxToolRounds はツール呼び出しを繰り返す回数の上限です。
+const maxToolRounds = 5
+
+// Tool はモデルが呼び出せるローカルのツールです。
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters は引数のスキーマです (object 型)。
+	Parameters *Schema
+	// Run はツールを実行し、モデルに返す結果のテキストを返します。
+	Run func(ctx context.Context, args map[string]interface{}) (string, error) `json:"-"`
+}
+
+// ToolCall はモデルが要求した1回のツール呼び出しです。
+type ToolCall struct {
+	// ID はOpenAI互換APIで呼び出しと結果を対応付けるIDです。IDを返さないプロバイダでは空文字列です。
+	ID   string                 `yaml:"id,omitempty"`
+	Name string                 `yaml:"name"`
+	Args map[string]interface{} `yaml:"args,omitempty"`
+}
+
+// ToolResult はツール呼び出しの実行結果です。
+type ToolResult struct {
+	CallID  string
+	Name    string
+	Content string
+	// IsError はツールの実行に失敗し、Content がエラーメッセージであることを表します。
+	IsError bool
+}
+
+// ToolCallObserver はツールを実行するたびに、呼び出しと結果を受け取ります。
+type ToolCallObserver func(call ToolCall, result ToolResult)
+
+// GenerateStreamWithTools はツールを使いながら応答をストリーミングで生成します。
+// モデルがツール呼び出しを要求した場合は req.Tools から該当するツールを実行して結果を返し、
+// 最終的な回答が得られるまで繰り返します。ツールを持たないリクエストは GenerateStream と同じです。
+func GenerateStreamWithTools(ctx context.Context, p Provider, req *Request, onChunk func(chunk string), onToolCall ToolCallObserver) (*Response, error) {
+	r := *req
+	r.Messages = append([]Message{}, req.Messages...)
+	for round := 1; ; round++ {
+		resp, err := p.GenerateStream(ctx, &r, onChunk)
+		if err != nil || len(resp.ToolCalls) == 0 {
+			return resp, err
+		}
+		if round >= maxToolRounds {
+			return resp, fmt.Errorf("tool call limit exceeded (%d rounds)", maxToolRounds)
+		}
+
+		results := make([]ToolResult, 0, len(resp.ToolCalls))
+		for _, call := range resp.ToolCalls {
+			result := runTool(ctx, r.Tools, call)
+			if onToolCall != nil {
+				onToolCall(call, result)
+			}
+			results = append(results, result)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r.Messages = append(r.Messages,
+			Message{Role: RoleModel, Text: resp.Text, ToolCalls: resp.ToolCalls},
+			Message{Role: RoleTool, ToolResults: results},
+		)
+	}
+}
+
+// functionTool はOpenAI互換APIとOllamaの tools に指定する関数ツールの定義です。
+type functionTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description,omitempty"`
+		Parameters  *Schema `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+// functionTools はツールを tools の定義に変換します。ツールがない場合はnilを返します。
+func functionTools(tools []*Tool) []functionTool {
+	if len(tools) == 0 {
+		return nil
+	}
+	defs := make([]functionTool, 0, len(tools))
+	for _, tool := range tools {
+		def := functionTool{Type: "function"}
+		def.Function.Name = tool.Name
+		def.Function.Description = tool.Description
+		def.Function.Parameters = tool.Parameters
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// encodeToolArgs はツールの引数をJSON文字列にします。引数がない場合は "{}" を返します。
+func encodeToolArgs(args map[string]interface{}) string {
+	if len(args) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// decodeToolArgs はJSON文字列のツールの引数を読み取ります。空文字列は引数なしとして扱います。
+func decodeToolArgs(name string, arguments string) (map[string]interface{}, error) {
+	if arguments == "" {
+		return nil, nil
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return nil, fmt.Errorf("failed to decode arguments of tool call %s: %w", name, err)
+	}
+	return args, nil
+}
+
+// runTool は名前が一致するツールを実行します。ツールのエラーはモデルに伝えるため、結果として返します。
+func runTool(ctx context.Context, tools []*Tool, call ToolCall) ToolResult {
+	result := ToolResult{CallID: call.ID, Name: call.Name}
+	for _, tool := range tools {
+		if tool.Name != call.Name {
+			continue
+		}
+		content, err := tool.Run(ctx, call.Args)
+		if err != nil {
+			log.Printf("Tool %s failed: %v", call.Name, err)
+			result.Content = err.Error()
+			result.IsError = true
+			return result
+		}
+		log.Printf("Tool %s returned %d bytes", call.Name, len(content))
+		result.Content = content
+		return result
+	}
+	result.Content = fmt.Sprintf("unknown tool: %s", call.Name)
+	result.IsError = true
+	return result
+}
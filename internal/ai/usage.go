@@ -0,0 +1,37 @@
+package ai_client
+
+import "context"
+
+// Usage は1回のリクエストで消費したトークン数です。
+type Usage struct {
+	PromptTokens   int
+	ResponseTokens int
+}
+
+// IsZero はトークン数が記録されていないかどうかを返します。
+func (u Usage) IsZero() bool {
+	return u.PromptTokens == 0 && u.ResponseTokens == 0
+}
+
+// UsageRecorder は生成リクエストが完了するたびに、応答したモデルと消費したトークン数を受け取ります。
+type UsageRecorder func(info ModelInfo, usage Usage)
+
+type usageRecorderKey struct{}
+
+// WithUsageRecorder はトークン使用量を記録する関数をコンテキストに設定します。
+// 回答の生成に加え、同じコンテキストで行ったタイトル生成や要約の使用量も記録されます。
+func WithUsageRecorder(ctx context.Context, record UsageRecorder) context.Context {
+	return context.WithValue(ctx, usageRecorderKey{}, record)
+}
+
+// recordUsage はコンテキストに記録関数が設定されていれば、応答の使用量を渡します。
+func recordUsage(ctx context.Context, info ModelInfo, resp *Response) {
+	record, _ := ctx.Value(usageRecorderKey{}).(UsageRecorder)
+	if record == nil || resp == nil || resp.Usage.IsZero() {
+		return
+	}
+	if resp.Model != "" {
+		info.Model = resp.Model
+	}
+	record(info, resp.Usage)
+}
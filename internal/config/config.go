@@ -4,6 +4,9 @@ import (
 	"bytes"
 	_ "embed"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -13,15 +16,141 @@ var secretTOMLContent []byte
 
 // Config はアプリケーションの設定を保持します。
 type Config struct {
+	// Provider は使用するAIプロバイダ名です ("gemini", "openai", "ollama")。未指定の場合はGeminiを使用します。
+	Provider     string `mapstructure:"provider"`
 	GeminiAPIKey string `mapstructure:"gemini_api_key"`
+	GeminiModel  string `mapstructure:"gemini_model"`
+	// SafetySettings はGeminiの安全性フィルタのカテゴリごとのしきい値です (例: harassment = "block_only_high")。
+	// 指定のないカテゴリはAPIの既定値を使用します。
+	SafetySettings map[string]string `mapstructure:"safety_settings"`
+	// GeminiEmbeddingModel は類似ノード検索の埋め込みに使用するモデルです (既定: text-embedding-004)。
+	GeminiEmbeddingModel string `mapstructure:"gemini_embedding_model"`
+
+	// OpenAI互換API (/v1/chat/completions) の設定です。
+	OpenAIBaseURL string `mapstructure:"openai_base_url"`
+	OpenAIAPIKey  string `mapstructure:"openai_api_key"`
+	OpenAIModel   string `mapstructure:"openai_model"`
+	// OpenAIEmbeddingModel は /embeddings で使用するモデルです (既定: text-embedding-3-small)。
+	OpenAIEmbeddingModel string `mapstructure:"openai_embedding_model"`
+
+	// ローカルのOllamaデーモンの設定です。OllamaModel が空の場合はインストール済みの先頭のモデルを使用します。
+	OllamaHost  string `mapstructure:"ollama_host"`
+	OllamaModel string `mapstructure:"ollama_model"`
+	// OllamaEmbeddingModel は /api/embed で使用するローカルの埋め込みモデルです (既定: nomic-embed-text)。
+	OllamaEmbeddingModel string `mapstructure:"ollama_embedding_model"`
+
+	// FanOutProviders は比較送信で同時に問い合わせる対象です。
+	// "provider" または "provider:model" の形式で指定します (例: ["gemini", "ollama:llama3.2"])。
+	FanOutProviders []string `mapstructure:"fan_out_providers"`
+
+	// 一時的なエラー (429/5xx) の再試行設定です。MaxRetries を0にすると再試行しません。
+	MaxRetries       int `mapstructure:"max_retries"`
+	RetryBaseDelayMs int `mapstructure:"retry_base_delay_ms"`
+	RetryMaxDelayMs  int `mapstructure:"retry_max_delay_ms"`
+
+	// ContextTokenBudget はリクエスト1回あたりのトークン数の上限です。
+	// 深い分岐で超える場合は古い祖先の会話から取り除きます。0の場合は制限しません。
+	ContextTokenBudget int `mapstructure:"context_token_budget"`
+
+	// HistorySummaryTurns は要約せずにそのまま送る直近の祖先の会話ターン数です。
+	// これより古い祖先は要約に置き換えて送信します。0の場合は要約しません。
+	HistorySummaryTurns int `mapstructure:"history_summary_turns"`
+
+	// FollowUpCount は「フォローアップ質問を提案」で提案する質問の数です (既定: 3)。
+	FollowUpCount int `mapstructure:"follow_up_count"`
+
+	// Explore* は「このノードから探索」の既定値です。探索ダイアログで変更できます。
+	ExploreDepth    int `mapstructure:"explore_depth"`
+	ExploreBreadth  int `mapstructure:"explore_breadth"`
+	ExploreMaxNodes int `mapstructure:"explore_max_nodes"`
+	// ExploreRequestsPerMinute は探索中の1分あたりのリクエスト数の上限です。0以下の場合は rate_limits のみを適用します。
+	ExploreRequestsPerMinute float64 `mapstructure:"explore_requests_per_minute"`
+
+	// ToolsEnabled はモデルに組み込みのツール (ファイル読み込み、ノード検索、計算など) を使わせるかどうかです (既定: true)。
+	ToolsEnabled bool `mapstructure:"tools_enabled"`
+
+	// RetrievalEnabled は「関連ノードを参照」の初期状態です (既定: false)。
+	RetrievalEnabled bool `mapstructure:"retrieval_enabled"`
+	// RetrievalTopK は「関連ノードを参照」で質問に加える関連ノードの最大数です (既定: 3)。
+	RetrievalTopK int `mapstructure:"retrieval_top_k"`
+
+	// EmbeddingProvider は類似ノード検索の埋め込みに使用するプロバイダ名です。未指定の場合は provider と同じです。
+	EmbeddingProvider string `mapstructure:"embedding_provider"`
+	// EmbeddingIndex は保存時に埋め込みインデックスを更新するかどうかです (既定: true)。
+	EmbeddingIndex bool `mapstructure:"embedding_index"`
+
+	// Prices はモデルごとのトークン単価です。使用量レポートで費用の計算に使用します。
+	Prices []ModelPrice `mapstructure:"prices"`
+	// PriceCurrency は単価の通貨の表示名です (既定: USD)。
+	PriceCurrency string `mapstructure:"price_currency"`
+
+	// ResponseCache は同一のリクエストに対する応答をディスクにキャッシュするかどうかです (既定: true)。
+	ResponseCache bool `mapstructure:"response_cache"`
+	// ResponseCacheDir はキャッシュの保存先ディレクトリです (既定: cache)。
+	ResponseCacheDir string `mapstructure:"response_cache_dir"`
+	// ResponseCacheTTLHours はキャッシュの有効期間 (時間) です。0以下の場合は期限なしです (既定: 168)。
+	ResponseCacheTTLHours float64 `mapstructure:"response_cache_ttl_hours"`
+	// ResponseCacheMaxMB はキャッシュの合計サイズの上限 (MB) です。超えた場合は古いものから削除します (既定: 100)。
+	ResponseCacheMaxMB int `mapstructure:"response_cache_max_mb"`
+
+	// ReplayFixtures は provider = "replay" で使用するフィクスチャファイルのパスです。
+	ReplayFixtures string `mapstructure:"replay_fixtures"`
+	// ReplayMode は "replay" (記録を再生) または "record" (replay_record_provider の応答を記録) です。
+	ReplayMode string `mapstructure:"replay_mode"`
+	// ReplayRecordProvider は記録モードで実際に問い合わせるプロバイダ名です (既定: gemini)。
+	ReplayRecordProvider string `mapstructure:"replay_record_provider"`
+
+	// RateLimits はプロバイダ名ごとのクライアント側のレート制限です。
+	RateLimits map[string]RateLimit `mapstructure:"rate_limits"`
+}
+
+// ModelPrice は1モデルの100万トークンあたりの単価です。
+// モデル名に "." を含むことが多いため、テーブルではなく配列 ([[prices]]) で指定します。
+type ModelPrice struct {
+	Model            string  `mapstructure:"model"`
+	InputPerMillion  float64 `mapstructure:"input_per_million"`
+	OutputPerMillion float64 `mapstructure:"output_per_million"`
 }
 
+// RateLimit はトークンバケット方式のレート制限の設定です。
+type RateLimit struct {
+	// RequestsPerMinute は1分あたりのリクエスト数の上限です。0以下の場合は制限しません。
+	RequestsPerMinute float64 `mapstructure:"requests_per_minute"`
+	// Burst は連続して送信できるリクエスト数です。未指定の場合は1です。
+	Burst int `mapstructure:"burst"`
+}
+
+// SafetyCategories は safety_settings で指定できるGeminiの有害カテゴリです。
+var SafetyCategories = []string{"harassment", "hate_speech", "sexually_explicit", "dangerous_content"}
+
+// SafetyThresholds は safety_settings で指定できるしきい値です。
+var SafetyThresholds = []string{"block_none", "block_only_high", "block_medium_and_above", "block_low_and_above"}
+
 var Cfg Config
 
 // loadConfig は設定ファイルから設定を読み込みます。
 func LoadConfig() error {
 	v := viper.New()
 	v.SetConfigType("toml") // 設定ファイルの形式
+	v.SetDefault("max_retries", 3)
+	v.SetDefault("retry_base_delay_ms", 1000)
+	v.SetDefault("retry_max_delay_ms", 30000)
+	v.SetDefault("history_summary_turns", 8)
+	v.SetDefault("follow_up_count", 3)
+	v.SetDefault("explore_depth", 2)
+	v.SetDefault("explore_breadth", 2)
+	v.SetDefault("explore_max_nodes", 20)
+	v.SetDefault("tools_enabled", true)
+	v.SetDefault("retrieval_top_k", 3)
+	v.SetDefault("embedding_index", true)
+	v.SetDefault("price_currency", "USD")
+	v.SetDefault("response_cache", true)
+	v.SetDefault("response_cache_dir", "cache")
+	v.SetDefault("response_cache_ttl_hours", 168)
+	v.SetDefault("response_cache_max_mb", 100)
+	v.SetDefault("replay_fixtures", "fixtures/replay.yaml")
+	v.SetDefault("replay_mode", "replay")
+	v.SetDefault("replay_record_provider", "gemini")
 	if err := v.ReadConfig(bytes.NewReader(secretTOMLContent)); err != nil {
 		return fmt.Errorf("failed to read embedded config: %w", err)
 	}
@@ -29,5 +158,27 @@ func LoadConfig() error {
 	if err := v.Unmarshal(&Cfg); err != nil {
 		return fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := validateSafetySettings(Cfg.SafetySettings); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateSafetySettings は safety_settings のカテゴリとしきい値が指定できる値かどうかを確認します。
+// Geminiは未対応のカテゴリを含む設定ですべてのリクエストを拒否するため、読み込み時にキーを示して失敗させます。
+func validateSafetySettings(settings map[string]string) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !slices.Contains(SafetyCategories, strings.ToLower(strings.TrimSpace(name))) {
+			return fmt.Errorf("unknown safety_settings category %q (supported: %s)", name, strings.Join(SafetyCategories, ", "))
+		}
+		if !slices.Contains(SafetyThresholds, strings.ToLower(strings.TrimSpace(settings[name]))) {
+			return fmt.Errorf("unknown safety_settings threshold for %q: %q (supported: %s)", name, settings[name], strings.Join(SafetyThresholds, ", "))
+		}
+	}
 	return nil
 }
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSafetySettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		wantErr  string
+	}{
+		{name: "empty", settings: nil},
+		{
+			name: "all supported categories",
+			settings: map[string]string{
+				"harassment":        "block_none",
+				"hate_speech":       "block_only_high",
+				"sexually_explicit": "block_medium_and_above",
+				"dangerous_content": "block_low_and_above",
+			},
+		},
+		{name: "case and spaces are ignored", settings: map[string]string{" Harassment ": "BLOCK_NONE"}},
+		{name: "legacy category", settings: map[string]string{"derogatory": "block_none"}, wantErr: `"derogatory"`},
+		{name: "unknown category", settings: map[string]string{"harassment": "block_none", "violence": "block_none"}, wantErr: `"violence"`},
+		{name: "unknown threshold", settings: map[string]string{"hate_speech": "block_all"}, wantErr: `threshold for "hate_speech": "block_all"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSafetySettings(tt.settings)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateSafetySettings: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to mention %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package search はプロジェクト内のノードを検索するための語彙ベースのランキング (BM25) を提供します。
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25のパラメータです。一般的な既定値を使用します。
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Document は検索対象の文書です。
+type Document struct {
+	ID   string
+	Text string
+}
+
+// Result は検索結果の1件です。Score が大きいほど関連度が高いことを表します。
+type Result struct {
+	ID    string
+	Score float64
+}
+
+// Index は文書集合に対するBM25の転置インデックスです。
+type Index struct {
+	ids      []string
+	termFreq []map[string]int
+	lengths  []int
+	docFreq  map[string]int
+	avgLen   float64
+}
+
+// NewIndex は文書集合からインデックスを作成します。
+func NewIndex(docs []Document) *Index {
+	idx := &Index{
+		ids:      make([]string, 0, len(docs)),
+		termFreq: make([]map[string]int, 0, len(docs)),
+		lengths:  make([]int, 0, len(docs)),
+		docFreq:  make(map[string]int),
+	}
+	total := 0
+	for _, doc := range docs {
+		tokens := Tokenize(doc.Text)
+		tf := make(map[string]int)
+		for _, t := range tokens {
+			tf[t]++
+		}
+		for t := range tf {
+			idx.docFreq[t]++
+		}
+		idx.ids = append(idx.ids, doc.ID)
+		idx.termFreq = append(idx.termFreq, tf)
+		idx.lengths = append(idx.lengths, len(tokens))
+		total += len(tokens)
+	}
+	if len(docs) > 0 {
+		idx.avgLen = float64(total) / float64(len(docs))
+	}
+	return idx
+}
+
+// Len はインデックスに含まれる文書数を返します。
+func (idx *Index) Len() int {
+	return len(idx.ids)
+}
+
+// Search は query との関連度が高い順に最大 limit 件の文書を返します。スコアが0の文書は含みません。
+func (idx *Index) Search(query string, limit int) []Result {
+	if limit <= 0 || len(idx.ids) == 0 {
+		return nil
+	}
+	terms := make(map[string]struct{})
+	for _, t := range Tokenize(query) {
+		terms[t] = struct{}{}
+	}
+
+	n := float64(len(idx.ids))
+	var results []Result
+	for i, tf := range idx.termFreq {
+		score := 0.0
+		for t := range terms {
+			f := float64(tf[t])
+			if f == 0 {
+				continue
+			}
+			df := float64(idx.docFreq[t])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := 1 - bm25B
+			if idx.avgLen > 0 {
+				norm += bm25B * float64(idx.lengths[i]) / idx.avgLen
+			}
+			score += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+		if score > 0 {
+			results = append(results, Result{ID: idx.ids[i], Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Tokenize はテキストを検索用のトークンに分割します。
+// 英数字は単語単位 (小文字化) で、日本語・中国語・韓国語など空白で区切らない文字は2文字ずつ (bigram) 分割します。
+func Tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	var cjk []rune
+
+	flushWord := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1:
+			tokens = append(tokens, string(cjk))
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+// isCJK は空白で単語を区切らない文字 (漢字・ひらがな・カタカナ・ハングル) かどうかを返します。
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) || r == 'ー'
+}
@@ -0,0 +1,119 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func resultIDs(results []Result) []string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "Hello, World! Go1.24", want: []string{"hello", "world", "go1", "24"}},
+		{text: "機械学習", want: []string{"機械", "械学", "学習"}},
+		{text: "猫", want: []string{"猫"}},
+		{text: "コーヒー", want: []string{"コー", "ーヒ", "ヒー"}},
+		{text: "Goの並行処理", want: []string{"go", "の並", "並行", "行処", "処理"}},
+		{text: "データ、分析", want: []string{"デー", "ータ", "分析"}},
+		{text: "", want: nil},
+		{text: " ... ", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexSearchRanking(t *testing.T) {
+	idx := NewIndex([]Document{
+		{ID: "mutex", Text: "goroutine と mutex による排他制御"},
+		{ID: "channel", Text: "goroutine 間の channel による通信。channel はバッファを持てます。"},
+		{ID: "python", Text: "Python の asyncio"},
+		{ID: "common", Text: "goroutine の起動"},
+	})
+	if idx.Len() != 4 {
+		t.Errorf("Len = %d, want 4", idx.Len())
+	}
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{name: "more matching terms rank first", query: "goroutine channel", limit: 10, want: []string{"channel", "common", "mutex"}},
+		{name: "rare term outweighs common term", query: "goroutine mutex", limit: 10, want: []string{"mutex", "common", "channel"}},
+		{name: "limit", query: "goroutine channel", limit: 1, want: []string{"channel"}},
+		{name: "case insensitive", query: "ASYNCIO", limit: 10, want: []string{"python"}},
+		{name: "no match", query: "rust", limit: 10, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := idx.Search(tt.query, tt.limit)
+			if got := resultIDs(results); len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("Search(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+			for i := 1; i < len(results); i++ {
+				if results[i].Score > results[i-1].Score {
+					t.Errorf("results are not sorted by score: %+v", results)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexSearchJapanese(t *testing.T) {
+	idx := NewIndex([]Document{
+		{ID: "ml", Text: "機械学習の基礎として、教師あり学習と教師なし学習の違いを説明します。"},
+		{ID: "cooking", Text: "カレーのレシピ。玉ねぎをよく炒めます。"},
+		{ID: "machine", Text: "工作機械の保守点検"},
+	})
+	// 空白で区切られていない質問でも、bigramが一致する文書を返します。
+	got := resultIDs(idx.Search("教師あり学習について教えて", 10))
+	if len(got) == 0 || got[0] != "ml" {
+		t.Errorf("Search = %q, want ml first", got)
+	}
+	for _, id := range got {
+		if id == "cooking" {
+			t.Errorf("Search = %q, cooking should not match", got)
+		}
+	}
+	// 「機械」だけが一致する文書より、「機械学習」全体が一致する文書を上位にします。
+	if got := resultIDs(idx.Search("機械学習", 10)); !reflect.DeepEqual(got, []string{"ml", "machine"}) {
+		t.Errorf("Search(機械学習) = %q, want [ml machine]", got)
+	}
+}
+
+func TestIndexSearchEmpty(t *testing.T) {
+	idx := NewIndex([]Document{{ID: "a", Text: "goroutine"}})
+	tests := []struct {
+		name  string
+		idx   *Index
+		query string
+		limit int
+	}{
+		{name: "empty corpus", idx: NewIndex(nil), query: "goroutine", limit: 5},
+		{name: "empty documents", idx: NewIndex([]Document{{ID: "a"}, {ID: "b"}}), query: "goroutine", limit: 5},
+		{name: "empty query", idx: idx, query: "", limit: 5},
+		{name: "punctuation only", idx: idx, query: "?!。", limit: 5},
+		{name: "zero limit", idx: idx, query: "goroutine", limit: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.idx.Search(tt.query, tt.limit); len(got) != 0 {
+				t.Errorf("Search(%q) = %+v, want no results", tt.query, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// EmbedFunc はテキストを同じ順序の埋め込みベクトルに変換する関数です。
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// EmbeddingDocument はインデックスに登録するノード1件分のテキストです。
+type EmbeddingDocument struct {
+	NodeID string
+	Title  string
+	Text   string
+}
+
+// EmbeddingEntry はインデックスに登録されたノード1件分の埋め込みです。
+type EmbeddingEntry struct {
+	ProjectID   string    `json:"project_id"`
+	ProjectName string    `json:"project_name"`
+	NodeID      string    `json:"node_id"`
+	Title       string    `json:"title"`
+	Hash        string    `json:"hash"` // 埋め込んだテキストのハッシュ。変わったノードだけを埋め込み直します
+	Vector      []float32 `json:"vector"`
+}
+
+// EmbeddingMatch は類似検索の結果の1件です。Score はコサイン類似度です。
+type EmbeddingMatch struct {
+	EmbeddingEntry
+	Score float64
+}
+
+// EmbeddingIndex は複数のプロジェクトにまたがるノードの埋め込みインデックスです。
+// 異なるモデルのベクトルは比較できないため、Model が変わった場合はすべて作り直します。
+type EmbeddingIndex struct {
+	Model   string           `json:"model"`
+	Entries []EmbeddingEntry `json:"entries"`
+}
+
+// LoadEmbeddingIndex はインデックスファイルを読み込みます。ファイルがない場合は空のインデックスを返します。
+func LoadEmbeddingIndex(path string) (*EmbeddingIndex, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &EmbeddingIndex{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding index: %w", err)
+	}
+	var ix EmbeddingIndex
+	if err := json.Unmarshal(data, &ix); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding index: %w", err)
+	}
+	return &ix, nil
+}
+
+// Save はインデックスをファイルに書き込みます。書き込み途中で中断しても壊れないよう、一時ファイルから置き換えます。
+func (ix *EmbeddingIndex) Save(path string) error {
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return fmt.Errorf("failed to encode embedding index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// UpdateProject はプロジェクトのノードをインデックスに反映します。
+// テキストが変わったノードと新しいノードだけを埋め込み、docs に含まれないノードは削除します。
+// 埋め込みに失敗した場合はインデックスを変更しません。埋め込んだノード数と、インデックスが変わったかどうかを返します。
+func (ix *EmbeddingIndex) UpdateProject(ctx context.Context, model string, projectID string, projectName string, docs []EmbeddingDocument, embed EmbedFunc) (embedded int, changed bool, err error) {
+	current := ix.Entries
+	if ix.Model != model {
+		current = nil
+	}
+	existing := make(map[string]EmbeddingEntry)
+	others := make([]EmbeddingEntry, 0, len(current))
+	for _, e := range current {
+		if e.ProjectID == projectID {
+			existing[e.NodeID] = e
+		} else {
+			others = append(others, e)
+		}
+	}
+
+	changed = len(existing) != len(docs)
+	entries := make([]EmbeddingEntry, len(docs))
+	var texts []string
+	var pending []int
+	for i, doc := range docs {
+		hash := textHash(doc.Text)
+		entries[i] = EmbeddingEntry{ProjectID: projectID, ProjectName: projectName, NodeID: doc.NodeID, Title: doc.Title, Hash: hash}
+		if e, ok := existing[doc.NodeID]; ok && e.Hash == hash {
+			entries[i].Vector = e.Vector
+			if e.Title != doc.Title || e.ProjectName != projectName {
+				changed = true
+			}
+			continue
+		}
+		texts = append(texts, doc.Text)
+		pending = append(pending, i)
+	}
+	if len(texts) > 0 {
+		vectors, err := embed(ctx, texts)
+		if err != nil {
+			return 0, false, err
+		}
+		if len(vectors) != len(texts) {
+			return 0, false, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+		}
+		for j, i := range pending {
+			entries[i].Vector = vectors[j]
+		}
+	}
+	if ix.Model != model || len(texts) > 0 {
+		changed = true
+	}
+	ix.Model = model
+	ix.Entries = append(others, entries...)
+	return len(texts), changed, nil
+}
+
+// RemoveProject はプロジェクトのノードをインデックスから削除します。
+func (ix *EmbeddingIndex) RemoveProject(projectID string) {
+	kept := ix.Entries[:0]
+	for _, e := range ix.Entries {
+		if e.ProjectID != projectID {
+			kept = append(kept, e)
+		}
+	}
+	ix.Entries = kept
+}
+
+// Search は vector とのコサイン類似度が高い順に最大 limit 件のノードを返します。
+func (ix *EmbeddingIndex) Search(vector []float32, limit int) []EmbeddingMatch {
+	if limit <= 0 {
+		return nil
+	}
+	var matches []EmbeddingMatch
+	for _, e := range ix.Entries {
+		if score, ok := cosineSimilarity(vector, e.Vector); ok {
+			matches = append(matches, EmbeddingMatch{EmbeddingEntry: e, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// cosineSimilarity は2つのベクトルのコサイン類似度を返します。次元が異なるかゼロベクトルの場合は ok=false です。
+func cosineSimilarity(a, b []float32) (float64, bool) {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0, false
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0, false
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB)), true
+}
+
+// textHash は埋め込むテキストの変更を検出するためのハッシュを返します。
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,277 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeEmbedder は埋め込みを依頼されたテキストを記録し、テキストの長さから決まるベクトルを返します。
+type fakeEmbedder struct {
+	texts [][]string
+	err   error
+	drop  bool // true の場合は1件少ないベクトルを返します
+}
+
+func (f *fakeEmbedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	f.texts = append(f.texts, texts)
+	if f.err != nil {
+		return nil, f.err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text)), 1}
+	}
+	if f.drop {
+		vectors = vectors[1:]
+	}
+	return vectors, nil
+}
+
+func baseDocs() []EmbeddingDocument {
+	return []EmbeddingDocument{
+		{NodeID: "a", Title: "A", Text: "alpha"},
+		{NodeID: "b", Title: "B", Text: "beta"},
+	}
+}
+
+// entryKeys はインデックスの各エントリを "プロジェクト/ノード" で返します。
+func entryKeys(ix *EmbeddingIndex) []string {
+	keys := make([]string, len(ix.Entries))
+	for i, e := range ix.Entries {
+		keys[i] = e.ProjectID + "/" + e.NodeID
+	}
+	return keys
+}
+
+func TestEmbeddingIndexUpdateProject(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        string
+		projectName  string
+		docs         []EmbeddingDocument
+		embedder     *fakeEmbedder
+		wantEmbedded int
+		wantChanged  bool
+		wantTexts    [][]string
+		wantKeys     []string
+		wantErr      bool
+	}{
+		{
+			name:         "unchanged",
+			model:        "m1",
+			projectName:  "Project 1",
+			docs:         baseDocs(),
+			wantEmbedded: 0,
+			wantChanged:  false,
+			wantKeys:     []string{"p2/x", "p1/a", "p1/b"},
+		},
+		{
+			name:         "only the changed node is embedded",
+			model:        "m1",
+			projectName:  "Project 1",
+			docs:         []EmbeddingDocument{{NodeID: "a", Title: "A", Text: "alpha"}, {NodeID: "b", Title: "B", Text: "beta v2"}},
+			wantEmbedded: 1,
+			wantChanged:  true,
+			wantTexts:    [][]string{{"beta v2"}},
+			wantKeys:     []string{"p2/x", "p1/a", "p1/b"},
+		},
+		{
+			name:         "new node",
+			model:        "m1",
+			projectName:  "Project 1",
+			docs:         append(baseDocs(), EmbeddingDocument{NodeID: "c", Title: "C", Text: "gamma"}),
+			wantEmbedded: 1,
+			wantChanged:  true,
+			wantTexts:    [][]string{{"gamma"}},
+			wantKeys:     []string{"p2/x", "p1/a", "p1/b", "p1/c"},
+		},
+		{
+			name:         "deleted node",
+			model:        "m1",
+			projectName:  "Project 1",
+			docs:         baseDocs()[:1],
+			wantEmbedded: 0,
+			wantChanged:  true,
+			wantKeys:     []string{"p2/x", "p1/a"},
+		},
+		{
+			name:         "renamed title is updated without embedding",
+			model:        "m1",
+			projectName:  "Project 1",
+			docs:         []EmbeddingDocument{{NodeID: "a", Title: "A2", Text: "alpha"}, {NodeID: "b", Title: "B", Text: "beta"}},
+			wantEmbedded: 0,
+			wantChanged:  true,
+			wantKeys:     []string{"p2/x", "p1/a", "p1/b"},
+		},
+		{
+			name:         "renamed project is updated without embedding",
+			model:        "m1",
+			projectName:  "Renamed",
+			docs:         baseDocs(),
+			wantEmbedded: 0,
+			wantChanged:  true,
+			wantKeys:     []string{"p2/x", "p1/a", "p1/b"},
+		},
+		{
+			// 別のモデルのベクトルは比較できないため、他のプロジェクトも含めて作り直します。
+			name:         "model change resets the index",
+			model:        "m2",
+			projectName:  "Project 1",
+			docs:         baseDocs(),
+			wantEmbedded: 2,
+			wantChanged:  true,
+			wantTexts:    [][]string{{"alpha", "beta"}},
+			wantKeys:     []string{"p1/a", "p1/b"},
+		},
+		{
+			name:        "embedding error keeps the index",
+			model:       "m1",
+			projectName: "Project 1",
+			docs:        append(baseDocs(), EmbeddingDocument{NodeID: "c", Text: "gamma"}),
+			embedder:    &fakeEmbedder{err: errors.New("quota exceeded")},
+			wantTexts:   [][]string{{"gamma"}},
+			wantKeys:    []string{"p2/x", "p1/a", "p1/b"},
+			wantErr:     true,
+		},
+		{
+			name:        "mismatched number of vectors",
+			model:       "m2",
+			projectName: "Project 1",
+			docs:        baseDocs(),
+			embedder:    &fakeEmbedder{drop: true},
+			wantTexts:   [][]string{{"alpha", "beta"}},
+			wantKeys:    []string{"p2/x", "p1/a", "p1/b"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			ix := &EmbeddingIndex{}
+			setup := &fakeEmbedder{}
+			if _, _, err := ix.UpdateProject(ctx, "m1", "p2", "Project 2", []EmbeddingDocument{{NodeID: "x", Text: "other"}}, setup.embed); err != nil {
+				t.Fatalf("setup: %v", err)
+			}
+			if _, _, err := ix.UpdateProject(ctx, "m1", "p1", "Project 1", baseDocs(), setup.embed); err != nil {
+				t.Fatalf("setup: %v", err)
+			}
+
+			embedder := tt.embedder
+			if embedder == nil {
+				embedder = &fakeEmbedder{}
+			}
+			embedded, changed, err := ix.UpdateProject(ctx, tt.model, "p1", tt.projectName, tt.docs, embedder.embed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if embedded != tt.wantEmbedded || changed != tt.wantChanged {
+				t.Errorf("UpdateProject = (%d, %v), want (%d, %v)", embedded, changed, tt.wantEmbedded, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(embedder.texts, tt.wantTexts) {
+				t.Errorf("embedded texts = %q, want %q", embedder.texts, tt.wantTexts)
+			}
+			if got := entryKeys(ix); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("entries = %q, want %q", got, tt.wantKeys)
+			}
+			wantModel := tt.model
+			if tt.wantErr {
+				wantModel = "m1"
+			}
+			if ix.Model != wantModel {
+				t.Errorf("Model = %q, want %q", ix.Model, wantModel)
+			}
+			for _, e := range ix.Entries {
+				if len(e.Vector) == 0 {
+					t.Errorf("entry %s/%s has no vector", e.ProjectID, e.NodeID)
+				}
+				if e.ProjectID == "p1" && !tt.wantErr && e.ProjectName != tt.projectName {
+					t.Errorf("ProjectName = %q, want %q", e.ProjectName, tt.projectName)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddingIndexSearch(t *testing.T) {
+	ix := &EmbeddingIndex{Model: "m1", Entries: []EmbeddingEntry{
+		{NodeID: "x", Vector: []float32{1, 0}},
+		{NodeID: "y", Vector: []float32{0, 1}},
+		{NodeID: "xy", Vector: []float32{1, 1}},
+		{NodeID: "opposite", Vector: []float32{-1, 0}},
+		{NodeID: "zero", Vector: []float32{0, 0}},
+		{NodeID: "3d", Vector: []float32{1, 0, 0}},
+		{NodeID: "empty"},
+	}}
+	tests := []struct {
+		name   string
+		vector []float32
+		limit  int
+		want   []string
+	}{
+		{name: "sorted by cosine similarity", vector: []float32{2, 0}, limit: 10, want: []string{"x", "xy", "y", "opposite"}},
+		{name: "limit", vector: []float32{2, 0}, limit: 2, want: []string{"x", "xy"}},
+		{name: "zero limit", vector: []float32{2, 0}, limit: 0, want: nil},
+		{name: "zero query vector", vector: []float32{0, 0}, limit: 10, want: nil},
+		{name: "empty query vector", vector: nil, limit: 10, want: nil},
+		{name: "only matching dimensions", vector: []float32{0, 0, 3}, limit: 10, want: []string{"3d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := ix.Search(tt.vector, tt.limit)
+			got := make([]string, len(matches))
+			for i, m := range matches {
+				got[i] = m.NodeID
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("Search(%v) = %q, want %q", tt.vector, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   []float32
+		want   float64
+		wantOK bool
+	}{
+		{name: "same direction", a: []float32{1, 2}, b: []float32{2, 4}, want: 1, wantOK: true},
+		{name: "orthogonal", a: []float32{1, 0}, b: []float32{0, 3}, want: 0, wantOK: true},
+		{name: "opposite", a: []float32{1, 1}, b: []float32{-1, -1}, want: -1, wantOK: true},
+		{name: "zero vector", a: []float32{0, 0}, b: []float32{1, 1}},
+		{name: "mismatched dimensions", a: []float32{1, 0}, b: []float32{1, 0, 0}},
+		{name: "empty", a: nil, b: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cosineSimilarity(tt.a, tt.b)
+			if ok != tt.wantOK || (ok && (got < tt.want-1e-9 || got > tt.want+1e-9)) {
+				t.Errorf("cosineSimilarity = (%v, %v), want (%v, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEmbeddingIndexSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index", "embeddings.json")
+	ix, err := LoadEmbeddingIndex(path)
+	if err != nil || ix.Model != "" || len(ix.Entries) != 0 {
+		t.Fatalf("LoadEmbeddingIndex(missing) = %+v, %v, want an empty index", ix, err)
+	}
+	ix.Model = "m1"
+	ix.Entries = []EmbeddingEntry{{ProjectID: "p1", ProjectName: "Project 1", NodeID: "a", Title: "A", Hash: textHash("alpha"), Vector: []float32{0.5, -1}}}
+	if err := ix.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := LoadEmbeddingIndex(path)
+	if err != nil {
+		t.Fatalf("LoadEmbeddingIndex: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, ix) {
+		t.Errorf("loaded %+v, want %+v", loaded, ix)
+	}
+}
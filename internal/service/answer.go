@@ -0,0 +1,93 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"strings"
+
+	"fyne.io/fyne/v2"
+)
+
+// streamAnswer はリクエストの応答をストリーミングで生成し、受信中の回答を nodeData の表示に逐次反映します。
+// 組み込みのツールが有効な場合はモデルからのツール呼び出しを実行し、その記録を nodeData に残します。
+// projectID はリクエストの開始時にUIスレッドで取得した、ツールが参照するプロジェクトのIDです。
+// 送信前に、リクエストを provider で数えたトークン数が予算に収まるよう古い祖先の会話を取り除きます。
+func (a *App) streamAnswer(ctx context.Context, provider ai_client.Provider, nodeData *ui.NodeData, request *ai_client.Request, projectID string) (*ai_client.Response, error) {
+	trimToBudget(ctx, provider, request)
+	if request.Tools == nil {
+		request.Tools = a.builtinTools(projectID)
+	}
+	if len(request.Tools) > 0 {
+		a.updateNodeAndWait(nodeData, func() {
+			nodeData.ToolCalls = nil
+		})
+	}
+	var streamed strings.Builder
+	resp, err := ai_client.GenerateStreamWithTools(ctx, provider, request, func(chunk string) {
+		streamed.WriteString(chunk)
+		partialAnswer := streamed.String()
+		a.updateNode(nodeData, func() {
+			nodeData.Answer = partialAnswer
+		})
+	}, a.toolCallRecorder(nodeData))
+	return resp, generationError(err)
+}
+
+// updateNode は UIスレッドで fn によりノードのデータを変更し、ノードを再描画します。UIスレッド以外から呼び出します。
+// fn は nodesMutex を取得した状態で実行します。画面を持たない App (テストなど) では、その場で fn を実行します。
+func (a *App) updateNode(nodeData *ui.NodeData, fn func()) {
+	if a.dialogCanvas == nil {
+		a.withNodesLocked(fn)
+		return
+	}
+	fyne.Do(func() {
+		a.withNodesLocked(fn)
+		a.dialogCanvas.RefreshNode(nodeData.ID)
+	})
+}
+
+// updateNodeAndWait は updateNode と同じですが、変更が反映されるまで待ちます。
+func (a *App) updateNodeAndWait(nodeData *ui.NodeData, fn func()) {
+	if a.dialogCanvas == nil {
+		a.withNodesLocked(fn)
+		return
+	}
+	fyne.DoAndWait(func() {
+		a.withNodesLocked(fn)
+		a.dialogCanvas.RefreshNode(nodeData.ID)
+	})
+}
+
+// withNodesLocked は nodesMutex を取得した状態で fn を実行します。
+// ツールや会話履歴の組み立てなど、他のゴルーチンがロックを取って読むノードのデータを変更するときに使います。
+func (a *App) withNodesLocked(fn func()) {
+	a.nodesMutex.Lock()
+	defer a.nodesMutex.Unlock()
+	fn()
+}
+
+// recordedGeneration はノードに記録する生成設定を返します。モデル名は実際に応答したモデルで上書きし、応答キャッシュから返したかどうかを記録します。
+// 回答が途中で打ち切られた場合はその理由と、有害性が medium 以上と評価されたカテゴリも記録します。
+func recordedGeneration(requested *ui.GenerationSettings, provider ai_client.Provider, resp *ai_client.Response) *ui.GenerationSettings {
+	recorded := ui.GenerationSettings{}
+	if requested != nil {
+		recorded = *requested
+	}
+	if provider != nil {
+		recorded.Provider = provider.ModelInfo().Provider
+	}
+	if resp != nil && resp.Model != "" {
+		recorded.Model = resp.Model
+	}
+	recorded.CacheHit = resp != nil && resp.CacheHit
+	recorded.FinishReason = ""
+	recorded.SafetyRatings = nil
+	if resp != nil {
+		if resp.FinishReason != ai_client.FinishReasonStop {
+			recorded.FinishReason = string(resp.FinishReason)
+		}
+		recorded.SafetyRatings = notableSafetyRatings(resp.SafetyRatings)
+	}
+	return &recorded
+}
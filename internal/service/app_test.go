@@ -0,0 +1,221 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/widget"
+)
+
+// uiThreadApp は fyne のテスト用アプリで、fyne.Do で渡された関数を1つずつ実行します。
+// テスト用ドライバは関数を呼び出し元のゴルーチンでそのまま実行するため、
+// バックグラウンドの処理とテストが同時にウィジェットやノードに触れないように直列化します。
+type uiThreadApp struct {
+	fyne.App
+	driver *uiThreadDriver
+}
+
+func (a *uiThreadApp) Driver() fyne.Driver {
+	return a.driver
+}
+
+type uiThreadDriver struct {
+	fyne.Driver
+	mu sync.Mutex
+}
+
+func (d *uiThreadDriver) DoFromGoroutine(fn func(), _ bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn()
+}
+
+// newTestApp は fyne のテスト用ドライバ上に、キャンバスと入力バーを持つ App を作成します。
+// UIスレッドから呼び出す処理は fyne.DoAndWait の中で呼び出し、終了を waitForRequest で待ちます。
+// プロジェクトはテストごとの一時ディレクトリに保存されます。
+func newTestApp(t *testing.T, provider ai_client.Provider, nodes ...*ui.NodeData) *App {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	testApp := test.NewTempApp(t)
+	fyneApp := &uiThreadApp{App: testApp, driver: &uiThreadDriver{Driver: testApp.Driver()}}
+	fyne.SetCurrentApp(fyneApp)
+
+	a := newHeadlessApp(nodes...)
+	a.fyneApp = fyneApp
+	a.window = fyneApp.NewWindow("test")
+	a.aiProvider = provider
+	a.uiUpdateChan = make(chan *ui.NodeData, 100)
+	a.dialogCanvas = ui.NewDialogCanvas(fyneApp, a.requestNodeDeletion)
+	a.dialogCanvas.SetOnSuggestionSelected(a.handleSuggestionSelected)
+	for _, n := range nodes {
+		a.dialogCanvas.AddNode(n)
+	}
+	a.chatInput = ui.NewChatEntry()
+	a.sendButton = widget.NewButton("送信", a.handleSend)
+	a.cancelButton = widget.NewButton("キャンセル", a.handleCancel)
+	a.cancelButton.Disable()
+	a.fanOutCheck = widget.NewCheck("比較送信", nil)
+	a.noCacheCheck = widget.NewCheck("キャッシュを使わない", nil)
+	a.statusLabel = widget.NewLabel("準備完了")
+	a.attachmentBar = container.NewVBox()
+	a.newGenerationSettingsBar()
+	return a
+}
+
+// waitForRequest は実行中のAIリクエストが終了し、終了時の画面の更新まで済むのを待ちます。
+func waitForRequest(t *testing.T, a *App) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		done := false
+		fyne.DoAndWait(func() {
+			done = !a.isRequestRunning() && a.cancelButton.Disabled()
+		})
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("AI request did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// childrenOf は parentID を親に持つノードを追加された順に返します。
+func childrenOf(a *App, parentID string) []*ui.NodeData {
+	a.nodesMutex.RLock()
+	defer a.nodesMutex.RUnlock()
+	var children []*ui.NodeData
+	for _, n := range a.nodes {
+		if n.ParentID == parentID {
+			children = append(children, n)
+		}
+	}
+	return children
+}
+
+// 依頼の種類です。fakeProvider はタイトル・フォローアップ質問・要約の依頼を回答の依頼と区別して応答します。
+const (
+	requestAnswer    = "answer"
+	requestTitle     = "title"
+	requestFollowUps = "follow-ups"
+	requestSummary   = "summary"
+)
+
+// requestKind はリクエストがどの依頼かを返します。
+func requestKind(req *ai_client.Request) string {
+	if req.ResponseSchema != nil {
+		if _, ok := req.ResponseSchema.Properties["title"]; ok {
+			return requestTitle
+		}
+		if _, ok := req.ResponseSchema.Properties["questions"]; ok {
+			return requestFollowUps
+		}
+	}
+	if len(req.Messages) == 1 && strings.HasPrefix(req.Messages[0].Text, "次の会話を") {
+		return requestSummary
+	}
+	return requestAnswer
+}
+
+// fakeProvider は質問 q に "A:q" と回答するテスト用のプロバイダで、受け取ったリクエストを記録します。
+// タイトルは "T:q"、フォローアップ質問は直前の質問に "-1", "-2", ... を付けたもの、要約は "要約n" (n は要約の依頼の通し番号) を返します。
+type fakeProvider struct {
+	info  ai_client.ModelInfo
+	usage ai_client.Usage // 各応答で報告するトークン使用量
+
+	mu       sync.Mutex
+	errs     map[string]error // 質問または依頼の種類ごとに返すエラー
+	requests []*ai_client.Request
+}
+
+func newFakeProvider(provider string, model string) *fakeProvider {
+	return &fakeProvider{info: ai_client.ModelInfo{Provider: provider, Model: model}}
+}
+
+// failWith は以降の question への回答を err で失敗させます。question に依頼の種類を指定すると、その種類の依頼をすべて失敗させます。
+// err がnilの場合は応答するように戻します。
+func (p *fakeProvider) failWith(question string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.errs == nil {
+		p.errs = make(map[string]error)
+	}
+	if err == nil {
+		delete(p.errs, question)
+		return
+	}
+	p.errs[question] = err
+}
+
+// requestsOf は受け取った kind の依頼を順に返します。
+func (p *fakeProvider) requestsOf(kind string) []*ai_client.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var requests []*ai_client.Request
+	for _, req := range p.requests {
+		if requestKind(req) == kind {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+func (p *fakeProvider) Generate(ctx context.Context, req *ai_client.Request) (*ai_client.Response, error) {
+	p.mu.Lock()
+	recorded := *req
+	recorded.Messages = append([]ai_client.Message{}, req.Messages...)
+	p.requests = append(p.requests, &recorded)
+	summaries := 0
+	for _, r := range p.requests {
+		if requestKind(r) == requestSummary {
+			summaries++
+		}
+	}
+	kind := requestKind(req)
+	last := req.Messages[len(req.Messages)-1].Text
+	err := p.errs[last]
+	if err == nil {
+		err = p.errs[kind]
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var text string
+	switch kind {
+	case requestTitle:
+		question := strings.SplitN(strings.SplitN(last, "# 質問\n", 2)[1], "\n\n# 回答", 2)[0]
+		text = fmt.Sprintf(`{"title": %q}`, "T:"+question)
+	case requestFollowUps:
+		question := req.Messages[len(req.Messages)-3].Text
+		text = fmt.Sprintf(`{"questions": [%q, %q, %q]}`, question+"-1", question+"-2", question+"-3")
+	case requestSummary:
+		text = fmt.Sprintf("要約%d", summaries)
+	default:
+		text = "A:" + last
+	}
+	return &ai_client.Response{Text: text, Model: p.info.Model, Usage: p.usage, FinishReason: ai_client.FinishReasonStop}, nil
+}
+
+func (p *fakeProvider) GenerateStream(ctx context.Context, req *ai_client.Request, onChunk func(chunk string)) (*ai_client.Response, error) {
+	resp, err := p.Generate(ctx, req)
+	if err == nil && onChunk != nil {
+		onChunk(resp.Text)
+	}
+	return resp, err
+}
+
+func (p *fakeProvider) ModelInfo() ai_client.ModelInfo {
+	return p.info
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/ui"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	attachmentsDirName = "attachments"
+	// maxAttachmentSize は1ファイルあたりの添付サイズの上限です。
+	maxAttachmentSize = 20 * 1024 * 1024
+)
+
+// newAttachButton は入力バーの「添付」ボタンを作成します。
+func (a *App) newAttachButton() *widget.Button {
+	return widget.NewButtonWithIcon("添付", theme.FileIcon(), a.chooseAttachment)
+}
+
+// chooseAttachment はファイル選択ダイアログを表示し、選ばれたファイルを次の質問に添付します。
+func (a *App) chooseAttachment() {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		defer reader.Close()
+		attachment, err := readAttachment(reader.URI().Name(), reader)
+		if err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		log.Printf("Attached %s (%s, %d bytes)", attachment.Name, attachment.MIMEType, len(attachment.Data))
+		a.setPendingAttachments(append(a.pendingAttachments, attachment))
+	}, a.window)
+}
+
+// readAttachment はファイルを読み込み、画像またはテキストの添付ファイルとして返します。
+// 画像でもUTF-8のテキストでもないファイルはエラーになります。
+func readAttachment(name string, r io.Reader) (ui.Attachment, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxAttachmentSize+1))
+	if err != nil {
+		return ui.Attachment{}, fmt.Errorf("ファイル「%s」を読み込めませんでした: %w", name, err)
+	}
+	if len(data) > maxAttachmentSize {
+		return ui.Attachment{}, fmt.Errorf("ファイル「%s」が大きすぎます (上限 %dMB)", name, maxAttachmentSize/1024/1024)
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+	case utf8.Valid(data):
+		mimeType = "text/plain"
+	default:
+		return ui.Attachment{}, fmt.Errorf("ファイル「%s」は未対応の形式です (%s)。テキストファイルか画像を添付してください", name, mimeType)
+	}
+	return ui.Attachment{Name: name, MIMEType: mimeType, Data: data}, nil
+}
+
+// setPendingAttachments は次の質問に添付するファイルを設定し、入力バーのチップを更新します。UIスレッドから呼び出します。
+func (a *App) setPendingAttachments(attachments []ui.Attachment) {
+	a.pendingAttachments = attachments
+	a.attachmentBar.RemoveAll()
+	if len(attachments) > 0 {
+		a.attachmentBar.Add(container.NewHScroll(ui.NewAttachmentChips(attachments, func(index int) {
+			remaining := append([]ui.Attachment{}, a.pendingAttachments[:index]...)
+			a.setPendingAttachments(append(remaining, a.pendingAttachments[index+1:]...))
+		})))
+	}
+	a.attachmentBar.Refresh()
+	a.scheduleTokenCount()
+}
+
+// takePendingAttachments は次の質問に添付するファイルを取り出し、入力バーから消去します。
+func (a *App) takePendingAttachments() []ui.Attachment {
+	attachments := a.pendingAttachments
+	a.setPendingAttachments(nil)
+	return attachments
+}
+
+// questionMessage は質問と添付ファイルからユーザーのメッセージを作成します。
+// テキストファイルは質問の後ろに本文を埋め込み、画像はメッセージの画像として送信します。
+func questionMessage(question string, attachments []ui.Attachment) ai_client.Message {
+	msg := ai_client.Message{Role: ai_client.RoleUser, Text: question}
+	var b strings.Builder
+	b.WriteString(question)
+	for _, att := range attachments {
+		if att.Data == nil {
+			log.Printf("Attachment %s has no data, skipping", att.Name)
+			continue
+		}
+		if att.IsImage() {
+			msg.Images = append(msg.Images, ai_client.Image{MIMEType: att.MIMEType, Data: att.Data})
+			continue
+		}
+		fmt.Fprintf(&b, "\n\n---\n添付ファイル: %s\n```\n%s\n```", att.Name, strings.TrimRight(string(att.Data), "\n"))
+	}
+	msg.Text = b.String()
+	return msg
+}
+
+// attachmentPath は添付ファイルの保存先 (projects/<id>/attachments/<nodeID>/<番号>-<名前>) を返します。
+func attachmentPath(projectDataPath string, nodeID string, index int, att ui.Attachment) string {
+	return filepath.Join(projectDataPath, attachmentsDirName, nodeID, fmt.Sprintf("%d-%s", index+1, filepath.Base(att.Name)))
+}
+
+// saveAttachments はノードの添付ファイルを保存します。添付ファイルは変更されないため、保存済みのファイルは書き直しません。
+func saveAttachments(projectDataPath string, node *ui.NodeData) {
+	for i, att := range node.Attachments {
+		path := attachmentPath(projectDataPath, node.ID, i, att)
+		if _, err := os.Stat(path); err == nil || att.Data == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Printf("添付ファイルのディレクトリ作成エラー (%s): %v", filepath.Dir(path), err)
+			continue
+		}
+		if err := os.WriteFile(path, att.Data, 0644); err != nil {
+			log.Printf("添付ファイル書き込みエラー (%s): %v", path, err)
+		}
+	}
+}
+
+// loadAttachments はノードの添付ファイルの内容を読み込みます。
+func loadAttachments(projectDataPath string, node *ui.NodeData) {
+	for i := range node.Attachments {
+		path := attachmentPath(projectDataPath, node.ID, i, node.Attachments[i])
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("添付ファイル読み込みエラー (%s): %v", path, err)
+			continue
+		}
+		node.Attachments[i].Data = data
+	}
+}
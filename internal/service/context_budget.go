@@ -0,0 +1,85 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// tokenCountDelay は入力が止まってからトークン数を数え直すまでの待ち時間です。
+const tokenCountDelay = 600 * time.Millisecond
+
+// newTokenLabel は次のリクエストのトークン数を表示するラベルを作成します。
+func (a *App) newTokenLabel() *widget.Label {
+	label := widget.NewLabel("")
+	label.SizeName = theme.SizeNameCaptionText
+	label.Importance = widget.LowImportance
+	return label
+}
+
+// scheduleTokenCount は入力や分岐元の変更後、少し待ってから次のリクエストのトークン数を数え直します。
+// UIスレッドから呼び出します。
+func (a *App) scheduleTokenCount() {
+	if a.tokenCountTimer != nil {
+		a.tokenCountTimer.Stop()
+	}
+	a.tokenCountTimer = time.AfterFunc(tokenCountDelay, func() {
+		fyne.Do(a.updateTokenCount)
+	})
+}
+
+// updateTokenCount は入力中の質問と分岐元から次のリクエストを組み立て、予算に合わせて削減した後のトークン数をラベルに表示します。
+// プロバイダのAPIで数えられない場合や、履歴を省略した場合は推定値を表示します。UIスレッドから呼び出します。
+func (a *App) updateTokenCount() {
+	if a.tokenLabel == nil || a.aiProvider == nil {
+		return
+	}
+	a.tokenCountSeq++
+	seq := a.tokenCountSeq
+	generation, err := a.currentGenerationSettings()
+	if err != nil {
+		generation = &ui.GenerationSettings{Model: a.modelSelect.Selected}
+	}
+	parentID := a.dialogCanvas.GetBranchSource()
+	references := a.retrieveReferences(parentID, a.chatInput.Text)
+	request := a.newChatRequest(parentID, parentID, a.chatInput.Text, a.pendingAttachments, references, generation)
+	provider := a.aiProvider
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// APIで数えるのは削減前の1回だけで、省略した後のトークン数は推定します。
+		count, exact, err := ai_client.CountRequestTokens(ctx, provider, request)
+		if err != nil {
+			log.Printf("トークン数の取得に失敗したため推定値を使用します: %v", err)
+		}
+		dropped, count := ai_client.TrimCountedToBudget(request, count, config.Cfg.ContextTokenBudget)
+		if dropped > 0 {
+			exact = false
+		}
+		text := fmt.Sprintf("次のリクエスト: %dトークン", count)
+		if !exact {
+			text = fmt.Sprintf("次のリクエスト: 約%dトークン", count)
+		}
+		if budget := config.Cfg.ContextTokenBudget; budget > 0 {
+			text += fmt.Sprintf(" / 予算 %d", budget)
+		}
+		if dropped > 0 {
+			text += fmt.Sprintf(" (古い履歴%d件を省略)", dropped)
+		}
+		fyne.Do(func() {
+			if seq != a.tokenCountSeq {
+				return
+			}
+			a.tokenLabel.SetText(text)
+		})
+	}()
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// exploreOptions は探索の深さと幅です。
+type exploreOptions struct {
+	Depth    int // 開始ノードから何階層下まで質問を掘り下げるか
+	Breadth  int // 1ノードあたりのフォローアップ質問の数
+	MaxNodes int // 追加するノード数の上限
+}
+
+// exploreItem は探索キューの1項目で、フォローアップ質問を生成する対象のノードとその会話履歴です。
+type exploreItem struct {
+	node    *ui.NodeData
+	depth   int
+	history []ai_client.Message
+}
+
+// showExploreDialog は探索の深さと幅を入力するダイアログを表示し、指定ノードからの探索を開始します。
+func (a *App) showExploreDialog(nodeID string) {
+	depthEntry := widget.NewEntry()
+	depthEntry.SetText(strconv.Itoa(config.Cfg.ExploreDepth))
+	breadthEntry := widget.NewEntry()
+	breadthEntry.SetText(strconv.Itoa(config.Cfg.ExploreBreadth))
+	maxNodesEntry := widget.NewEntry()
+	maxNodesEntry.SetText(strconv.Itoa(config.Cfg.ExploreMaxNodes))
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("深さ", depthEntry),
+		widget.NewFormItem("幅 (質問数/ノード)", breadthEntry),
+		widget.NewFormItem("最大ノード数", maxNodesEntry),
+	}
+	dialog.ShowForm("このノードから探索", "開始", "キャンセル", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		var opts exploreOptions
+		var err error
+		if opts.Depth, err = parsePositiveInt("深さ", depthEntry.Text); err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		if opts.Breadth, err = parsePositiveInt("幅", breadthEntry.Text); err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		if opts.MaxNodes, err = parsePositiveInt("最大ノード数", maxNodesEntry.Text); err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		a.explore(nodeID, opts)
+	}, a.window)
+}
+
+func parsePositiveInt(name string, text string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("%sには1以上の整数を入力してください: %q", name, text)
+	}
+	return v, nil
+}
+
+// newExploreLimiter は探索中のリクエスト間隔を制限するリミッタを作成します。
+// explore_requests_per_minute が0以下の場合はプロバイダごとのレート制限のみを適用します。
+func newExploreLimiter() *rate.Limiter {
+	rpm := config.Cfg.ExploreRequestsPerMinute
+	if rpm <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(rpm/60), 1)
+}
+
+// explore は指定ノードから幅優先で、フォローアップ質問の生成と回答を指定の深さまで繰り返し、サブツリーを自動で作成します。
+// ノードは uiUpdateChan を通して追加・保存され、キャンセルボタンで探索全体を中止できます。UIスレッドから呼び出します。
+func (a *App) explore(startID string, opts exploreOptions) {
+	startNode := a.findNodeData(startID)
+	if startNode == nil || !startNode.IsComplete() {
+		return
+	}
+	if a.aiProvider == nil {
+		dialog.ShowInformation("情報", "AIプロバイダが初期化されていません。", a.window)
+		return
+	}
+	generation, err := a.currentGenerationSettings()
+	if err != nil {
+		dialog.ShowError(err, a.window)
+		return
+	}
+	ctx, cancel, ok := a.beginRequest("探索中...")
+	if !ok {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return
+	}
+	log.Printf("Exploring from node %s (depth=%d, breadth=%d, max nodes=%d)", startID, opts.Depth, opts.Breadth, opts.MaxNodes)
+	provider := a.aiProvider
+	system := a.resolveSystemPrompt(startID)
+	history := a.getConversationHistory(startID)
+	limiter := newExploreLimiter()
+
+	go func() {
+		added := 0
+		status := ""
+		defer func() {
+			a.finishRequest(cancel, status)
+		}()
+
+		queue := []exploreItem{{node: startNode, depth: 0, history: history}}
+		for len(queue) > 0 && added < opts.MaxNodes && ctx.Err() == nil {
+			item := queue[0]
+			queue = queue[1:]
+			if item.depth >= opts.Depth {
+				continue
+			}
+			a.showExploreStatus(item.depth+1, opts, added)
+			if err := limiter.Wait(ctx); err != nil {
+				break
+			}
+			questions, err := ai_client.SuggestFollowUps(ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(item.node)), provider, system, item.history, opts.Breadth)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Explore: failed to suggest follow-up questions for node %s: %v", item.node.ID, err)
+				}
+				continue
+			}
+			for _, question := range questions {
+				if added >= opts.MaxNodes {
+					break
+				}
+				child, childHistory := a.exploreAnswer(ctx, provider, limiter, item, question, system, generation)
+				if ctx.Err() != nil {
+					break
+				}
+				a.uiUpdateChan <- child
+				added++
+				a.showExploreStatus(item.depth+1, opts, added)
+				if child.IsComplete() {
+					queue = append(queue, exploreItem{node: child, depth: item.depth + 1, history: childHistory})
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			status = fmt.Sprintf("探索をキャンセルしました (%d件のノードを追加)", added)
+		} else {
+			status = fmt.Sprintf("探索が完了しました (%d件のノードを追加)", added)
+		}
+		log.Println(status)
+	}()
+}
+
+// exploreAnswer は探索で生成した質問に回答し、子ノードとその子ノードまでの会話履歴を返します。
+// 回答の生成に失敗した場合は失敗したノードを返します。
+func (a *App) exploreAnswer(ctx context.Context, provider ai_client.Provider, limiter *rate.Limiter, parent exploreItem, question string, system string, generation *ui.GenerationSettings) (*ui.NodeData, []ai_client.Message) {
+	child := &ui.NodeData{
+		ID:         uuid.NewString(),
+		Title:      fallbackNodeTitle(question),
+		Question:   question,
+		ParentID:   parent.node.ID,
+		Generation: generation,
+	}
+	ctx = ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(child))
+	if err := limiter.Wait(ctx); err != nil {
+		child.MarkFailed(err)
+		return child, nil
+	}
+
+	messages := append(append([]ai_client.Message{}, parent.history...), ai_client.Message{Role: ai_client.RoleUser, Text: question})
+	request := &ai_client.Request{
+		Model:    generation.Model,
+		Params:   toGenerationParams(generation),
+		System:   system,
+		Messages: messages,
+	}
+	trimToBudget(ctx, provider, request)
+	resp, err := provider.Generate(ctx, request)
+	if err = generationError(err); err != nil {
+		log.Printf("Explore: failed to answer %q: %v", question, err)
+		child.MarkFailed(err)
+		return child, nil
+	}
+	child.Answer = resp.Text
+	child.Generation = recordedGeneration(generation, provider, resp)
+	child.Title = a.generateNodeTitle(ctx, provider, question, resp.Text)
+	child.MarkComplete()
+	return child, append(messages, ai_client.Message{Role: ai_client.RoleModel, Text: resp.Text})
+}
+
+// showExploreStatus は探索の進み具合をステータスラベルに表示します。
+func (a *App) showExploreStatus(depth int, opts exploreOptions, added int) {
+	fyne.Do(func() {
+		if a.statusLabel != nil {
+			a.statusLabel.SetText(fmt.Sprintf("探索中... (深さ %d/%d, %d/%dノード)", depth, opts.Depth, added, opts.MaxNodes))
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"errors"
+	"reflect"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+// runExplore は startID からの探索を最後まで実行し、追加されたノードを追加された順に返します。
+func runExplore(t *testing.T, a *App, startID string, opts exploreOptions) []*ui.NodeData {
+	t.Helper()
+	fyne.DoAndWait(func() {
+		a.explore(startID, opts)
+	})
+	waitForRequest(t, a)
+	var added []*ui.NodeData
+	for len(a.uiUpdateChan) > 0 {
+		added = append(added, <-a.uiUpdateChan)
+	}
+	return added
+}
+
+// questionTree はノードを "親の質問 > 質問" の形式で返します。
+func questionTree(start *ui.NodeData, nodes []*ui.NodeData) []string {
+	questions := map[string]string{start.ID: start.Question}
+	tree := make([]string, len(nodes))
+	for i, n := range nodes {
+		questions[n.ID] = n.Question
+		tree[i] = questions[n.ParentID] + " > " + n.Question
+	}
+	return tree
+}
+
+func TestExploreStopsAtMaxDepth(t *testing.T) {
+	useConfig(t, config.Config{})
+	provider := newFakeProvider("openai", "gpt-x")
+	start := newCompleteNode("start", "", "Q", "A:Q")
+	a := newTestApp(t, provider, start)
+
+	added := runExplore(t, a, "start", exploreOptions{Depth: 2, Breadth: 2, MaxNodes: 100})
+
+	// 幅優先で、開始ノードから2階層下までを掘り下げます。
+	want := []string{"Q > Q-1", "Q > Q-2", "Q-1 > Q-1-1", "Q-1 > Q-1-2", "Q-2 > Q-2-1", "Q-2 > Q-2-2"}
+	if got := questionTree(start, added); !reflect.DeepEqual(got, want) {
+		t.Fatalf("explored tree = %q, want %q", got, want)
+	}
+	for _, n := range added {
+		if !n.IsComplete() || n.Answer != "A:"+n.Question || n.Title != "T:"+n.Question {
+			t.Errorf("node %q = %q %q (status %q)", n.Question, n.Title, n.Answer, n.Status)
+		}
+		if n.Generation == nil || n.Generation.Provider != "openai" || n.Generation.Model != "gpt-x" {
+			t.Errorf("node %q generation = %+v", n.Question, n.Generation)
+		}
+	}
+
+	// 最も深いノードにはフォローアップ質問を求めません。
+	followUps := provider.requestsOf(requestFollowUps)
+	if len(followUps) != 3 {
+		t.Fatalf("got %d follow-up requests, want one per node above the max depth", len(followUps))
+	}
+	// 子ノードへの質問は、開始ノードからその子ノードまでの会話を文脈にします。
+	if got := messageTexts(followUps[1].Messages); len(got) != 5 || got[0] != "user: Q" || got[2] != "user: Q-1" || got[3] != "model: A:Q-1" {
+		t.Errorf("follow-up request for Q-1 = %q", got)
+	}
+	answers := provider.requestsOf(requestAnswer)
+	if got := messageTexts(answers[len(answers)-1].Messages); !reflect.DeepEqual(got, []string{"user: Q", "model: A:Q", "user: Q-2", "model: A:Q-2", "user: Q-2-2"}) {
+		t.Errorf("last answer request = %q", got)
+	}
+}
+
+func TestExploreStopsAtMaxNodes(t *testing.T) {
+	useConfig(t, config.Config{})
+	provider := newFakeProvider("openai", "gpt-x")
+	start := newCompleteNode("start", "", "Q", "A:Q")
+	a := newTestApp(t, provider, start)
+
+	added := runExplore(t, a, "start", exploreOptions{Depth: 3, Breadth: 2, MaxNodes: 3})
+
+	want := []string{"Q > Q-1", "Q > Q-2", "Q-1 > Q-1-1"}
+	if got := questionTree(start, added); !reflect.DeepEqual(got, want) {
+		t.Fatalf("explored tree = %q, want %q", got, want)
+	}
+	if n := len(provider.requestsOf(requestAnswer)); n != 3 {
+		t.Errorf("got %d answer requests, want no answers beyond the max nodes", n)
+	}
+	if n := len(provider.requestsOf(requestFollowUps)); n != 2 {
+		t.Errorf("got %d follow-up requests, want none after reaching the max nodes", n)
+	}
+	var status string
+	fyne.DoAndWait(func() {
+		status = a.statusLabel.Text
+	})
+	if status != "探索が完了しました (3件のノードを追加)" {
+		t.Errorf("status = %q", status)
+	}
+}
+
+func TestExploreKeepsFailedAnswersAsLeaves(t *testing.T) {
+	useConfig(t, config.Config{})
+	provider := newFakeProvider("openai", "gpt-x")
+	provider.failWith("Q-1", errors.New("quota exceeded"))
+	start := newCompleteNode("start", "", "Q", "A:Q")
+	a := newTestApp(t, provider, start)
+
+	added := runExplore(t, a, "start", exploreOptions{Depth: 2, Breadth: 2, MaxNodes: 100})
+
+	// 回答に失敗したノードは失敗したノードとして追加し、その先は掘り下げません。
+	want := []string{"Q > Q-1", "Q > Q-2", "Q-2 > Q-2-1", "Q-2 > Q-2-2"}
+	if got := questionTree(start, added); !reflect.DeepEqual(got, want) {
+		t.Fatalf("explored tree = %q, want %q", got, want)
+	}
+	if failed := added[0]; !failed.IsFailed() || failed.Answer != "" || failed.Error != "quota exceeded" || failed.Title != "Q-1" {
+		t.Errorf("failed node = %q %q (status %q, error %q)", failed.Title, failed.Answer, failed.Status, failed.Error)
+	}
+}
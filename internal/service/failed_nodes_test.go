@@ -0,0 +1,128 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"errors"
+	"strings"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestFailedNodeIsRetriedInPlace(t *testing.T) {
+	useConfig(t, config.Config{})
+	provider := newFakeProvider("openai", "gpt-x")
+	provider.failWith("失敗する質問", errors.New("503 service unavailable"))
+	a := newTestApp(t, provider, newCompleteNode("root", "", "ルートの質問", "ルートの回答"))
+
+	fyne.DoAndWait(func() {
+		a.dialogCanvas.SetBranchSource("root")
+		a.chatInput.SetText("失敗する質問")
+		a.sendQuestion()
+	})
+	waitForRequest(t, a)
+
+	// 失敗した回答はエラーの内容とともに失敗したノードとして残り、回答にはエラーを書き込みません。
+	children := childrenOf(a, "root")
+	if len(children) != 1 {
+		t.Fatalf("got %d children, want the failed node", len(children))
+	}
+	node := children[0]
+	if !node.IsFailed() || node.Answer != "" || !strings.Contains(node.Error, "503 service unavailable") || node.Title != "失敗する質問" {
+		t.Fatalf("after a failed send: %q %q (status %q, error %q)", node.Title, node.Answer, node.Status, node.Error)
+	}
+
+	regenerate := func() {
+		t.Helper()
+		fyne.DoAndWait(func() {
+			a.regenerateNode(node.ID)
+		})
+		waitForRequest(t, a)
+	}
+
+	// 再試行にも失敗した場合は、新しいエラーで失敗したノードのままです。
+	provider.failWith("失敗する質問", errors.New("quota exceeded"))
+	regenerate()
+	if !node.IsFailed() || node.Answer != "" || node.Error != "quota exceeded" {
+		t.Errorf("after a failed retry: %q (status %q, error %q)", node.Answer, node.Status, node.Error)
+	}
+
+	// 再試行に成功した場合は、バージョンを追加せずにノードの回答にします。
+	provider.failWith("失敗する質問", nil)
+	regenerate()
+	if !node.IsComplete() || node.Error != "" || node.Answer != "A:失敗する質問" || node.Title != "T:失敗する質問" {
+		t.Errorf("after a successful retry: %q %q (status %q, error %q)", node.Title, node.Answer, node.Status, node.Error)
+	}
+	if node.VersionCount() != 1 {
+		t.Errorf("retry added a version: %d versions", node.VersionCount())
+	}
+	if node.Generation == nil || node.Generation.Provider != "openai" || node.Generation.Model != "gpt-x" {
+		t.Errorf("generation = %+v", node.Generation)
+	}
+
+	// 回答済みのノードの再生成は、これまでどおり新しいバージョンになります。
+	regenerate()
+	if node.VersionCount() != 2 || node.ActiveVersion != 1 {
+		t.Errorf("regenerating a complete node: %d versions, active %d", node.VersionCount(), node.ActiveVersion)
+	}
+}
+
+func TestRetryingFailedFanOutNodeUsesItsModel(t *testing.T) {
+	useConfig(t, config.Config{})
+	provider := newFakeProvider("openai", "gpt-x")
+	gemini := newFakeProvider("gemini", "gemini-x")
+	failed := &ui.NodeData{
+		ID:         "failed",
+		ParentID:   "root",
+		Question:   "比較する質問",
+		Generation: &ui.GenerationSettings{Provider: "gemini", Model: "gemini-x"},
+		Status:     ui.NodeStatusFailed,
+		Error:      "quota exceeded",
+	}
+	a := newTestApp(t, provider, newCompleteNode("root", "", "ルートの質問", "ルートの回答"), failed)
+	a.fanOutProviders = []ai_client.Provider{newFakeProvider("ollama", "llama-x"), gemini}
+
+	fyne.DoAndWait(func() {
+		a.regenerateNode("failed")
+	})
+	waitForRequest(t, a)
+
+	if answers := provider.requestsOf(requestAnswer); len(answers) != 0 {
+		t.Errorf("the default provider answered %d times", len(answers))
+	}
+	answers := gemini.requestsOf(requestAnswer)
+	if len(answers) != 1 || answers[0].Model != "" {
+		t.Fatalf("gemini answer requests = %+v, want one with its own model", answers)
+	}
+	if !failed.IsComplete() || failed.Answer != "A:比較する質問" || failed.Generation.Provider != "gemini" || failed.Generation.Model != "gemini-x" {
+		t.Errorf("retried node = %q (status %q, generation %+v)", failed.Answer, failed.Status, failed.Generation)
+	}
+}
+
+func TestLoadingProjectMarksInterruptedNodesFailed(t *testing.T) {
+	useConfig(t, config.Config{})
+	pending := &ui.NodeData{ID: "pending", ParentID: "root", Question: "生成中の質問", Answer: "途中まで", Status: ui.NodeStatusPending}
+	failed := &ui.NodeData{ID: "failed", ParentID: "root", Question: "失敗した質問", Status: ui.NodeStatusFailed, Error: "quota exceeded"}
+	a := newTestApp(t, newFakeProvider("openai", "gpt-x"), newCompleteNode("root", "", "ルートの質問", "ルートの回答"), pending, failed)
+
+	fyne.DoAndWait(func() {
+		saveData(a, "project", "プロジェクト")
+		a.loadProjectData("project")
+	})
+
+	loaded := map[string]*ui.NodeData{}
+	for _, n := range a.nodes {
+		loaded[n.ID] = n
+	}
+	if n := loaded["root"]; n == nil || !n.IsComplete() {
+		t.Errorf("root = %+v", n)
+	}
+	if n := loaded["pending"]; n == nil || !n.IsFailed() || n.Error == "" {
+		t.Errorf("interrupted node = %+v, want failed", n)
+	}
+	if n := loaded["failed"]; n == nil || !n.IsFailed() || n.Error != "quota exceeded" {
+		t.Errorf("failed node = %+v, want its error kept", n)
+	}
+}
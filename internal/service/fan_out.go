@@ -0,0 +1,193 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"fmt"
+	"log"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/google/uuid"
+)
+
+// newFanOutProviders は設定の fan_out_providers から比較送信の対象プロバイダを作成します。
+// 作成に失敗した対象はログに記録して除外します。
+func newFanOutProviders(cfg config.Config) []ai_client.Provider {
+	providers := make([]ai_client.Provider, 0, len(cfg.FanOutProviders))
+	for _, spec := range cfg.FanOutProviders {
+		provider, err := ai_client.NewProviderFromSpec(cfg, spec)
+		if err != nil {
+			log.Printf("比較送信の対象 %q を初期化できませんでした: %v", spec, err)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	if len(providers) > 0 {
+		log.Printf("比較送信の対象: %d件", len(providers))
+	}
+	return providers
+}
+
+// newFanOutCheck は入力バーに表示する比較送信の切り替えを作成します。
+// 対象が2件未満の場合は比較にならないため無効にします。
+func (a *App) newFanOutCheck() *widget.Check {
+	check := widget.NewCheck(fmt.Sprintf("比較送信 (%dモデル)", len(a.fanOutProviders)), nil)
+	if len(a.fanOutProviders) < 2 {
+		check.Disable()
+	}
+	return check
+}
+
+// fanOutProviderFor はノードの生成設定と同じプロバイダとモデルの比較送信対象を返します。見つからない場合はnilを返します。
+func (a *App) fanOutProviderFor(gs *ui.GenerationSettings) ai_client.Provider {
+	if gs == nil {
+		return nil
+	}
+	for _, provider := range a.fanOutProviders {
+		info := provider.ModelInfo()
+		if info.Provider == gs.Provider && info.Model == gs.Model {
+			return provider
+		}
+	}
+	return nil
+}
+
+// handleFanOutSend は同じ質問を比較送信の対象すべてに同時に送信し、
+// 分岐元の下にモデルごとの兄弟ノードを作成します。送信を開始できた場合は true を返します。UIスレッドから呼び出します。
+func (a *App) handleFanOutSend(question string, generation *ui.GenerationSettings) bool {
+	isNewProject := a.currentProjectID == ""
+
+	ctx, cancel, ok := a.beginRequest(fmt.Sprintf("%dモデルで応答生成中...", len(a.fanOutProviders)))
+	if !ok {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return false
+	}
+	log.Printf("比較送信: %s (%dモデル, プロジェクト: %s)", question, len(a.fanOutProviders), a.currentProjectID)
+	a.chatInput.SetText("")
+
+	parentID := a.dialogCanvas.GetBranchSource()
+	attachments := a.takePendingAttachments()
+	references := a.retrieveReferences(parentID, question)
+
+	placeholders := make([]*ui.NodeData, len(a.fanOutProviders))
+	for i, provider := range a.fanOutProviders {
+		info := provider.ModelInfo()
+		nodeGeneration := *generation
+		nodeGeneration.Provider = info.Provider
+		nodeGeneration.Model = info.Model
+		placeholders[i] = &ui.NodeData{
+			ID:          uuid.NewString(),
+			Title:       "生成中... (" + info.String() + ")",
+			Question:    question,
+			ParentID:    parentID,
+			Generation:  &nodeGeneration,
+			Status:      ui.NodeStatusPending,
+			Attachments: attachments,
+			References:  references,
+		}
+		a.addNode(placeholders[i])
+	}
+	a.dialogCanvas.SetBranchSource(parentID)
+	projectID := a.currentProjectID
+
+	go func() {
+		status := "準備完了"
+		defer func() {
+			a.finishRequest(cancel, status)
+		}()
+
+		summaryProvider := a.aiProvider
+		if summaryProvider == nil {
+			summaryProvider = a.fanOutProviders[0]
+		}
+		a.ensureHistorySummary(ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(nil)), summaryProvider, parentID)
+		// モデル選択は既定のプロバイダ用なので、各対象には生成パラメータのみを引き継ぎます。
+		baseRequest := a.newChatRequest(parentID, parentID, question, attachments, references, generation)
+		baseRequest.Model = ""
+
+		var wg sync.WaitGroup
+		titles := make([]string, len(placeholders))
+		for i, provider := range a.fanOutProviders {
+			wg.Add(1)
+			go func(i int, provider ai_client.Provider, nodeData *ui.NodeData) {
+				defer wg.Done()
+				ctx := ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(nodeData))
+				request := *baseRequest
+
+				var answerText string
+				resp, err := a.streamAnswer(ctx, provider, nodeData, &request, projectID)
+				if ctx.Err() != nil {
+					fyne.Do(func() {
+						a.discardNode(nodeData.ID)
+					})
+					return
+				}
+				if err != nil {
+					log.Printf("AI Provider Error (%s): %v", provider.ModelInfo(), err)
+				} else {
+					answerText = resp.Text
+					recorded := recordedGeneration(nodeData.Generation, provider, resp)
+					fyne.Do(func() {
+						a.withNodesLocked(func() {
+							nodeData.Generation = recorded
+						})
+					})
+				}
+
+				nodeTitle := fallbackNodeTitle(question)
+				if err == nil {
+					nodeTitle = a.generateNodeTitle(ctx, provider, question, answerText)
+				}
+				titles[i] = nodeTitle
+
+				fyne.Do(func() {
+					a.withNodesLocked(func() {
+						nodeData.Title = nodeTitle
+						nodeData.Answer = answerText
+						if err != nil {
+							nodeData.MarkFailed(err)
+						} else {
+							nodeData.MarkComplete()
+						}
+					})
+					a.completeNode(nodeData)
+				})
+			}(i, provider, placeholders[i])
+		}
+		wg.Wait()
+
+		// キャンセル前に完了したノードは残し、未完了のノードだけが破棄されています。
+		projectName := ""
+		for _, title := range titles {
+			if title != "" {
+				projectName = title
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			log.Printf("Fan-out request cancelled: %v", ctx.Err())
+			status = "AI応答の生成をキャンセルしました"
+		}
+		fyne.Do(func() {
+			a.dialogCanvas.SetBranchSource(parentID)
+			if projectName == "" {
+				if a.chatInput != nil && a.chatInput.Text == "" {
+					a.chatInput.SetText(question)
+					a.setPendingAttachments(attachments)
+				}
+				return
+			}
+			if isNewProject && a.currentProjectID == "" {
+				a.createProject(projectName)
+			}
+			if a.currentProjectID != "" {
+				a.saveCurrentProject()
+			}
+		})
+	}()
+	return true
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestFanOutSendAnswersEachModelInItsOwnNode(t *testing.T) {
+	useConfig(t, config.Config{})
+	openai := newFakeProvider("openai", "gpt-x")
+	ollama := newFakeProvider("ollama", "llama-x")
+	gemini := newFakeProvider("gemini", "gemini-x")
+	gemini.failWith("比較する質問", errors.New("quota exceeded"))
+	a := newTestApp(t, newFakeProvider("openai", "default-model"), newCompleteNode("root", "", "ルートの質問", "ルートの回答"))
+	a.fanOutProviders = []ai_client.Provider{openai, ollama, gemini}
+
+	fyne.DoAndWait(func() {
+		a.fanOutCheck.SetChecked(true)
+		a.dialogCanvas.SetBranchSource("root")
+		a.temperatureEntry.SetText("0.3")
+		a.chatInput.SetText("比較する質問")
+		if !a.sendQuestion() {
+			t.Error("sendQuestion() = false")
+		}
+	})
+	waitForRequest(t, a)
+
+	children := childrenOf(a, "root")
+	if len(children) != 3 {
+		t.Fatalf("got %d sibling nodes, want one per model", len(children))
+	}
+	for i, provider := range []*fakeProvider{openai, ollama, gemini} {
+		node := children[i]
+		if node.Generation == nil || node.Generation.Provider != provider.info.Provider || node.Generation.Model != provider.info.Model {
+			t.Errorf("node %d generation = %+v, want %s", i, node.Generation, provider.info)
+			continue
+		}
+		if node.Generation.Temperature == nil || *node.Generation.Temperature != 0.3 {
+			t.Errorf("node %d lost the generation parameters: %+v", i, node.Generation)
+		}
+
+		// 各対象には同じ文脈と生成パラメータが送られ、モデルは対象の既定のものが使われます。
+		answers := provider.requestsOf(requestAnswer)
+		if len(answers) != 1 {
+			t.Fatalf("%s received %d answer requests, want 1", provider.info, len(answers))
+		}
+		want := []string{"user: ルートの質問", "model: ルートの回答", "user: 比較する質問"}
+		if got := messageTexts(answers[0].Messages); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s messages = %q, want %q", provider.info, got, want)
+		}
+		if answers[0].Model != "" || answers[0].Params.Temperature == nil || *answers[0].Params.Temperature != 0.3 {
+			t.Errorf("%s model/params = %q %+v", provider.info, answers[0].Model, answers[0].Params)
+		}
+	}
+
+	// 失敗した対象は他の対象の回答に影響せず、そのノードだけが失敗になります。
+	for _, node := range children[:2] {
+		if !node.IsComplete() || node.Answer != "A:比較する質問" || node.Title != "T:比較する質問" {
+			t.Errorf("node %s = %q %q (status %q)", node.Generation.Model, node.Title, node.Answer, node.Status)
+		}
+	}
+	if failed := children[2]; !failed.IsFailed() || failed.Answer != "" || !strings.Contains(failed.Error, "quota exceeded") || failed.Title != "比較する質問" {
+		t.Errorf("failed node = %q %q (status %q, error %q)", failed.Title, failed.Answer, failed.Status, failed.Error)
+	}
+	if titles := gemini.requestsOf(requestTitle); len(titles) != 0 {
+		t.Errorf("failed target was asked for %d titles", len(titles))
+	}
+
+	// 最初のノードのタイトルでプロジェクトを作成して保存します。
+	if a.currentProjectName != "T:比較する質問" {
+		t.Errorf("project name = %q", a.currentProjectName)
+	}
+	if _, err := os.Stat(filepath.Join(projectsBaseDir, a.currentProjectID, yamlFileName)); err != nil {
+		t.Errorf("project was not saved: %v", err)
+	}
+}
+
+func TestFanOutProviderFor(t *testing.T) {
+	openai := newFakeProvider("openai", "gpt-x")
+	ollama := newFakeProvider("ollama", "gpt-x")
+	a := newHeadlessApp()
+	a.fanOutProviders = []ai_client.Provider{openai, ollama}
+
+	if got := a.fanOutProviderFor(&ui.GenerationSettings{Provider: "ollama", Model: "gpt-x"}); got != ollama {
+		t.Errorf("fanOutProviderFor(ollama/gpt-x) = %v", got)
+	}
+	if got := a.fanOutProviderFor(&ui.GenerationSettings{Provider: "openai", Model: "other"}); got != nil {
+		t.Errorf("fanOutProviderFor(openai/other) = %v, want nil", got)
+	}
+	if got := a.fanOutProviderFor(nil); got != nil {
+		t.Errorf("fanOutProviderFor(nil) = %v, want nil", got)
+	}
+}
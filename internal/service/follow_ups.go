@@ -0,0 +1,67 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"log"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+)
+
+// suggestFollowUps は指定ノードまでの会話に続くフォローアップ質問をAIに提案させ、ゴーストノードとして表示します。
+func (a *App) suggestFollowUps(nodeID string) {
+	nodeData := a.findNodeData(nodeID)
+	if nodeData == nil || !nodeData.IsComplete() {
+		return
+	}
+	if a.aiProvider == nil {
+		dialog.ShowInformation("情報", "AIプロバイダが初期化されていません。", a.window)
+		return
+	}
+	ctx, cancel, ok := a.beginRequest("フォローアップ質問を提案中...")
+	if !ok {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return
+	}
+	provider := a.aiProvider
+
+	go func() {
+		status := "準備完了"
+		defer func() {
+			a.finishRequest(cancel, status)
+		}()
+
+		ctx := ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(nodeData))
+		history := a.getConversationHistory(nodeID)
+		questions, err := ai_client.SuggestFollowUps(ctx, provider, a.resolveSystemPrompt(nodeID), history, config.Cfg.FollowUpCount)
+		if ctx.Err() != nil {
+			status = "フォローアップ質問の提案をキャンセルしました"
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to suggest follow-up questions for node %s: %v", nodeID, err)
+			status = "フォローアップ質問の提案に失敗しました"
+			fyne.Do(func() {
+				dialog.ShowError(err, a.window)
+			})
+			return
+		}
+		fyne.Do(func() {
+			a.dialogCanvas.ShowSuggestions(nodeID, questions)
+		})
+	}()
+}
+
+// handleSuggestionSelected はクリックされた提案をそのノードを分岐元とする質問として送信します。
+// 送信を開始できた場合は true を返します。UIスレッドから呼び出します。
+func (a *App) handleSuggestionSelected(parentID string, question string) bool {
+	if a.isRequestRunning() {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return false
+	}
+	log.Printf("Suggested follow-up selected for node %s: %s", parentID, question)
+	a.dialogCanvas.SetBranchSource(parentID)
+	a.chatInput.SetText(question)
+	return a.sendQuestion()
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+)
+
+// ghostsOf はキャンバスに表示しているゴーストノードを返します。
+func ghostsOf(a *App) []*ui.GhostNode {
+	var ghosts []*ui.GhostNode
+	fyne.DoAndWait(func() {
+		for _, o := range test.LaidOutObjects(a.dialogCanvas) {
+			if g, ok := o.(*ui.GhostNode); ok {
+				ghosts = append(ghosts, g)
+			}
+		}
+	})
+	return ghosts
+}
+
+func ghostQuestions(ghosts []*ui.GhostNode) []string {
+	questions := make([]string, len(ghosts))
+	for i, g := range ghosts {
+		questions[i] = g.Question
+	}
+	return questions
+}
+
+func TestFollowUpSuggestionsAreShownAsGhostNodes(t *testing.T) {
+	useConfig(t, config.Config{FollowUpCount: 3})
+	provider := newFakeProvider("openai", "gpt-x")
+	root := newCompleteNode("root", "", "ルートの質問", "ルートの回答")
+	root.SystemPrompt = "ルートのシステムプロンプト"
+	a := newTestApp(t, provider, root, &ui.NodeData{ID: "failed", ParentID: "root", Question: "失敗した質問", Status: ui.NodeStatusFailed, Error: "quota exceeded"})
+	a.currentProjectID = "project"
+
+	suggest := func(nodeID string) {
+		t.Helper()
+		fyne.DoAndWait(func() {
+			a.suggestFollowUps(nodeID)
+		})
+		waitForRequest(t, a)
+	}
+
+	// 回答済みのノードの会話とシステムプロンプトを送り、提案を子のゴーストノードとして表示します。
+	suggest("root")
+	requests := provider.requestsOf(requestFollowUps)
+	if len(requests) != 1 {
+		t.Fatalf("got %d follow-up requests", len(requests))
+	}
+	if got := messageTexts(requests[0].Messages); len(got) != 3 || got[0] != "user: ルートの質問" || got[1] != "model: ルートの回答" || !strings.Contains(got[2], "3個") {
+		t.Errorf("follow-up request = %q", got)
+	}
+	if requests[0].System != "ルートのシステムプロンプト" {
+		t.Errorf("System = %q", requests[0].System)
+	}
+	want := []string{"ルートの質問-1", "ルートの質問-2", "ルートの質問-3"}
+	ghosts := ghostsOf(a)
+	if got := ghostQuestions(ghosts); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ghost questions = %q, want %q", got, want)
+	}
+	for _, g := range ghosts {
+		if g.ParentID != "root" {
+			t.Errorf("ghost %q has parent %q", g.Question, g.ParentID)
+		}
+	}
+
+	// 提案を選ぶと、そのノードを分岐元とする質問として送信し、選んだゴーストノードだけを取り除きます。
+	fyne.DoAndWait(func() {
+		test.Tap(ghosts[1])
+	})
+	waitForRequest(t, a)
+	var sent *ui.NodeData
+	for _, n := range childrenOf(a, "root") {
+		if n.Question == "ルートの質問-2" {
+			sent = n
+		}
+	}
+	if sent == nil || !sent.IsComplete() || sent.Answer != "A:ルートの質問-2" {
+		t.Fatalf("selected suggestion was not sent: %+v", sent)
+	}
+	if got := ghostQuestions(ghostsOf(a)); !reflect.DeepEqual(got, []string{"ルートの質問-1", "ルートの質問-3"}) {
+		t.Errorf("ghosts after selecting one = %q", got)
+	}
+
+	// 提案は保存されず、送信した質問だけがプロジェクトに残ります。
+	saved, err := os.ReadFile(filepath.Join(projectsBaseDir, "project", mdNodesDirName, sent.ID+".md"))
+	if err != nil {
+		t.Fatalf("sent node was not saved: %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(projectsBaseDir, "project", mdNodesDirName))
+	for _, e := range entries {
+		data, _ := os.ReadFile(filepath.Join(projectsBaseDir, "project", mdNodesDirName, e.Name()))
+		if strings.Contains(string(data), "ルートの質問-1") {
+			t.Errorf("unsent suggestion was saved in %s", e.Name())
+		}
+	}
+	if !strings.Contains(string(saved), "ルートの質問-2") {
+		t.Errorf("saved node = %q", saved)
+	}
+
+	// 同じノードへの提案は以前の提案を置き換えます。
+	suggest("root")
+	if got := ghostQuestions(ghostsOf(a)); !reflect.DeepEqual(got, want) {
+		t.Errorf("ghosts after suggesting again = %q, want %q", got, want)
+	}
+
+	// 回答のないノードには提案しません。
+	suggest("failed")
+	if n := len(provider.requestsOf(requestFollowUps)); n != 2 {
+		t.Errorf("got %d follow-up requests, want none for the failed node", n)
+	}
+
+	// ノードを削除すると、そのノードへの提案も取り除きます。
+	a.requestNodeDeletion("root")
+	if ghosts := ghostsOf(a); len(ghosts) != 0 {
+		t.Errorf("ghosts after deleting the parent = %q", ghostQuestions(ghosts))
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// generationEntryWidth は入力バーの生成パラメータ入力欄の幅です。
+const generationEntryWidth float32 = 80
+
+// newGenerationSettingsBar は入力バーに表示するモデル選択と生成パラメータの入力欄を作成します。
+func (a *App) newGenerationSettingsBar() fyne.CanvasObject {
+	a.modelSelect = widget.NewSelect(nil, nil)
+	a.modelSelect.PlaceHolder = "既定のモデル"
+
+	newParamEntry := func() *widget.Entry {
+		e := widget.NewEntry()
+		e.SetPlaceHolder("既定")
+		return e
+	}
+	a.temperatureEntry = newParamEntry()
+	a.topPEntry = newParamEntry()
+	a.topKEntry = newParamEntry()
+	a.maxTokensEntry = newParamEntry()
+
+	sized := func(e *widget.Entry) fyne.CanvasObject {
+		return container.NewGridWrap(fyne.NewSize(generationEntryWidth, e.MinSize().Height), e)
+	}
+
+	a.resetGenerationSettings()
+	a.refreshModelOptions()
+
+	return container.NewHBox(
+		widget.NewLabel("モデル"), a.modelSelect,
+		widget.NewLabel("温度"), sized(a.temperatureEntry),
+		widget.NewLabel("Top-P"), sized(a.topPEntry),
+		widget.NewLabel("Top-K"), sized(a.topKEntry),
+		widget.NewLabel("最大トークン"), sized(a.maxTokensEntry),
+	)
+}
+
+// defaultModelName はプロバイダの既定モデル名を返します。
+func (a *App) defaultModelName() string {
+	if a.aiProvider == nil {
+		return ""
+	}
+	return a.aiProvider.ModelInfo().Model
+}
+
+// refreshModelOptions はプロバイダから利用可能なモデルの一覧を取得し、モデル選択肢を更新します。
+func (a *App) refreshModelOptions() {
+	options := []string{}
+	if name := a.defaultModelName(); name != "" {
+		options = append(options, name)
+	}
+	a.setModelOptions(options)
+
+	lister, ok := a.aiProvider.(ai_client.ModelLister)
+	if !ok {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		models, err := lister.ListModels(ctx)
+		if err != nil {
+			log.Printf("モデル一覧の取得に失敗しました: %v", err)
+			return
+		}
+		fyne.Do(func() {
+			a.setModelOptions(models)
+		})
+	}()
+}
+
+// setModelOptions はモデルの選択肢を設定します。現在の選択と既定モデルは常に選択肢に含めます。
+func (a *App) setModelOptions(models []string) {
+	seen := make(map[string]bool)
+	options := []string{}
+	for _, m := range append([]string{a.defaultModelName(), a.modelSelect.Selected}, models...) {
+		if m == "" || seen[m] {
+			continue
+		}
+		seen[m] = true
+		options = append(options, m)
+	}
+	a.modelSelect.SetOptions(options)
+}
+
+// resetGenerationSettings は入力バーの設定を既定値 (既定モデル、パラメータ未指定) に戻します。
+func (a *App) resetGenerationSettings() {
+	a.applyGenerationSettings(nil)
+}
+
+// applyGenerationSettings は保存されている生成設定を入力バーに反映します。nilの場合は既定値に戻します。
+func (a *App) applyGenerationSettings(gs *ui.GenerationSettings) {
+	if gs == nil {
+		gs = &ui.GenerationSettings{}
+	}
+	model := gs.Model
+	if model == "" {
+		model = a.defaultModelName()
+	}
+	if model != "" {
+		a.setModelOptions(append(a.modelSelect.Options, model))
+		a.modelSelect.SetSelected(model)
+	} else {
+		a.modelSelect.ClearSelected()
+	}
+	a.temperatureEntry.SetText(formatFloatParam(gs.Temperature))
+	a.topPEntry.SetText(formatFloatParam(gs.TopP))
+	a.topKEntry.SetText(formatIntParam(gs.TopK))
+	a.maxTokensEntry.SetText(formatIntParam(gs.MaxOutputTokens))
+}
+
+// currentGenerationSettings は入力バーの内容から生成設定を作成します。
+// 数値として解釈できない入力がある場合はエラーを返します。
+func (a *App) currentGenerationSettings() (*ui.GenerationSettings, error) {
+	gs := &ui.GenerationSettings{Model: a.modelSelect.Selected}
+	var err error
+	if gs.Temperature, err = parseFloatParam("温度", a.temperatureEntry.Text); err != nil {
+		return nil, err
+	}
+	if gs.TopP, err = parseFloatParam("Top-P", a.topPEntry.Text); err != nil {
+		return nil, err
+	}
+	if gs.TopK, err = parseIntParam("Top-K", a.topKEntry.Text); err != nil {
+		return nil, err
+	}
+	if gs.MaxOutputTokens, err = parseIntParam("最大トークン", a.maxTokensEntry.Text); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// toGenerationParams は生成設定をプロバイダに渡す生成パラメータに変換します。
+func toGenerationParams(gs *ui.GenerationSettings) ai_client.GenerationParams {
+	if gs == nil {
+		return ai_client.GenerationParams{}
+	}
+	return ai_client.GenerationParams{
+		Temperature:     gs.Temperature,
+		TopP:            gs.TopP,
+		TopK:            gs.TopK,
+		MaxOutputTokens: gs.MaxOutputTokens,
+	}
+}
+
+func parseFloatParam(name string, text string) (*float32, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(text, 32)
+	if err != nil || v < 0 {
+		return nil, fmt.Errorf("%sには0以上の数値を入力してください: %q", name, text)
+	}
+	f := float32(v)
+	return &f, nil
+}
+
+func parseIntParam(name string, text string) (*int32, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(text, 10, 32)
+	if err != nil || v <= 0 {
+		return nil, fmt.Errorf("%sには1以上の整数を入力してください: %q", name, text)
+	}
+	i := int32(v)
+	return &i, nil
+}
+
+func formatFloatParam(v *float32) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*v), 'g', -1, 32)
+}
+
+func formatIntParam(v *int32) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(int64(*v), 10)
+}
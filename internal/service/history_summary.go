@@ -0,0 +1,122 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+
+	"fyne.io/fyne/v2"
+)
+
+// summaryCutoff は conversationPathLocked が返す長さ n の経路のうち、要約に置き換える最後のノードの位置を返します。
+// 直近 history_summary_turns 件はそのまま送るため、それより古いノードがない場合は -1 を返します。
+func summaryCutoff(n int) int {
+	keep := config.Cfg.HistorySummaryTurns
+	if keep <= 0 || n <= keep {
+		return -1
+	}
+	return n - keep - 1
+}
+
+// conversationHash はルートから順に並んだノードの質問と回答のハッシュを返します。
+// 祖先の回答が編集・再生成・バージョン切り替えされるとハッシュが変わり、要約が作り直されます。
+func conversationHash(path []*ui.NodeData) string {
+	h := sha256.New()
+	for _, n := range path {
+		h.Write([]byte(n.ID))
+		h.Write([]byte{0})
+		h.Write([]byte(n.Question))
+		h.Write([]byte{0})
+		h.Write([]byte(n.Answer))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// summaryIsValid は path の最後のノードが、path 全体の現在の内容に対応する要約を持っているかどうかを返します。
+func summaryIsValid(path []*ui.NodeData) bool {
+	if len(path) == 0 {
+		return false
+	}
+	last := path[len(path)-1]
+	return last.Summary != "" && last.SummaryHash == conversationHash(path)
+}
+
+// summaryMessages は要約を会話の先頭に置くユーザー/モデルのターンに変換します。
+// 要約はトークン予算を超えた場合も取り除かず、その後の会話ターンから取り除くよう Pinned にします。
+func summaryMessages(summary string) []ai_client.Message {
+	return []ai_client.Message{
+		{Role: ai_client.RoleUser, Text: "これまでの会話の要約です。以降の質問はこの内容を踏まえています。\n\n" + summary, Pinned: true},
+		{Role: ai_client.RoleModel, Text: "承知しました。要約の内容を踏まえて回答します。", Pinned: true},
+	}
+}
+
+// ensureHistorySummary は parentID までの経路が長い場合に、古い祖先の要約を用意します。
+// 要約は要約対象の最後のノードに保存し、最も近い有効な要約に続きの会話を加えて更新します (ローリング要約)。
+// 要約に失敗した場合は要約せずに送信するため、エラーはログに記録するだけです。UIスレッド以外から呼び出します。
+func (a *App) ensureHistorySummary(ctx context.Context, provider ai_client.Provider, parentID string) {
+	if provider == nil || parentID == "" {
+		return
+	}
+
+	a.nodesMutex.RLock()
+	path := a.conversationPathLocked(parentID)
+	cutoff := summaryCutoff(len(path))
+	if cutoff < 0 || summaryIsValid(path[:cutoff+1]) {
+		a.nodesMutex.RUnlock()
+		return
+	}
+	start := -1
+	for i := cutoff - 1; i >= 0; i-- {
+		if summaryIsValid(path[:i+1]) {
+			start = i
+			break
+		}
+	}
+	var previousSummary string
+	if start >= 0 {
+		previousSummary = path[start].Summary
+	}
+	turns := make([]ai_client.Message, 0, (cutoff-start)*2)
+	for _, n := range path[start+1 : cutoff+1] {
+		turns = append(turns,
+			a.userMessageLocked(n),
+			ai_client.Message{Role: ai_client.RoleModel, Text: n.Answer},
+		)
+	}
+	target := path[cutoff]
+	hash := conversationHash(path[:cutoff+1])
+	a.nodesMutex.RUnlock()
+
+	log.Printf("Summarizing %d ancestor turns up to node %s", len(turns)/2, target.ID)
+	var previousStatus string
+	fyne.DoAndWait(func() {
+		if a.statusLabel != nil {
+			previousStatus = a.statusLabel.Text
+			a.statusLabel.SetText("会話履歴を要約中...")
+		}
+	})
+	defer fyne.Do(func() {
+		if a.statusLabel != nil {
+			a.statusLabel.SetText(previousStatus)
+		}
+	})
+	summary, err := ai_client.SummarizeConversation(ctx, provider, previousSummary, turns)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("会話履歴の要約に失敗したため、要約せずに送信します: %v", err)
+		}
+		return
+	}
+
+	fyne.DoAndWait(func() {
+		a.nodesMutex.Lock()
+		target.Summary = summary
+		target.SummaryHash = hash
+		a.nodesMutex.Unlock()
+	})
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+// newChain は n1 から nN までの一本道の会話を作成します。ノード ni の質問は "質問i"、回答は "回答i" です。
+func newChain(n int) []*ui.NodeData {
+	nodes := make([]*ui.NodeData, n)
+	for i := range nodes {
+		parentID := ""
+		if i > 0 {
+			parentID = nodes[i-1].ID
+		}
+		nodes[i] = newCompleteNode(fmt.Sprintf("n%d", i+1), parentID, fmt.Sprintf("質問%d", i+1), fmt.Sprintf("回答%d", i+1))
+	}
+	return nodes
+}
+
+func TestRollingHistorySummary(t *testing.T) {
+	useConfig(t, config.Config{HistorySummaryTurns: 2})
+	provider := newFakeProvider("openai", "gpt-x")
+	nodes := newChain(6)
+	a := newTestApp(t, provider, nodes[:5]...)
+	ctx := context.Background()
+
+	summaries := func(want int) []string {
+		t.Helper()
+		requests := provider.requestsOf(requestSummary)
+		if len(requests) != want {
+			t.Fatalf("got %d summary requests, want %d", len(requests), want)
+		}
+		prompts := make([]string, len(requests))
+		for i, req := range requests {
+			prompts[i] = req.Messages[0].Text
+		}
+		return prompts
+	}
+	history := func(nodeID string) []string {
+		t.Helper()
+		return messageTexts(a.getConversationHistory(nodeID))
+	}
+
+	// 直近2件より古い n1〜n3 を要約し、要約対象の最後のノード n3 に保存します。
+	a.ensureHistorySummary(ctx, provider, "n5")
+	prompt := summaries(1)[0]
+	for _, part := range []string{"質問1", "回答1", "質問3", "回答3"} {
+		if !strings.Contains(prompt, part) {
+			t.Errorf("summary prompt is missing %q:\n%s", part, prompt)
+		}
+	}
+	if strings.Contains(prompt, "質問4") || strings.Contains(prompt, "# これまでの要約") {
+		t.Errorf("summary prompt covers too much:\n%s", prompt)
+	}
+	if nodes[2].Summary != "要約1" {
+		t.Fatalf("n3 summary = %q", nodes[2].Summary)
+	}
+	got := history("n5")
+	if len(got) != 6 || !strings.Contains(got[0], "要約1") || got[2] != "user: 質問4" || got[5] != "model: 回答5" {
+		t.Errorf("history with summary = %q", got)
+	}
+
+	// 会話が変わっていなければ要約を作り直しません。
+	a.ensureHistorySummary(ctx, provider, "n5")
+	summaries(1)
+
+	// 会話が伸びると、前回の要約に続きの会話だけを加えて更新します (ローリング要約)。
+	a.withNodesLocked(func() {
+		a.nodes = append(a.nodes, nodes[5])
+	})
+	a.ensureHistorySummary(ctx, provider, "n6")
+	prompt = summaries(2)[1]
+	if !strings.Contains(prompt, "# これまでの要約\n要約1") || !strings.Contains(prompt, "質問4") || strings.Contains(prompt, "質問3") {
+		t.Errorf("rolling summary prompt:\n%s", prompt)
+	}
+	if nodes[3].Summary != "要約2" {
+		t.Fatalf("n4 summary = %q", nodes[3].Summary)
+	}
+
+	// 祖先の回答が変わると要約は無効になり、作り直すまでは要約せずに送ります。
+	a.withNodesLocked(func() {
+		nodes[1].AddVersion("回答2の別バージョン", nil)
+	})
+	got = history("n6")
+	if len(got) != 12 || strings.Contains(strings.Join(got, "\n"), "要約") || got[3] != "model: 回答2の別バージョン" {
+		t.Errorf("history after editing an ancestor = %q", got)
+	}
+	a.ensureHistorySummary(ctx, provider, "n6")
+	prompt = summaries(3)[2]
+	if strings.Contains(prompt, "# これまでの要約") || !strings.Contains(prompt, "質問1") || !strings.Contains(prompt, "回答2の別バージョン") {
+		t.Errorf("summary prompt after editing an ancestor:\n%s", prompt)
+	}
+	if nodes[3].Summary != "要約3" {
+		t.Errorf("n4 summary = %q", nodes[3].Summary)
+	}
+
+	// バージョンを元に戻すと、元の会話に対応する n3 の要約が再び有効になります。
+	if !a.switchNodeVersion(nodes[1], 0) {
+		t.Fatal("switchNodeVersion failed")
+	}
+	if got := history("n5"); len(got) != 6 || !strings.Contains(got[0], "要約1") {
+		t.Errorf("history after switching back = %q", got)
+	}
+	if got := history("n6"); strings.Contains(strings.Join(got, "\n"), "要約3") {
+		t.Errorf("stale summary is used after switching back: %q", got)
+	}
+	a.ensureHistorySummary(ctx, provider, "n6")
+	if prompt = summaries(4)[3]; !strings.Contains(prompt, "# これまでの要約\n要約1") {
+		t.Errorf("summary prompt after switching back:\n%s", prompt)
+	}
+
+	// 送信するリクエストは用意した要約から始まり、要約は予算による削減の対象外です。
+	fyne.DoAndWait(func() {
+		a.dialogCanvas.SetBranchSource("n6")
+		a.chatInput.SetText("質問7")
+		a.sendQuestion()
+	})
+	waitForRequest(t, a)
+	answers := provider.requestsOf(requestAnswer)
+	if len(answers) != 1 {
+		t.Fatalf("got %d answer requests", len(answers))
+	}
+	sent := answers[0].Messages
+	if len(sent) != 7 || !strings.Contains(sent[0].Text, "要約4") || !sent[0].Pinned || !sent[1].Pinned || sent[6].Text != "質問7" {
+		t.Errorf("sent messages = %q", messageTexts(sent))
+	}
+	summaries(4)
+}
+
+func TestHistorySummaryFailureSendsFullHistory(t *testing.T) {
+	useConfig(t, config.Config{HistorySummaryTurns: 1})
+	provider := newFakeProvider("openai", "gpt-x")
+	provider.failWith(requestSummary, errors.New("summary failed"))
+	nodes := newChain(3)
+	a := newTestApp(t, provider, nodes...)
+
+	a.ensureHistorySummary(context.Background(), provider, "n3")
+	if len(provider.requestsOf(requestSummary)) != 1 {
+		t.Fatal("summary was not requested")
+	}
+	if nodes[1].Summary != "" || nodes[1].SummaryHash != "" {
+		t.Errorf("failed summary was stored: %q", nodes[1].Summary)
+	}
+	if got := messageTexts(a.getConversationHistory("n3")); len(got) != 6 || got[0] != "user: 質問1" {
+		t.Errorf("history = %q, want the full conversation", got)
+	}
+}
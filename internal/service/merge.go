@@ -0,0 +1,134 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/google/uuid"
+)
+
+// showSelectionStatus は統合用に選択されているノードの数をステータスラベルに表示します。
+func (a *App) showSelectionStatus(nodeIDs []string) {
+	switch {
+	case len(nodeIDs) == 0:
+		a.statusLabel.SetText("準備完了")
+	case len(nodeIDs) == 1:
+		a.statusLabel.SetText("1件のノードを選択中 (Ctrl+クリックで統合する分岐を追加)")
+	default:
+		a.statusLabel.SetText(fmt.Sprintf("%d件のノードを選択中 (ノードのメニューから統合できます)", len(nodeIDs)))
+	}
+}
+
+// showSynthesizeDialog は選択したノードを統合する指示を入力するダイアログを表示します。
+func (a *App) showSynthesizeDialog() {
+	nodeIDs := a.dialogCanvas.SelectedNodeIDs()
+	if len(nodeIDs) < 2 {
+		dialog.ShowInformation("情報", "統合するノードをCtrl+クリックで2つ以上選択してください。", a.window)
+		return
+	}
+	for _, id := range nodeIDs {
+		if n := a.findNodeData(id); n == nil || !n.IsComplete() {
+			dialog.ShowInformation("情報", "回答が完了していないノードは統合できません。", a.window)
+			return
+		}
+	}
+
+	instructionEntry := widget.NewMultiLineEntry()
+	instructionEntry.Wrapping = fyne.TextWrapWord
+	instructionEntry.SetPlaceHolder("統合の観点や出力の形式 (任意)")
+	instructionEntry.SetMinRowsVisible(4)
+	items := []*widget.FormItem{widget.NewFormItem("指示", instructionEntry)}
+	d := dialog.NewForm(fmt.Sprintf("選択した%d件の分岐を統合", len(nodeIDs)), "統合", "キャンセル", items, func(ok bool) {
+		if ok {
+			a.synthesize(nodeIDs, strings.TrimSpace(instructionEntry.Text))
+		}
+	}, a.window)
+	d.Resize(fyne.NewSize(520, 260))
+	d.Show()
+}
+
+// synthesize は選択したノードの分岐をAIに統合させ、結果をすべての選択ノードを親とする統合ノードとして追加します。
+func (a *App) synthesize(nodeIDs []string, instruction string) {
+	generation, err := a.currentGenerationSettings()
+	if err != nil {
+		dialog.ShowError(err, a.window)
+		return
+	}
+	if a.isRequestRunning() {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return
+	}
+	a.dialogCanvas.ClearSelection()
+	ctx, cancel, ok := a.beginRequest("分岐を統合中...")
+	if !ok {
+		return
+	}
+	question := instruction
+	if question == "" {
+		question = fmt.Sprintf("選択した%d件の分岐を統合してください。", len(nodeIDs))
+	}
+	log.Printf("Synthesizing branches %v", nodeIDs)
+
+	placeholder := &ui.NodeData{
+		ID:             uuid.NewString(),
+		Title:          "統合中...",
+		Question:       question,
+		ParentID:       nodeIDs[0],
+		MergeParentIDs: nodeIDs[1:],
+		Generation:     generation,
+		Status:         ui.NodeStatusPending,
+	}
+	a.addNode(placeholder)
+	provider := a.aiProvider
+	projectID := a.currentProjectID
+
+	go func(ctx context.Context, nodeData *ui.NodeData) {
+		status := "準備完了"
+		defer func() {
+			a.finishRequest(cancel, status)
+		}()
+
+		ctx = ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(nodeData))
+		var resp *ai_client.Response
+		err := fmt.Errorf("AIプロバイダが初期化されていません (APIキー未設定)")
+		if provider != nil {
+			resp, err = a.streamAnswer(ctx, provider, nodeData, a.newMergeRequest(nodeData, generation), projectID)
+		}
+		if ctx.Err() != nil {
+			status = "分岐の統合をキャンセルしました"
+			fyne.Do(func() {
+				a.discardNode(nodeData.ID)
+			})
+			return
+		}
+
+		title := fallbackNodeTitle(question)
+		if err != nil {
+			log.Printf("Failed to synthesize branches: %v", err)
+			status = "分岐の統合に失敗しました"
+		} else {
+			title = a.generateNodeTitle(ctx, provider, question, resp.Text)
+		}
+		fyne.Do(func() {
+			a.withNodesLocked(func() {
+				nodeData.Title = title
+				if err != nil {
+					nodeData.Answer = ""
+					nodeData.MarkFailed(err)
+				} else {
+					nodeData.Answer = resp.Text
+					nodeData.Generation = recordedGeneration(generation, provider, resp)
+					nodeData.MarkComplete()
+				}
+			})
+			a.completeNode(nodeData)
+		})
+	}(ctx, placeholder)
+}
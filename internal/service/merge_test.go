@@ -0,0 +1,54 @@
+package service
+
+import (
+	"AI-Dialogue-Map/internal/ui"
+	"reflect"
+	"testing"
+)
+
+func TestDeletingAnyMergeParentDeletesTheMergeNode(t *testing.T) {
+	// root ─┬─ a ─┐
+	//       └─ b ─┴─ merge ── child
+	newTree := func() []*ui.NodeData {
+		merge := newCompleteNode("merge", "a", "統合してください", "統合した結論")
+		merge.MergeParentIDs = []string{"b"}
+		return []*ui.NodeData{
+			newCompleteNode("root", "", "Q0", "A0"),
+			newCompleteNode("a", "root", "Q1", "A1"),
+			newCompleteNode("b", "root", "Q2", "A2"),
+			merge,
+			newCompleteNode("child", "merge", "Q3", "A3"),
+		}
+	}
+	tests := []struct {
+		name        string
+		deleteID    string
+		wantDeleted []string
+		wantKept    []string
+	}{
+		{name: "primary parent", deleteID: "a", wantDeleted: []string{"a", "merge", "child"}, wantKept: []string{"root", "b"}},
+		{name: "secondary parent", deleteID: "b", wantDeleted: []string{"b", "merge", "child"}, wantKept: []string{"root", "a"}},
+		{name: "merge node", deleteID: "merge", wantDeleted: []string{"merge", "child"}, wantKept: []string{"root", "a", "b"}},
+		{name: "common ancestor", deleteID: "root", wantDeleted: []string{"root", "a", "b", "merge", "child"}, wantKept: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newHeadlessApp(newTree()...)
+			deleted := ui.DescendantIDs(a.nodes, tt.deleteID)
+			if !reflect.DeepEqual(deleted, tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			a.updateAppDataAfterDeletion(deleted)
+			kept := []string{}
+			for _, n := range a.nodes {
+				kept = append(kept, n.ID)
+				if n.IsMerge() {
+					t.Errorf("merge node %s survived the deletion of %s", n.ID, tt.deleteID)
+				}
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scriptedProvider は最後のユーザーメッセージに対応する回答を返すテスト用のプロバイダです。
+// 統合の依頼には mergeAnswer を返します。記録モードの内側のプロバイダとして使用します。
+type scriptedProvider struct {
+	answers     map[string]string
+	mergeAnswer string
+}
+
+func (p *scriptedProvider) Generate(ctx context.Context, req *ai_client.Request) (*ai_client.Response, error) {
+	last := req.Messages[len(req.Messages)-1].Text
+	answer, ok := p.answers[last]
+	if strings.HasPrefix(last, "ここまでの会話から") {
+		answer, ok = p.mergeAnswer, true
+	}
+	if !ok {
+		return nil, errors.New("unexpected question: " + last)
+	}
+	return &ai_client.Response{Text: answer, Model: "scripted-model", FinishReason: ai_client.FinishReasonStop}, nil
+}
+
+func (p *scriptedProvider) GenerateStream(ctx context.Context, req *ai_client.Request, onChunk func(chunk string)) (*ai_client.Response, error) {
+	resp, err := p.Generate(ctx, req)
+	if err == nil && onChunk != nil {
+		onChunk(resp.Text)
+	}
+	return resp, err
+}
+
+func (p *scriptedProvider) ModelInfo() ai_client.ModelInfo {
+	return ai_client.ModelInfo{Provider: "scripted", Model: "scripted-model"}
+}
+
+// useConfig はテストの間だけ config.Cfg を cfg に置き換えます。
+func useConfig(t *testing.T, cfg config.Config) {
+	t.Helper()
+	saved := config.Cfg
+	config.Cfg = cfg
+	t.Cleanup(func() { config.Cfg = saved })
+}
+
+// newHeadlessApp は画面を持たない App を作成します。リクエストの組み立てと回答の生成はウィジェットなしで動作します。
+func newHeadlessApp(nodes ...*ui.NodeData) *App {
+	return &App{nodes: nodes, systemPrompt: "プロジェクトのシステムプロンプト"}
+}
+
+func newCompleteNode(id string, parentID string, question string, answer string) *ui.NodeData {
+	return &ui.NodeData{ID: id, ParentID: parentID, Question: question, Answer: answer, Status: ui.NodeStatusComplete}
+}
+
+// send は handleSend と同じ手順で、parentID への質問をプレースホルダのノードに回答させます。
+func send(t *testing.T, a *App, provider ai_client.Provider, id string, parentID string, question string, gs *ui.GenerationSettings) (*ui.NodeData, *ai_client.Request) {
+	t.Helper()
+	nodeData := &ui.NodeData{ID: id, ParentID: parentID, Question: question, Generation: gs, Status: ui.NodeStatusPending}
+	a.nodes = append(a.nodes, nodeData)
+	request := a.newChatRequest(parentID, parentID, question, nil, nil, gs)
+	sent := *request
+	sent.Messages = append([]ai_client.Message{}, request.Messages...)
+	resp, err := a.streamAnswer(context.Background(), provider, nodeData, request, "")
+	if err != nil {
+		t.Fatalf("streamAnswer(%q): %v", question, err)
+	}
+	if nodeData.Answer != resp.Text {
+		t.Errorf("streamed answer %q does not match response %q", nodeData.Answer, resp.Text)
+	}
+	nodeData.Generation = recordedGeneration(gs, provider, resp)
+	nodeData.MarkComplete()
+	return nodeData, &sent
+}
+
+func messageTexts(messages []ai_client.Message) []string {
+	texts := make([]string, len(messages))
+	for i, m := range messages {
+		texts[i] = string(m.Role) + ": " + m.Text
+	}
+	return texts
+}
+
+func TestNewChatRequest(t *testing.T) {
+	useConfig(t, config.Config{})
+	root := newCompleteNode("root", "", "ルートの質問", "ルートの回答")
+	failed := &ui.NodeData{ID: "failed", ParentID: "root", Question: "失敗した質問", Status: ui.NodeStatusFailed, Error: "API error"}
+	child := newCompleteNode("child", "failed", "子の質問", "子の回答")
+	child.SystemPrompt = "子のシステムプロンプト"
+	a := newHeadlessApp(root, failed, child)
+
+	temperature := float32(0.2)
+	gs := &ui.GenerationSettings{Model: "model-x", Temperature: &temperature}
+	request := a.newChatRequest("child", "child", "新しい質問", []ui.Attachment{
+		{Name: "memo.txt", MIMEType: "text/plain", Data: []byte("メモの内容\n")},
+		{Name: "figure.png", MIMEType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}},
+	}, nil, gs)
+
+	want := []string{
+		"user: ルートの質問",
+		"model: ルートの回答",
+		"user: 子の質問",
+		"model: 子の回答",
+		"user: 新しい質問\n\n---\n添付ファイル: memo.txt\n```\nメモの内容\n```",
+	}
+	if got := messageTexts(request.Messages); !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %q, want %q (failed nodes are skipped)", got, want)
+	}
+	if images := request.Messages[len(request.Messages)-1].Images; len(images) != 1 || images[0].MIMEType != "image/png" {
+		t.Errorf("images = %+v, want figure.png", images)
+	}
+	if request.System != "子のシステムプロンプト" {
+		t.Errorf("System = %q, want the nearest node's prompt", request.System)
+	}
+	if request.Model != "model-x" || request.Params.Temperature == nil || *request.Params.Temperature != 0.2 {
+		t.Errorf("model/params = %q %+v", request.Model, request.Params)
+	}
+
+	// 祖先にシステムプロンプトがない場合はプロジェクト既定を使用します。
+	if request := a.newChatRequest("root", "root", "q", nil, nil, nil); request.System != "プロジェクトのシステムプロンプト" {
+		t.Errorf("System = %q, want the project prompt", request.System)
+	}
+}
+
+func TestSynthesisMessage(t *testing.T) {
+	a1 := newCompleteNode("a1", "root", "Aの質問", "Aの回答")
+	a1.Attachments = []ui.Attachment{{Name: "a.png", MIMEType: "image/png", Data: []byte{1}}}
+	b1 := newCompleteNode("b1", "root", "Bの質問", "Bの回答")
+	b2 := newCompleteNode("b2", "b1", "Bの続き", "Bの続きの回答")
+
+	msg := synthesisMessage("違いを整理して", [][]*ui.NodeData{{a1}, {b1, b2}})
+	if msg.Role != ai_client.RoleUser {
+		t.Errorf("Role = %q", msg.Role)
+	}
+	order := []string{"2個の分岐", "# 分岐1", "Aの質問", "Aの回答", "# 分岐2", "Bの質問", "Bの回答", "Bの続き", "Bの続きの回答", "# 統合の指示\n違いを整理して"}
+	rest := msg.Text
+	for _, part := range order {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			t.Fatalf("synthesis message is missing %q in order:\n%s", part, msg.Text)
+		}
+		rest = rest[i+len(part):]
+	}
+	if len(msg.Images) != 1 {
+		t.Errorf("got %d images, want the branch attachment", len(msg.Images))
+	}
+}
+
+// TestSendRegenerateAndMergeWithReplay は送信・統合の応答を記録し、記録から再生成と統合を再現します。
+func TestSendRegenerateAndMergeWithReplay(t *testing.T) {
+	useConfig(t, config.Config{})
+	fixtures := filepath.Join(t.TempDir(), "replay.yaml")
+	gs := &ui.GenerationSettings{Model: "scripted-model"}
+
+	// 記録: 実際のプロバイダの代わりに scriptedProvider の応答をフィクスチャに記録します。
+	recorder, err := ai_client.NewRecordingProvider(&scriptedProvider{
+		answers: map[string]string{
+			"分岐Aの質問": "分岐Aの回答です。",
+			"分岐Bの質問": "分岐Bの回答です。",
+		},
+		mergeAnswer: "AとBを統合した結論です。",
+	}, fixtures)
+	if err != nil {
+		t.Fatalf("NewRecordingProvider: %v", err)
+	}
+	a := newHeadlessApp(newCompleteNode("root", "", "ルートの質問", "ルートの回答"))
+	branchA, sentA := send(t, a, recorder, "a1", "root", "分岐Aの質問", gs)
+	branchB, _ := send(t, a, recorder, "b1", "root", "分岐Bの質問", gs)
+	if branchA.Answer != "分岐Aの回答です。" || branchB.Answer != "分岐Bの回答です。" {
+		t.Fatalf("answers = %q, %q", branchA.Answer, branchB.Answer)
+	}
+	if got := messageTexts(sentA.Messages); len(got) != 3 || got[0] != "user: ルートの質問" || got[2] != "user: 分岐Aの質問" {
+		t.Errorf("send request = %q", got)
+	}
+
+	merge := &ui.NodeData{ID: "m", ParentID: "a1", MergeParentIDs: []string{"b1"}, Question: "結論をまとめて", Status: ui.NodeStatusPending}
+	a.nodes = append(a.nodes, merge)
+	mergeRequest := a.newMergeRequest(merge, gs)
+	wantMerge := synthesisMessage("結論をまとめて", [][]*ui.NodeData{{branchA}, {branchB}})
+	if got := messageTexts(mergeRequest.Messages); len(got) != 3 || got[0] != "user: ルートの質問" || got[2] != "user: "+wantMerge.Text {
+		t.Errorf("merge request = %q", got)
+	}
+	resp, err := a.streamAnswer(context.Background(), recorder, merge, mergeRequest, "")
+	if err != nil {
+		t.Fatalf("streamAnswer(merge): %v", err)
+	}
+	merge.Answer = resp.Text
+	merge.MarkComplete()
+
+	// 統合ノードの子には、共通の祖先・統合の依頼・統合の回答が文脈として送られます。
+	history := messageTexts(a.getConversationHistory("m"))
+	if len(history) != 4 || history[2] != "user: "+wantMerge.Text || history[3] != "model: AとBを統合した結論です。" {
+		t.Errorf("history below merge = %q", history)
+	}
+
+	// 再生: ネットワークなしに記録した応答だけで再生成と統合を再現します。
+	replay, err := ai_client.NewReplayClient(fixtures)
+	if err != nil {
+		t.Fatalf("NewReplayClient: %v", err)
+	}
+
+	// 再生成は元の送信と同じリクエストを組み立てるため、記録と完全に一致します。
+	regenerateRequest := a.newChatRequest(branchA.ParentID, branchA.ID, branchA.Question, branchA.Attachments, branchA.References, gs)
+	if !reflect.DeepEqual(regenerateRequest, sentA) {
+		t.Errorf("regenerate request differs from the original send:\n%+v\n%+v", regenerateRequest, sentA)
+	}
+	resp, err = a.streamAnswer(context.Background(), replay, branchA, regenerateRequest, "")
+	if err != nil {
+		t.Fatalf("streamAnswer(regenerate): %v", err)
+	}
+	branchA.AddVersion(resp.Text, recordedGeneration(gs, replay, resp))
+	if branchA.VersionCount() != 2 || branchA.Answer != "分岐Aの回答です。" {
+		t.Errorf("after regenerate: %d versions, answer %q", branchA.VersionCount(), branchA.Answer)
+	}
+	if branchA.Generation.Provider != ai_client.ProviderReplay || branchA.Generation.Model != "scripted-model" {
+		t.Errorf("recorded generation = %+v", branchA.Generation)
+	}
+
+	merge.Answer = ""
+	resp, err = a.streamAnswer(context.Background(), replay, merge, a.newMergeRequest(merge, gs), "")
+	if err != nil {
+		t.Fatalf("streamAnswer(merge replay): %v", err)
+	}
+	if merge.Answer != "AとBを統合した結論です。" || resp.Text != merge.Answer {
+		t.Errorf("replayed merge answer = %q", merge.Answer)
+	}
+
+	// 記録にない質問はプロバイダのエラーと同じように失敗します。
+	unknown := &ui.NodeData{ID: "x", ParentID: "root", Question: "記録にない質問"}
+	if _, err := a.streamAnswer(context.Background(), replay, unknown, a.newChatRequest("root", "root", unknown.Question, nil, nil, gs), ""); !errors.Is(err, ai_client.ErrNoFixture) {
+		t.Errorf("err = %v, want ErrNoFixture", err)
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// resolveSystemPrompt は指定ノードから祖先をたどり、最も近いノードのシステムプロンプトを返します。
+// どのノードにも設定がない場合はプロジェクト既定のシステムプロンプトを返します。
+func (a *App) resolveSystemPrompt(nodeID string) string {
+	a.nodesMutex.RLock()
+	defer a.nodesMutex.RUnlock()
+
+	nodeDataMap := make(map[string]*ui.NodeData)
+	for _, n := range a.nodes {
+		nodeDataMap[n.ID] = n
+	}
+	for currentNodeID := nodeID; currentNodeID != ""; {
+		currentNodeData, found := nodeDataMap[currentNodeID]
+		if !found {
+			break
+		}
+		if currentNodeData.SystemPrompt != "" {
+			return currentNodeData.SystemPrompt
+		}
+		currentNodeID = currentNodeData.ParentID
+	}
+	return a.systemPrompt
+}
+
+// getConversationHistory は指定ノードからルートまでの祖先をたどり、
+// 古い順に並べたユーザー/モデルの会話ターンとして返します。
+// 回答の生成に失敗したノードや生成中のノードは回答がないため含めません。
+// 古い祖先の有効な要約がある場合は、その部分を要約に置き換えます。
+func (a *App) getConversationHistory(targetNodeID string) []ai_client.Message {
+	a.nodesMutex.RLock()
+	defer a.nodesMutex.RUnlock()
+
+	return a.historyFromPathLocked(a.conversationPathLocked(targetNodeID))
+}
+
+// historyFromPathLocked は古い順に並んだノードを会話ターンに変換します。
+// 古い祖先の有効な要約がある場合は、その部分を要約に置き換えます。nodesMutex を保持した状態で呼び出します。
+func (a *App) historyFromPathLocked(path []*ui.NodeData) []ai_client.Message {
+	history := make([]ai_client.Message, 0, len(path)*2)
+	if cutoff := summaryCutoff(len(path)); cutoff >= 0 && summaryIsValid(path[:cutoff+1]) {
+		history = append(history, summaryMessages(path[cutoff].Summary)...)
+		path = path[cutoff+1:]
+	}
+	for _, n := range path {
+		history = append(history,
+			a.userMessageLocked(n),
+			ai_client.Message{Role: ai_client.RoleModel, Text: n.Answer},
+		)
+	}
+	return history
+}
+
+// userMessageLocked はノードの質問を会話履歴のユーザーメッセージに変換します。
+// 統合ノードの場合は、統合した分岐の内容を含む統合の依頼になります。nodesMutex を保持した状態で呼び出します。
+func (a *App) userMessageLocked(n *ui.NodeData) ai_client.Message {
+	if n.IsMerge() {
+		_, branches := mergeBranches(a.nodeDataMapLocked(), n)
+		return synthesisMessage(n.Question, branches)
+	}
+	return questionMessage(n.Question, n.Attachments)
+}
+
+// nodeDataMapLocked はIDからNodeDataを引くマップを作成します。nodesMutex を保持した状態で呼び出します。
+func (a *App) nodeDataMapLocked() map[string]*ui.NodeData {
+	nodeDataMap := make(map[string]*ui.NodeData, len(a.nodes))
+	for _, n := range a.nodes {
+		nodeDataMap[n.ID] = n
+	}
+	return nodeDataMap
+}
+
+// conversationPathLocked は指定ノードからルートまでの祖先のうち、回答が完了したノードを古い順に返します。
+// nodesMutex を保持した状態で呼び出します。
+func (a *App) conversationPathLocked(targetNodeID string) []*ui.NodeData {
+	return conversationPath(a.nodeDataMapLocked(), targetNodeID)
+}
+
+// conversationPath は conversationPathLocked の本体です。
+// 統合ノードより前は、統合したすべての分岐に共通する祖先だけをたどります (分岐ごとの会話は統合ノードの質問に含めます)。
+func conversationPath(nodeDataMap map[string]*ui.NodeData, targetNodeID string) []*ui.NodeData {
+	var path, base []*ui.NodeData
+	currentNodeID := targetNodeID
+	for currentNodeID != "" {
+		currentNodeData, found := nodeDataMap[currentNodeID]
+		if !found {
+			log.Printf("getConversationHistory: NodeData not found for ID %s", currentNodeID)
+			break
+		}
+		if currentNodeData.IsComplete() {
+			path = append(path, currentNodeData)
+		}
+		if currentNodeData.IsMerge() {
+			base, _ = mergeBranches(nodeDataMap, currentNodeData)
+			break
+		}
+		currentNodeID = currentNodeData.ParentID
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return append(base, path...)
+}
+
+// newChatRequest は parentID までの会話履歴に新しい質問と添付ファイルを加えたリクエストを作成します。
+// references に関連ノードのIDがある場合は、それらを番号付きの出典として質問の前に置きます。
+// システムプロンプトは systemNodeID から解決します。トークン予算に合わせた履歴の削減は送信時に trimToBudget で行います。
+func (a *App) newChatRequest(parentID string, systemNodeID string, question string, attachments []ui.Attachment, references []string, gs *ui.GenerationSettings) *ai_client.Request {
+	var messages []ai_client.Message
+	if parentID != "" {
+		messages = a.getConversationHistory(parentID)
+	}
+	message := questionMessage(question, attachments)
+	if len(references) > 0 {
+		message.Text = a.referenceContext(references) + message.Text
+	}
+	messages = append(messages, message)
+	request := &ai_client.Request{
+		Params:   toGenerationParams(gs),
+		System:   a.resolveSystemPrompt(systemNodeID),
+		Messages: messages,
+	}
+	if gs != nil {
+		request.Model = gs.Model
+	}
+	return request
+}
+
+// trimToBudget はリクエストが設定のトークン予算を超える場合に、古い祖先の会話から取り除きます。
+// トークン数をAPIで数えることがあるため、UIスレッド以外から呼び出します。
+func trimToBudget(ctx context.Context, provider ai_client.Provider, request *ai_client.Request) int {
+	dropped := ai_client.TrimToBudget(ctx, provider, request, config.Cfg.ContextTokenBudget)
+	if dropped > 0 {
+		log.Printf("Context token budget (%d) exceeded, dropped %d oldest messages", config.Cfg.ContextTokenBudget, dropped)
+	}
+	return dropped
+}
+
+// mergeBranches は統合ノードのすべての親までの経路から、共通の祖先 (古い順) と分岐ごとの残りの経路を返します。
+func mergeBranches(nodeDataMap map[string]*ui.NodeData, merge *ui.NodeData) (base []*ui.NodeData, branches [][]*ui.NodeData) {
+	var paths [][]*ui.NodeData
+	for _, id := range merge.ParentIDs() {
+		paths = append(paths, conversationPath(nodeDataMap, id))
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	common := len(paths[0])
+	for _, p := range paths[1:] {
+		n := 0
+		for n < common && n < len(p) && p[n] == paths[0][n] {
+			n++
+		}
+		common = n
+	}
+	for _, p := range paths {
+		branches = append(branches, p[common:])
+	}
+	return paths[0][:common:common], branches
+}
+
+// synthesisMessage は分岐ごとの会話を並べ、1つの結論に統合するよう依頼するユーザーメッセージを作成します。
+func synthesisMessage(instruction string, branches [][]*ui.NodeData) ai_client.Message {
+	msg := ai_client.Message{Role: ai_client.RoleUser}
+	var b strings.Builder
+	fmt.Fprintf(&b, "ここまでの会話から、次の%d個の分岐で異なる方向に検討を進めました。", len(branches))
+	b.WriteString("各分岐の内容を比較し、共通点と相違点を踏まえて1つの結論に統合してください。分岐の間で矛盾がある場合は、どちらを採るべきかを理由とともに示してください。\n\n")
+	for i, branch := range branches {
+		fmt.Fprintf(&b, "# 分岐%d\n", i+1)
+		for _, n := range branch {
+			question := questionMessage(n.Question, n.Attachments)
+			msg.Images = append(msg.Images, question.Images...)
+			fmt.Fprintf(&b, "## 質問\n%s\n\n## 回答\n%s\n\n", question.Text, n.Answer)
+		}
+	}
+	b.WriteString("# 統合の指示\n")
+	b.WriteString(instruction)
+	msg.Text = b.String()
+	return msg
+}
+
+// newMergeRequest は統合ノードの回答を生成するリクエストを作成します。
+// 分岐に共通する祖先を会話履歴とし、分岐ごとの会話を含む統合の依頼を最後のメッセージにします。
+func (a *App) newMergeRequest(nodeData *ui.NodeData, gs *ui.GenerationSettings) *ai_client.Request {
+	a.nodesMutex.RLock()
+	base, branches := mergeBranches(a.nodeDataMapLocked(), nodeData)
+	messages := append(a.historyFromPathLocked(base), synthesisMessage(nodeData.Question, branches))
+	a.nodesMutex.RUnlock()
+
+	request := &ai_client.Request{
+		Params:   toGenerationParams(gs),
+		System:   a.resolveSystemPrompt(nodeData.ID),
+		Messages: messages,
+	}
+	if gs != nil {
+		request.Model = gs.Model
+	}
+	return request
+}
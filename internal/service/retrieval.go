@@ -0,0 +1,86 @@
+package service
+
+import (
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/search"
+	"AI-Dialogue-Map/internal/utils"
+	"fmt"
+	"log"
+	"strings"
+
+	"fyne.io/fyne/v2/widget"
+)
+
+// referenceAnswerMaxLength は参照として送る関連ノードの回答の最大文字数です。
+const referenceAnswerMaxLength = 2000
+
+// newRetrievalCheck は入力バーの「関連ノードを参照」チェックボックスを作成します。
+func (a *App) newRetrievalCheck() *widget.Check {
+	check := widget.NewCheck("関連ノードを参照", func(bool) {
+		a.scheduleTokenCount()
+	})
+	check.SetChecked(config.Cfg.RetrievalEnabled)
+	return check
+}
+
+// retrieveReferences は「関連ノードを参照」が有効な場合に、question に関連するノードをプロジェクト全体から探してIDを返します。
+// parentID までの祖先は会話履歴として送るため除きます。UIスレッドから呼び出します。
+func (a *App) retrieveReferences(parentID string, question string) []string {
+	if a.retrievalCheck == nil || !a.retrievalCheck.Checked {
+		return nil
+	}
+	return a.searchReferences(parentID, question, config.Cfg.RetrievalTopK)
+}
+
+// searchReferences は祖先以外の回答済みのノードをBM25で検索し、関連度の高い順に最大 limit 件のIDを返します。
+func (a *App) searchReferences(parentID string, question string, limit int) []string {
+	if limit <= 0 || strings.TrimSpace(question) == "" {
+		return nil
+	}
+	a.nodesMutex.RLock()
+	defer a.nodesMutex.RUnlock()
+	ancestors := make(map[string]bool)
+	if parentID != "" {
+		for _, n := range a.conversationPathLocked(parentID) {
+			ancestors[n.ID] = true
+		}
+	}
+	docs := make([]search.Document, 0, len(a.nodes))
+	for _, n := range a.nodes {
+		if !n.IsComplete() || ancestors[n.ID] {
+			continue
+		}
+		docs = append(docs, search.Document{ID: n.ID, Text: n.Title + "\n" + n.Question + "\n" + n.Answer})
+	}
+	results := search.NewIndex(docs).Search(question, limit)
+	ids := make([]string, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	if len(ids) > 0 {
+		log.Printf("Retrieved %d related nodes for the question: %v", len(ids), ids)
+	}
+	return ids
+}
+
+// referenceContext は関連ノードを番号付きの出典として質問の前に置くテキストを返します。
+// 番号は references の順序 (1始まり) です。削除されたノードは番号を詰めずに飛ばすため、回答中の出典番号は変わりません。参照するノードがない場合は空文字列です。
+func (a *App) referenceContext(references []string) string {
+	a.nodesMutex.RLock()
+	defer a.nodesMutex.RUnlock()
+	nodes := a.nodeDataMapLocked()
+	var b strings.Builder
+	for i, id := range references {
+		n, ok := nodes[id]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "## [%d] %s\n質問: %s\n回答: %s\n\n", i+1, n.Title, n.Question, utils.TruncateText(n.Answer, referenceAnswerMaxLength))
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "以下はこのプロジェクトの別の分岐にある、質問に関連する可能性のある会話です。" +
+		"回答に利用した場合は、該当する箇所に [1] のような番号で出典を示してください。\n\n" +
+		b.String() + "---\n\n# 質問\n"
+}
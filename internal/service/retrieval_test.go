@@ -0,0 +1,42 @@
+package service
+
+import (
+	"AI-Dialogue-Map/internal/ui"
+	"reflect"
+	"testing"
+)
+
+func TestSearchReferencesExcludesAncestors(t *testing.T) {
+	pending := &ui.NodeData{ID: "pending", ParentID: "root", Question: "goroutine の質問", Status: ui.NodeStatusPending}
+	a := newHeadlessApp(
+		newCompleteNode("root", "", "goroutine とは", "goroutine は軽量なスレッドです。"),
+		newCompleteNode("parent", "root", "goroutine の channel", "channel で goroutine 間を通信します。"),
+		newCompleteNode("sibling", "root", "goroutine のリーク", "終了しない goroutine はリークします。"),
+		newCompleteNode("child", "parent", "goroutine の数", "goroutine はいくつでも起動できます。"),
+		newCompleteNode("other", "root", "Python", "asyncio を使います。"),
+		pending,
+	)
+
+	tests := []struct {
+		name     string
+		parentID string
+		question string
+		limit    int
+		want     []string
+	}{
+		// parent までの祖先 (root, parent) は会話履歴として送られるため除きます。子孫と別の分岐は対象です。
+		{name: "ancestors are excluded", parentID: "parent", question: "goroutine のリーク", limit: 10, want: []string{"sibling", "child"}},
+		{name: "new root question searches every node", parentID: "", question: "asyncio", limit: 10, want: []string{"other"}},
+		{name: "limit", parentID: "parent", question: "goroutine のリーク", limit: 1, want: []string{"sibling"}},
+		{name: "empty question", parentID: "parent", question: "  ", limit: 10, want: nil},
+		{name: "zero limit", parentID: "parent", question: "goroutine", limit: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := a.searchReferences(tt.parentID, tt.question, tt.limit)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("searchReferences = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
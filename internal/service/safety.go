@@ -0,0 +1,52 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/ui"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// generationError は安全性フィルタなどで回答がブロックされたことを表すエラーを、ノードに表示する理由付きのメッセージに変換します。
+// それ以外のエラーはそのまま返します。
+func generationError(err error) error {
+	var blocked *ai_client.BlockedError
+	if !errors.As(err, &blocked) {
+		return err
+	}
+	var message string
+	switch blocked.Reason {
+	case ai_client.FinishReasonSafety:
+		message = "安全性フィルタにより回答がブロックされました"
+	case ai_client.FinishReasonPrompt:
+		message = "安全性フィルタにより質問がブロックされました"
+	case ai_client.FinishReasonRecitation:
+		message = "著作物の引用と判定されたため回答がブロックされました"
+	case ai_client.FinishReasonMaxTokens:
+		message = "回答を出力する前に最大出力トークン数に達しました"
+	default:
+		message = fmt.Sprintf("回答が得られないまま生成が終了しました (理由: %s)", blocked.Reason)
+	}
+	var categories []string
+	for _, r := range blocked.SafetyRatings {
+		if r.Blocked {
+			categories = append(categories, r.Category+": "+r.Probability)
+		}
+	}
+	if len(categories) > 0 {
+		message += " (" + strings.Join(categories, ", ") + ")"
+	}
+	return errors.New(message)
+}
+
+// notableSafetyRatings は有害性が medium 以上と評価された安全性評価を、ノードに記録する形式に変換します。
+func notableSafetyRatings(ratings []ai_client.SafetyRating) []ui.SafetyRating {
+	var notable []ui.SafetyRating
+	for _, r := range ratings {
+		if r.Blocked || r.Probability == "medium" || r.Probability == "high" {
+			notable = append(notable, ui.SafetyRating{Category: r.Category, Probability: r.Probability})
+		}
+	}
+	return notable
+}
@@ -1,11 +1,13 @@
 package service
 
 import (
-	ai_client "AI-Dialogue-Map/internal/ai" // Importing ai package for GeminiClient
+	ai_client "AI-Dialogue-Map/internal/ai" // Importing ai package for Provider
 	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/search"
 	"AI-Dialogue-Map/internal/ui"
 	"AI-Dialogue-Map/internal/utils"
 	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -20,6 +22,7 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/google/uuid"
 	"gopkg.in/yaml.v3"
@@ -41,25 +44,60 @@ const (
 
 // TreeData はプロジェクト全体のデータを保持します。
 type TreeData struct {
-	Nodes       []*ui.NodeData `yaml:"nodes"` // NodeData is defined in node_widget.go
-	ProjectName string         `yaml:"project_name"`
+	Nodes        []*ui.NodeData         `yaml:"nodes"` // NodeData is defined in node_widget.go
+	ProjectName  string                 `yaml:"project_name"`
+	SystemPrompt string                 `yaml:"system_prompt,omitempty"` // プロジェクト既定のシステムプロンプト
+	Generation   *ui.GenerationSettings `yaml:"generation,omitempty"`    // 入力バーで最後に使用したモデルと生成パラメータ
+	Usage        []ui.ModelUsage        `yaml:"usage,omitempty"`         // プロジェクト全体のモデルごとのトークン使用量 (削除したノードの分も含む)
 }
 
 type App struct {
 	fyneApp fyne.App
 	window  fyne.Window
 
-	geminiClient *ai_client.GeminiClient
-	dialogCanvas *ui.DialogCanvas
-	chatInput    *widget.Entry
-	sendButton   *widget.Button
-	statusLabel  *widget.Label
+	aiProvider      ai_client.Provider
+	fanOutProviders []ai_client.Provider // 比較送信で同時に問い合わせるプロバイダ
+	dialogCanvas    *ui.DialogCanvas
+	chatInput       *ui.ChatEntry
+	sendButton      *widget.Button
+	cancelButton    *widget.Button
+	statusLabel     *widget.Label
+	fanOutCheck     *widget.Check
+	noCacheCheck    *widget.Check
+	retrievalCheck  *widget.Check // 関連ノードを検索して質問の文脈に加えるかどうか
+	tokenLabel      *widget.Label
+
+	attachButton  *widget.Button
+	attachmentBar *fyne.Container // 次の質問に添付するファイルのチップ
+
+	pendingAttachments []ui.Attachment // 次の質問に添付するファイル
+	tokenCountTimer    *time.Timer     // 入力中のトークン数の再計算を遅延させるタイマー
+	tokenCountSeq      int             // 古いトークン数の結果を破棄するための通し番号
+
+	modelSelect      *widget.Select
+	temperatureEntry *widget.Entry
+	topPEntry        *widget.Entry
+	topKEntry        *widget.Entry
+	maxTokensEntry   *widget.Entry
+
+	requestMutex  sync.Mutex // protects cancelRequest
+	cancelRequest context.CancelFunc
 
 	nodesMutex         sync.RWMutex // protects nodes
 	nodes              []*ui.NodeData
 	uiUpdateChan       chan *ui.NodeData
 	currentProjectID   string
 	currentProjectName string
+	systemPrompt       string          // プロジェクト既定のシステムプロンプト
+	projectUsage       []ui.ModelUsage // プロジェクト全体のトークン使用量
+
+	embeddingProvider ai_client.Provider     // 類似ノード検索の埋め込みに使用するプロバイダ (未対応の場合はnil)
+	embeddingMutex    sync.Mutex             // protects embeddingIndex
+	embeddingIndex    *search.EmbeddingIndex // 全プロジェクト共通の埋め込みインデックス (初回使用時に読み込み)
+
+	embeddingQueueMutex sync.Mutex                   // protects embeddingPending, embeddingWorkers
+	embeddingPending    map[string]*embeddingRefresh // プロジェクトIDごとの未反映の最新スナップショット
+	embeddingWorkers    map[string]bool              // 反映用のワーカーが動作中のプロジェクトID
 }
 
 func NewMainApp() *App {
@@ -73,30 +111,37 @@ func NewMainApp() *App {
 	window := fyneAppInstance.NewWindow("AI対話ツリー化アプリケーション")
 	window.Resize(fyne.NewSize(1200, 800))
 
-	var gemini *ai_client.GeminiClient
-	if config.Cfg.GeminiAPIKey != "" {
-		gemini, err = ai_client.NewGeminiClient(config.Cfg.GeminiAPIKey)
-		if err != nil {
-			log.Printf("Geminiクライアントの初期化に失敗しました: %v", err)
-		} else {
-			log.Println("Geminiクライアントが正常に初期化されました。")
-		}
+	provider, err := ai_client.NewProvider(config.Cfg)
+	if err != nil {
+		log.Printf("AIプロバイダの初期化に失敗しました: %v", err)
+	} else {
+		log.Printf("AIプロバイダが正常に初期化されました: %s", provider.ModelInfo())
 	}
 
 	ma := &App{
-		fyneApp:      fyneAppInstance,
-		window:       window,
-		geminiClient: gemini,
-		nodes:        make([]*ui.NodeData, 0),
-		uiUpdateChan: make(chan *ui.NodeData, 10),
+		fyneApp:           fyneAppInstance,
+		window:            window,
+		aiProvider:        provider,
+		fanOutProviders:   newFanOutProviders(config.Cfg),
+		embeddingProvider: newEmbeddingProvider(config.Cfg, provider),
+		nodes:             make([]*ui.NodeData, 0),
+		uiUpdateChan:      make(chan *ui.NodeData, 10),
 	}
 	ma.updateWindowTitle()
 
 	ma.dialogCanvas = ui.NewDialogCanvas(fyneAppInstance, ma.requestNodeDeletion)
-	ma.chatInput = widget.NewMultiLineEntry()
+	ma.dialogCanvas.SetNodeMenuProvider(ma.nodeMenuItems)
+	ma.dialogCanvas.SetOnNodeChanged(ma.handleNodeChanged)
+	ma.dialogCanvas.SetOnVersionSwitch(ma.switchNodeVersion)
+	ma.dialogCanvas.SetOnNodeRetry(func(data *ui.NodeData) { ma.regenerateNode(data.ID) })
+	ma.dialogCanvas.SetOnSuggestionSelected(ma.handleSuggestionSelected)
+	ma.dialogCanvas.SetOnSelectionChanged(ma.showSelectionStatus)
+	ma.chatInput = ui.NewChatEntry()
 	ma.chatInput.SetPlaceHolder("AIへの質問を入力してください...")
-	ma.chatInput.Wrapping = fyne.TextWrapWord
 	ma.chatInput.SetMinRowsVisible(3)
+	ma.chatInput.OnEscape = ma.handleCancel
+	ma.chatInput.OnChanged = func(string) { ma.scheduleTokenCount() }
+	ma.dialogCanvas.SetOnBranchSourceChanged(func(string) { ma.scheduleTokenCount() })
 
 	ma.window.Canvas().AddShortcut(&desktop.CustomShortcut{
 		KeyName:  fyne.KeyReturn,
@@ -121,12 +166,31 @@ func NewMainApp() *App {
 		}
 	})
 
+	ma.window.Canvas().SetOnTypedKey(func(key *fyne.KeyEvent) {
+		if key.Name == fyne.KeyEscape {
+			log.Println("Esc key activated for cancel")
+			ma.handleCancel()
+		}
+	})
+
 	ma.sendButton = widget.NewButton("送信", ma.handleSend)
+	ma.cancelButton = widget.NewButtonWithIcon("キャンセル", theme.CancelIcon(), ma.handleCancel)
+	ma.cancelButton.Disable()
+	ma.fanOutCheck = ma.newFanOutCheck()
+	ma.noCacheCheck = widget.NewCheck("キャッシュを使わない", nil)
+	if !config.Cfg.ResponseCache {
+		ma.noCacheCheck.Disable()
+	}
+	ma.retrievalCheck = ma.newRetrievalCheck()
 	ma.statusLabel = widget.NewLabel("準備完了 (プロジェクトなし)")
 	ma.statusLabel.Alignment = fyne.TextAlignCenter
+	ma.tokenLabel = ma.newTokenLabel()
+	ma.attachButton = ma.newAttachButton()
+	ma.attachmentBar = container.NewVBox()
 
-	inputArea := container.NewBorder(nil, nil, nil, ma.sendButton, ma.chatInput)
-	bottomBar := container.NewVBox(inputArea, ma.statusLabel)
+	inputArea := container.NewBorder(ma.attachmentBar, nil, nil, container.NewVBox(ma.sendButton, ma.cancelButton, ma.fanOutCheck, ma.noCacheCheck, ma.retrievalCheck, ma.attachButton), ma.chatInput)
+	statusBar := container.NewBorder(nil, nil, nil, ma.tokenLabel, ma.statusLabel)
+	bottomBar := container.NewVBox(ma.newGenerationSettingsBar(), inputArea, statusBar)
 
 	split := container.NewVSplit(ma.dialogCanvas, bottomBar)
 	split.Offset = 0.85
@@ -156,7 +220,13 @@ func (a *App) createMenu() {
 	openProjectItem := fyne.NewMenuItem("プロジェクトを開く...", a.openProjectDialog)
 	saveItem := fyne.NewMenuItem("プロジェクトを保存", a.saveCurrentProject)
 	exitItem := fyne.NewMenuItem("終了", func() { a.fyneApp.Quit() })
-	fileMenu := fyne.NewMenu("ファイル", newProjectItem, openProjectItem, saveItem, fyne.NewMenuItemSeparator(), exitItem)
+	usageReportItem := fyne.NewMenuItem("使用量レポート...", a.showUsageReport)
+	findSimilarItem := fyne.NewMenuItem("類似ノードを検索...", func() { a.showFindSimilarDialog("", "") })
+	rebuildIndexItem := fyne.NewMenuItem("埋め込みインデックスを更新", a.rebuildEmbeddingIndex)
+	fileMenu := fyne.NewMenu("ファイル", newProjectItem, openProjectItem, saveItem, fyne.NewMenuItemSeparator(), findSimilarItem, rebuildIndexItem, fyne.NewMenuItemSeparator(), usageReportItem, fyne.NewMenuItemSeparator(), exitItem)
+
+	systemPromptItem := fyne.NewMenuItem("プロジェクトのシステムプロンプト...", a.editProjectSystemPrompt)
+	settingsMenu := fyne.NewMenu("設定", systemPromptItem)
 
 	branchSourceItem := fyne.NewMenuItem("選択中分岐元表示", func() {
 		log.Printf("現在選択中の分岐元ノードID: %s", a.dialogCanvas.GetBranchSource())
@@ -165,49 +235,101 @@ func (a *App) createMenu() {
 	})
 	debugMenu := fyne.NewMenu("デバッグ", branchSourceItem)
 
-	mainMenu := fyne.NewMainMenu(fileMenu, debugMenu)
+	mainMenu := fyne.NewMainMenu(fileMenu, settingsMenu, debugMenu)
 	a.window.SetMainMenu(mainMenu)
 }
 
-func (a *App) getConversationHistory(targetNodeID string) string {
-	a.nodesMutex.RLock()
-	defer a.nodesMutex.RUnlock()
-
-	var historyParts []string
-	currentNodeID := targetNodeID
-
-	nodeDataMap := make(map[string]*ui.NodeData)
-	for _, n := range a.nodes {
-		nodeDataMap[n.ID] = n
+// nodeMenuItems はノードの「…」ボタンで表示するメニュー項目を返します。
+func (a *App) nodeMenuItems(data *ui.NodeData) []*fyne.MenuItem {
+	items := []*fyne.MenuItem{
+		fyne.NewMenuItem("回答を再生成", func() { a.regenerateNode(data.ID) }),
+	}
+	if selected := a.dialogCanvas.SelectedNodeIDs(); len(selected) >= 2 {
+		items = append(items, fyne.NewMenuItem(fmt.Sprintf("選択した%d件の分岐を統合...", len(selected)), a.showSynthesizeDialog))
 	}
+	if data.IsComplete() {
+		items = append(items,
+			fyne.NewMenuItem("フォローアップ質問を提案", func() { a.suggestFollowUps(data.ID) }),
+			fyne.NewMenuItem("このノードから探索...", func() { a.showExploreDialog(data.ID) }),
+			fyne.NewMenuItem("類似ノードを検索...", func() { a.showFindSimilarDialog(embeddingText(data), data.ID) }),
+		)
+	}
+	if a.dialogCanvas.HasSuggestions(data.ID) {
+		items = append(items, fyne.NewMenuItem("提案を消去", func() { a.dialogCanvas.ClearSuggestions(data.ID) }))
+	}
+	return append(items, fyne.NewMenuItem("システムプロンプト (このノード以下)...", func() { a.editNodeSystemPrompt(data.ID) }))
+}
 
-	for currentNodeID != "" {
-		currentNodeData, found := nodeDataMap[currentNodeID]
-		if !found {
-			log.Printf("getConversationHistory: NodeData not found for ID %s", currentNodeID)
-			break
+// editProjectSystemPrompt はプロジェクト既定のシステムプロンプトを編集するダイアログを表示します。
+func (a *App) editProjectSystemPrompt() {
+	a.showSystemPromptDialog("プロジェクトのシステムプロンプト", a.systemPrompt, func(prompt string) {
+		a.systemPrompt = prompt
+		log.Printf("Project system prompt updated (%d chars)", len([]rune(prompt)))
+		if a.currentProjectID != "" {
+			a.saveCurrentProject()
 		}
-		historyParts = append(historyParts, fmt.Sprintf("AI: %s\n", currentNodeData.Answer))
-		historyParts = append(historyParts, fmt.Sprintf("User: %s\n", currentNodeData.Question))
+	})
+}
 
-		currentNodeID = currentNodeData.ParentID
+// editNodeSystemPrompt は指定ノード以下のサブツリーに適用するシステムプロンプトを編集するダイアログを表示します。
+// 空にするとプロジェクト既定 (または祖先ノードの設定) に戻ります。
+func (a *App) editNodeSystemPrompt(nodeID string) {
+	nodeData := a.findNodeData(nodeID)
+	if nodeData == nil {
+		return
 	}
+	title := fmt.Sprintf("「%s」以下のシステムプロンプト", utils.TruncateText(nodeData.Title, nodeTitleMaxLength))
+	a.showSystemPromptDialog(title, nodeData.SystemPrompt, func(prompt string) {
+		a.nodesMutex.Lock()
+		nodeData.SystemPrompt = prompt
+		a.nodesMutex.Unlock()
+		log.Printf("System prompt for subtree %s updated (%d chars)", nodeID, len([]rune(prompt)))
+		if a.currentProjectID != "" {
+			a.saveCurrentProject()
+		}
+	})
+}
 
-	var builder strings.Builder
-	for i := len(historyParts) - 1; i >= 0; i-- {
-		builder.WriteString(historyParts[i])
-		if i > 0 {
-			builder.WriteString("\n")
+// showSystemPromptDialog はシステムプロンプト編集用のダイアログを表示し、保存時に onSave を呼び出します。
+func (a *App) showSystemPromptDialog(title string, current string, onSave func(prompt string)) {
+	entry := widget.NewMultiLineEntry()
+	entry.Wrapping = fyne.TextWrapWord
+	entry.SetPlaceHolder("例: あなたは経験豊富なソフトウェアアーキテクトです。簡潔に回答してください。")
+	entry.SetText(current)
+	entry.SetMinRowsVisible(8)
+
+	content := container.NewVScroll(entry)
+	content.SetMinSize(fyne.NewSize(480, 220))
+
+	dialog.ShowCustomConfirm(title, "保存", "キャンセル", content, func(confirm bool) {
+		if confirm {
+			onSave(strings.TrimSpace(entry.Text))
 		}
-	}
-	return builder.String()
+	}, a.window)
 }
 
+// handleSend は送信ボタンとショートカットから入力中の質問を送信します。
 func (a *App) handleSend() {
+	a.sendQuestion()
+}
+
+// sendQuestion は入力中の質問を分岐元への質問として送信し、送信を開始できた場合は true を返します。
+// 質問が空の場合や他のリクエストが実行中の場合は、理由を表示して false を返します。UIスレッドから呼び出します。
+func (a *App) sendQuestion() bool {
 	currentQuestion := a.chatInput.Text
 	if currentQuestion == "" {
 		dialog.ShowInformation("情報", "質問を入力してください。", a.window)
-		return
+		return false
+	}
+
+	generation, err := a.currentGenerationSettings()
+	if err != nil {
+		dialog.ShowError(err, a.window)
+		return false
+	}
+
+	if a.fanOutCheck.Checked && len(a.fanOutProviders) > 0 {
+		return a.handleFanOutSend(currentQuestion, generation)
 	}
 
 	isNewProject := false
@@ -215,10 +337,13 @@ func (a *App) handleSend() {
 		isNewProject = true
 	}
 
+	ctx, cancel, ok := a.beginRequest("AI応答生成中...")
+	if !ok {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return false
+	}
 	log.Printf("ユーザーからの質問: %s (プロジェクト: %s)", currentQuestion, a.currentProjectID)
 	a.chatInput.SetText("")
-	a.sendButton.Disable()
-	a.statusLabel.SetText("AI応答生成中...")
 
 	branchSource := a.dialogCanvas.GetBranchSource()
 	var parentID string
@@ -226,99 +351,311 @@ func (a *App) handleSend() {
 		parentID = branchSource
 	}
 
-	conversationHistory := ""
-	if parentID != "" {
-		conversationHistory = a.getConversationHistory(parentID)
-		if conversationHistory != "" {
-			conversationHistory += "\n\n"
-		}
+	placeholder := &ui.NodeData{
+		ID:          uuid.NewString(),
+		Title:       "生成中...",
+		Question:    currentQuestion,
+		Expanded:    false,
+		ParentID:    parentID,
+		Generation:  generation,
+		Status:      ui.NodeStatusPending,
+		Attachments: a.takePendingAttachments(),
+		References:  a.retrieveReferences(parentID, currentQuestion),
 	}
-	instructedQuestion := "応答の最初の行に「Title: 」に続けてタイトルを記述し、改行を2つ入れてから本文を記述してください。\n\n質問： " + currentQuestion
-	fullPrompt := conversationHistory + "User: " + instructedQuestion
+	a.addNode(placeholder)
+	projectID := a.currentProjectID
 
-	go func(promptToSend string, originalQuestion string, pID string, isFirstNodeInProject bool) {
+	go func(ctx context.Context, nodeData *ui.NodeData, originalQuestion string, isFirstNodeInProject bool) {
+		ctx = ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(nodeData))
+		status := "準備完了"
 		defer func() {
-			fyne.Do(func() {
-				if a.sendButton != nil {
-					a.sendButton.Enable()
-				}
-				if a.statusLabel != nil {
-					a.statusLabel.SetText("準備完了")
-				}
-			})
+			a.finishRequest(cancel, status)
 		}()
 
 		var answerText string
 		var err error
 
-		if a.geminiClient != nil {
-			answerText, err = a.geminiClient.Generate(promptToSend)
+		if a.aiProvider != nil {
+			a.ensureHistorySummary(ctx, a.aiProvider, parentID)
+			requestToSend := a.newChatRequest(parentID, parentID, originalQuestion, nodeData.Attachments, nodeData.References, generation)
+			var resp *ai_client.Response
+			resp, err = a.streamAnswer(ctx, a.aiProvider, nodeData, requestToSend, projectID)
+			if ctx.Err() != nil {
+				log.Printf("AI request cancelled: %v", ctx.Err())
+				status = "AI応答の生成をキャンセルしました"
+				fyne.Do(func() {
+					a.discardNode(nodeData.ID)
+					a.dialogCanvas.SetBranchSource(nodeData.ParentID)
+					if a.chatInput != nil && a.chatInput.Text == "" {
+						a.chatInput.SetText(originalQuestion)
+						a.setPendingAttachments(nodeData.Attachments)
+					}
+				})
+				return
+			}
 			if err != nil {
-				log.Printf("Gemini API Error: %v", err)
-				answerText = fmt.Sprintf("API Error: %v", err)
+				log.Printf("AI Provider Error (%s): %v", a.aiProvider.ModelInfo(), err)
+				status = "AI応答の生成に失敗しました"
+			} else {
+				answerText = resp.Text
+				recorded := recordedGeneration(nodeData.Generation, a.aiProvider, resp)
+				fyne.Do(func() {
+					a.withNodesLocked(func() {
+						nodeData.Generation = recorded
+					})
+				})
 			}
 		} else {
-			answerText = fmt.Sprintf("「%s」に対するAIの応答です。(APIキー未設定)", originalQuestion)
-			log.Println("Gemini client not initialized.")
-		}
-
-		nodeTitle := ""
-		nodeAnswerContent := answerText
-
-		if strings.HasPrefix(answerText, "Title: ") {
-			parts := strings.SplitN(answerText, "\n", 3)
-			if len(parts) >= 1 {
-				nodeTitle = strings.TrimSpace(strings.TrimPrefix(parts[0], "Title: "))
-				if len(parts) == 2 {
-					if strings.TrimSpace(parts[1]) == "" {
-						nodeAnswerContent = ""
-					} else {
-						nodeAnswerContent = parts[1]
-					}
-				} else if len(parts) >= 3 {
-					nodeAnswerContent = parts[2]
+			err = fmt.Errorf("AIプロバイダが初期化されていません (APIキー未設定)")
+			log.Println("AI provider not initialized.")
+			status = "AI応答の生成に失敗しました"
+		}
+
+		nodeTitle := fallbackNodeTitle(originalQuestion)
+		if err == nil {
+			nodeTitle = a.generateNodeTitle(ctx, a.aiProvider, originalQuestion, answerText)
+		}
+
+		if isFirstNodeInProject {
+			fyne.Do(func() {
+				a.createProject(nodeTitle)
+			})
+		}
+
+		fyne.Do(func() {
+			a.withNodesLocked(func() {
+				nodeData.Title = nodeTitle
+				nodeData.Answer = answerText
+				if err != nil {
+					nodeData.MarkFailed(err)
 				} else {
-					nodeAnswerContent = ""
+					nodeData.MarkComplete()
 				}
-			}
-		}
+			})
+			a.completeNode(nodeData)
+		})
+	}(ctx, placeholder, currentQuestion, isNewProject)
+	return true
+}
 
-		if nodeTitle == "" {
-			log.Println("Title not extracted via 'Title: ' prefix. Using fallback.")
-			if firstNewLine := strings.Index(answerText, "\n"); firstNewLine != -1 {
-				nodeTitle = answerText[:firstNewLine]
+// regenerateNode は同じ質問と祖先の文脈で回答を再生成し、ノードに新しいバージョンとして追加します。
+// 既存の回答は別バージョンとして残り、ノード上の矢印で切り替えられます。
+// 生成に失敗したノードの場合は、成功した回答をそのままノードの回答にします。
+func (a *App) regenerateNode(nodeID string) {
+	nodeData := a.findNodeData(nodeID)
+	if nodeData == nil {
+		return
+	}
+	if a.aiProvider == nil {
+		dialog.ShowInformation("情報", "AIプロバイダが設定されていないため再生成できません。", a.window)
+		return
+	}
+	generation, err := a.currentGenerationSettings()
+	if err != nil {
+		dialog.ShowError(err, a.window)
+		return
+	}
+	ctx, cancel, ok := a.beginRequest("回答を再生成中...")
+	if !ok {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return
+	}
+	log.Printf("Regenerating answer for node %s with %s", nodeID, generation.Summary())
+
+	previousAnswer := nodeData.Answer
+	previousStatus := nodeData.Status
+	previousError := nodeData.Error
+	wasFailed := nodeData.IsFailed()
+	provider := a.aiProvider
+	if fanOutProvider := a.fanOutProviderFor(nodeData.Generation); wasFailed && fanOutProvider != nil {
+		// 比較送信で失敗したノードは、同じモデルで再試行します。
+		provider = fanOutProvider
+	}
+	if wasFailed {
+		a.withNodesLocked(func() {
+			nodeData.Status = ui.NodeStatusPending
+			nodeData.Error = ""
+		})
+		a.dialogCanvas.RefreshNode(nodeID)
+	}
+	projectID := a.currentProjectID
+
+	go func() {
+		status := "準備完了"
+		defer func() {
+			a.finishRequest(cancel, status)
+		}()
+
+		ctx := ai_client.WithUsageRecorder(ctx, a.usageRecorderFor(nodeData))
+		a.ensureHistorySummary(ctx, a.aiProvider, nodeData.ParentID)
+		var request *ai_client.Request
+		if nodeData.IsMerge() {
+			request = a.newMergeRequest(nodeData, generation)
+		} else {
+			request = a.newChatRequest(nodeData.ParentID, nodeID, nodeData.Question, nodeData.Attachments, nodeData.References, generation)
+		}
+		if provider != a.aiProvider {
+			request.Model = ""
+		}
+		// 同じリクエストを送るため、キャッシュを使うと前回と同じ回答がバージョンとして追加されてしまいます。
+		resp, err := a.streamAnswer(ai_client.WithCacheDisabled(ctx), provider, nodeData, request, projectID)
+		if ctx.Err() != nil || err != nil {
+			if ctx.Err() != nil {
+				status = "再生成をキャンセルしました"
 			} else {
-				nodeTitle = answerText
+				log.Printf("AI Provider Error (%s): %v", provider.ModelInfo(), err)
+				status = "再生成に失敗しました"
 			}
-		}
-		nodeTitle = utils.TruncateText(nodeTitle, nodeTitleMaxLength*2)
-		if nodeTitle == "" {
-			nodeTitle = "無題のノード"
+			fyne.Do(func() {
+				a.withNodesLocked(func() {
+					nodeData.Answer = previousAnswer
+					nodeData.Status = previousStatus
+					nodeData.Error = previousError
+					if wasFailed && ctx.Err() == nil {
+						nodeData.MarkFailed(err)
+					}
+				})
+				if wasFailed && ctx.Err() == nil {
+					a.completeNode(nodeData)
+					return
+				}
+				a.dialogCanvas.RefreshNode(nodeData.ID)
+				if err != nil && ctx.Err() == nil {
+					dialog.ShowError(fmt.Errorf("回答の再生成に失敗しました: %w", err), a.window)
+				}
+			})
+			return
 		}
 
-		if isFirstNodeInProject {
+		recorded := recordedGeneration(generation, provider, resp)
+		if wasFailed {
+			nodeTitle := a.generateNodeTitle(ctx, provider, nodeData.Question, resp.Text)
 			fyne.Do(func() {
-				a.currentProjectID = uuid.NewString()
-				a.currentProjectName = nodeTitle
-				if a.currentProjectName == "" {
-					a.currentProjectName = "New Project - " + time.Now().Format("150405")
-				}
-				a.updateWindowTitle()
-				log.Printf("新規プロジェクトが作成されました: ID=%s, Name=%s", a.currentProjectID, a.currentProjectName)
+				a.withNodesLocked(func() {
+					nodeData.Title = nodeTitle
+					nodeData.Answer = resp.Text
+					nodeData.Generation = recorded
+					nodeData.MarkComplete()
+				})
+				a.completeNode(nodeData)
+			})
+			return
+		}
+		fyne.Do(func() {
+			a.withNodesLocked(func() {
+				nodeData.AddVersion(resp.Text, recorded)
 			})
+			a.completeNode(nodeData)
+		})
+	}()
+}
+
+// createProject は最初のノードのタイトルを名前として新しいプロジェクトを作成します。UIスレッドから呼び出します。
+func (a *App) createProject(name string) {
+	a.currentProjectID = uuid.NewString()
+	a.currentProjectName = name
+	if a.currentProjectName == "" {
+		a.currentProjectName = "New Project - " + time.Now().Format("150405")
+	}
+	a.updateWindowTitle()
+	log.Printf("新規プロジェクトが作成されました: ID=%s, Name=%s", a.currentProjectID, a.currentProjectName)
+}
+
+// beginRequest は入力を無効化し、キャンセル可能なAIリクエスト用のコンテキストを作成します。
+// 既に実行中のリクエストがある場合は ok=false を返します。UIスレッドから呼び出します。
+func (a *App) beginRequest(statusText string) (context.Context, context.CancelFunc, bool) {
+	a.requestMutex.Lock()
+	defer a.requestMutex.Unlock()
+	if a.cancelRequest != nil {
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = ai_client.WithRetryNotifier(ctx, a.showRetryStatus)
+	if a.noCacheCheck.Checked {
+		ctx = ai_client.WithCacheDisabled(ctx)
+	}
+	a.cancelRequest = cancel
+	a.sendButton.Disable()
+	a.cancelButton.Enable()
+	a.statusLabel.SetText(statusText)
+	return ctx, cancel, true
+}
+
+// isRequestRunning はAIリクエストが実行中かどうかを返します。
+func (a *App) isRequestRunning() bool {
+	a.requestMutex.Lock()
+	defer a.requestMutex.Unlock()
+	return a.cancelRequest != nil
+}
+
+// finishRequest はリクエストの終了後にコンテキストを解放し、入力を再び有効化します。
+func (a *App) finishRequest(cancel context.CancelFunc, statusText string) {
+	cancel()
+	a.requestMutex.Lock()
+	a.cancelRequest = nil
+	a.requestMutex.Unlock()
+	fyne.Do(func() {
+		if a.sendButton != nil {
+			a.sendButton.Enable()
+		}
+		if a.cancelButton != nil {
+			a.cancelButton.Disable()
+		}
+		if a.statusLabel != nil && statusText != "" {
+			a.statusLabel.SetText(statusText)
 		}
+	})
+}
 
-		newNodeData := &ui.NodeData{
-			ID:       uuid.NewString(),
-			Title:    nodeTitle,
-			Question: originalQuestion,
-			Answer:   nodeAnswerContent,
-			Expanded: false,
-			ParentID: pID,
+// showRetryStatus は一時的なエラーによる再試行の待機をステータスラベルに表示します。
+func (a *App) showRetryStatus(attempt int, maxRetries int, wait time.Duration, err error) {
+	fyne.Do(func() {
+		if a.statusLabel != nil {
+			a.statusLabel.SetText(fmt.Sprintf("一時的なエラーのため %d秒後に再試行します (%d/%d)...", int(wait.Round(time.Second)/time.Second), attempt, maxRetries))
 		}
-		a.uiUpdateChan <- newNodeData
+	})
+}
 
-	}(fullPrompt, currentQuestion, parentID, isNewProject)
+// handleCancel は実行中のAIリクエストを中断します。リクエストがない場合は何もしません。
+func (a *App) handleCancel() {
+	a.requestMutex.Lock()
+	cancel := a.cancelRequest
+	a.requestMutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	log.Println("Cancelling in-flight AI request.")
+	cancel()
+	if a.statusLabel != nil {
+		a.statusLabel.SetText("キャンセル中...")
+	}
+}
+
+// generateNodeTitle は質問と回答からノードのタイトルを生成します。
+// タイトル生成に失敗した場合は質問の先頭行を使用します。
+func (a *App) generateNodeTitle(ctx context.Context, provider ai_client.Provider, question string, answer string) string {
+	if provider == nil || strings.TrimSpace(answer) == "" {
+		return fallbackNodeTitle(question)
+	}
+	title, err := ai_client.GenerateTitle(ctx, provider, question, answer)
+	if err != nil {
+		log.Printf("Title generation failed, using question as title: %v", err)
+		return fallbackNodeTitle(question)
+	}
+	return fallbackNodeTitle(title)
+}
+
+// fallbackNodeTitle はテキストの先頭行をノードのタイトルとして使える長さに切り詰めます。
+func fallbackNodeTitle(text string) string {
+	title := strings.TrimSpace(text)
+	if firstNewLine := strings.Index(title, "\n"); firstNewLine != -1 {
+		title = title[:firstNewLine]
+	}
+	title = utils.TruncateText(strings.TrimSpace(title), nodeTitleMaxLength*2)
+	if title == "" {
+		title = "無題のノード"
+	}
+	return title
 }
 
 func (a *App) handleUIUpdates() {
@@ -333,6 +670,49 @@ func (a *App) handleUIUpdates() {
 	}
 }
 
+// completeNode は生成が完了したノードを再描画し、プロジェクトを保存します。
+// 生成中にノードが削除されていた場合は何もしません。
+func (a *App) completeNode(data *ui.NodeData) {
+	if a.findNodeData(data.ID) == nil {
+		log.Printf("completeNode: node %s was removed while generating, skipping.", data.ID)
+		return
+	}
+	a.dialogCanvas.RefreshNode(data.ID)
+	if a.currentProjectID != "" {
+		a.saveCurrentProject()
+	}
+}
+
+// findNodeData は指定されたIDのNodeDataを返します。見つからない場合はnilを返します。
+func (a *App) findNodeData(nodeID string) *ui.NodeData {
+	a.nodesMutex.RLock()
+	defer a.nodesMutex.RUnlock()
+	for _, n := range a.nodes {
+		if n.ID == nodeID {
+			return n
+		}
+	}
+	return nil
+}
+
+// handleNodeChanged はノード上の操作 (回答バージョンの切り替えなど) でNodeDataが変更されたときに呼ばれます。
+func (a *App) handleNodeChanged(data *ui.NodeData) {
+	log.Printf("Node %s changed (active version %d/%d)", data.ID, data.ActiveVersion+1, data.VersionCount())
+	if a.currentProjectID != "" {
+		a.saveCurrentProject()
+	}
+}
+
+// switchNodeVersion はノードのアクティブな回答バージョンを切り替えます。
+// バックグラウンドのリクエストが会話履歴として Answer を読むため、nodesMutex を保持して書き換えます。
+func (a *App) switchNodeVersion(data *ui.NodeData, index int) bool {
+	var switched bool
+	a.withNodesLocked(func() {
+		switched = data.SetActiveVersion(index)
+	})
+	return switched
+}
+
 func (a *App) addNode(data *ui.NodeData) {
 	a.nodesMutex.Lock()
 	a.nodes = append(a.nodes, data)
@@ -342,6 +722,13 @@ func (a *App) addNode(data *ui.NodeData) {
 	a.dialogCanvas.Refresh()
 }
 
+// discardNode は確認なしでノードとその子孫を破棄します。キャンセルされた生成中ノードの後始末に使います。
+func (a *App) discardNode(nodeID string) {
+	deletedIDs := a.dialogCanvas.RemoveNodeAndDescendants(nodeID)
+	a.updateAppDataAfterDeletion(deletedIDs)
+	a.dialogCanvas.Refresh()
+}
+
 func (a *App) requestNodeDeletion(nodeID string) {
 	log.Printf("App.requestNodeDeletion: %s", nodeID)
 	fyne.Do(func() {
@@ -497,6 +884,12 @@ func (a *App) clearCurrentProjectState() {
 	}
 	a.currentProjectID = ""
 	a.currentProjectName = ""
+	a.systemPrompt = ""
+	a.projectUsage = nil
+	a.setPendingAttachments(nil)
+	if a.modelSelect != nil {
+		a.resetGenerationSettings()
+	}
 	a.updateWindowTitle()
 	if a.statusLabel != nil {
 		a.statusLabel.SetText("準備完了 (プロジェクトなし)")
@@ -506,6 +899,11 @@ func (a *App) clearCurrentProjectState() {
 	}
 }
 
+// questionAnswerMarkdown は質問と回答を保存用のMarkdownに整形します。
+func questionAnswerMarkdown(question string, answer string) string {
+	return fmt.Sprintf("# Question\n\n%s\n\n---\n\n# Answer\n\n%s", question, answer)
+}
+
 // saveData は指定されたプロジェクトIDと名前で現在のノードデータを保存します。
 func saveData(appInstance *App, projectID string, projectName string) {
 	if projectID == "" {
@@ -522,7 +920,12 @@ func saveData(appInstance *App, projectID string, projectName string) {
 	}
 	appInstance.nodesMutex.RUnlock()
 
-	tree := TreeData{Nodes: nodesToSave, ProjectName: projectName}
+	tree := TreeData{Nodes: nodesToSave, ProjectName: projectName, SystemPrompt: appInstance.systemPrompt, Usage: appInstance.projectUsage}
+	if appInstance.modelSelect != nil {
+		if generation, err := appInstance.currentGenerationSettings(); err == nil {
+			tree.Generation = generation
+		}
+	}
 	projectDataPath := filepath.Join(projectsBaseDir, projectID)
 	yamlFile := filepath.Join(projectDataPath, yamlFileName)
 	mdDir := filepath.Join(projectDataPath, mdNodesDirName)
@@ -569,13 +972,22 @@ func saveData(appInstance *App, projectID string, projectName string) {
 	}
 
 	for _, node := range nodesToSave {
-		mdContent := fmt.Sprintf("# Question\n\n%s\n\n---\n\n# Answer\n\n%s", node.Question, node.Answer)
+		mdContent := questionAnswerMarkdown(node.Question, node.Answer)
 		mdPath := filepath.Join(mdDir, node.ID+".md")
 		err = ioutil.WriteFile(mdPath, []byte(mdContent), 0644)
 		if err != nil {
 			log.Printf("Markdownファイル書き込みエラー (%s): %v", mdPath, err)
 		}
+		for i, version := range node.Versions {
+			versionContent := questionAnswerMarkdown(node.Question, version.Answer)
+			versionPath := filepath.Join(mdDir, versionFileName(node.ID, i))
+			if err := ioutil.WriteFile(versionPath, []byte(versionContent), 0644); err != nil {
+				log.Printf("Markdownファイル書き込みエラー (%s): %v", versionPath, err)
+			}
+		}
+		saveAttachments(projectDataPath, node)
 	}
+	appInstance.refreshEmbeddingIndex(projectID, projectName, embeddingDocuments(nodesToSave))
 
 	log.Println("データが正常に保存されました。")
 	if appInstance.statusLabel != nil {
@@ -628,10 +1040,6 @@ func loadProjectData(appInstance *App, projectID string) {
 		return
 	}
 
-	appInstance.currentProjectID = projectID
-	appInstance.currentProjectName = tree.ProjectName
-	appInstance.updateWindowTitle()
-
 	loadedNodes := []*ui.NodeData{}
 	for _, node := range tree.Nodes {
 		mdPath := filepath.Join(mdDir, node.ID+".md")
@@ -643,10 +1051,29 @@ func loadProjectData(appInstance *App, projectID string) {
 		}
 		node.Question = q
 		node.Answer = ans
+		if node.Status == ui.NodeStatusPending {
+			node.MarkFailed(fmt.Errorf("アプリケーションの終了により生成が中断されました"))
+		}
+		for i := range node.Versions {
+			versionPath := filepath.Join(mdDir, versionFileName(node.ID, i))
+			_, versionAns, errVersion := parseMarkdown(versionPath)
+			if errVersion != nil {
+				log.Printf("Markdownファイル読み込みエラー (%s): %v", versionPath, errVersion)
+				versionAns = fmt.Sprintf("Markdownファイル '%s' の読み込みに失敗しました: %v", versionPath, errVersion)
+			}
+			node.Versions[i].Answer = versionAns
+		}
+		loadAttachments(projectDataPath, node)
 		loadedNodes = append(loadedNodes, node)
 	}
 
-	appInstance.clearCurrentProjectState() // Clear before loading new nodes, but preserve new project ID/Name
+	appInstance.clearCurrentProjectState() // Clear before loading new nodes, then set the new project ID/Name
+	appInstance.currentProjectID = projectID
+	appInstance.currentProjectName = tree.ProjectName
+	appInstance.systemPrompt = tree.SystemPrompt
+	appInstance.projectUsage = tree.Usage
+	appInstance.applyGenerationSettings(tree.Generation)
+	appInstance.updateWindowTitle()
 
 	appInstance.nodesMutex.Lock()
 	appInstance.nodes = loadedNodes // Assign loaded nodes to mainApp
@@ -667,6 +1094,11 @@ func loadProjectData(appInstance *App, projectID string) {
 	}
 }
 
+// versionFileName は回答バージョンを保存するMarkdownファイル名 (例: <id>.v2.md) を返します。
+func versionFileName(nodeID string, index int) string {
+	return fmt.Sprintf("%s.v%d.md", nodeID, index+1)
+}
+
 func parseMarkdown(filePath string) (string, string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
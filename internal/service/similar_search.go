@@ -0,0 +1,364 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/search"
+	"AI-Dialogue-Map/internal/ui"
+	"AI-Dialogue-Map/internal/utils"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// embeddingIndexFileName は projects/ 直下に置く、全プロジェクト共通の埋め込みインデックスのファイル名です。
+	embeddingIndexFileName = "embedding_index.json"
+	// embeddingTextMaxLength は1ノードあたりの埋め込むテキストの最大文字数です。
+	embeddingTextMaxLength = 4000
+	// similarResultLimit は類似ノード検索で表示する結果の最大数です。
+	similarResultLimit = 20
+)
+
+// newEmbeddingProvider は embedding_provider の設定から埋め込み用のプロバイダを作成します。
+// 未指定の場合は既定のプロバイダを使用します。埋め込みに対応していない場合はnilを返します。
+func newEmbeddingProvider(cfg config.Config, defaultProvider ai_client.Provider) ai_client.Provider {
+	provider := defaultProvider
+	if name := strings.TrimSpace(cfg.EmbeddingProvider); name != "" {
+		p, err := ai_client.NewProviderByName(cfg, name)
+		if err != nil {
+			log.Printf("埋め込み用のプロバイダの初期化に失敗しました: %v", err)
+			return nil
+		}
+		provider = p
+	}
+	if provider == nil || ai_client.EmbeddingModelOf(provider) == "" {
+		return nil
+	}
+	log.Printf("Embedding model: %s", ai_client.EmbeddingModelOf(provider))
+	return provider
+}
+
+// embeddingIndexPath は埋め込みインデックスのファイルのパスを返します。
+func embeddingIndexPath() string {
+	return filepath.Join(projectsBaseDir, embeddingIndexFileName)
+}
+
+// embeddingText はノードのタイトル・質問・回答を埋め込み用のテキストにまとめます。
+func embeddingText(n *ui.NodeData) string {
+	return utils.TruncateText(n.Title+"\n\n"+n.Question+"\n\n"+n.Answer, embeddingTextMaxLength)
+}
+
+// embeddingDocuments は回答が完了したノードを埋め込みインデックスに登録する文書に変換します。
+func embeddingDocuments(nodes []*ui.NodeData) []search.EmbeddingDocument {
+	docs := make([]search.EmbeddingDocument, 0, len(nodes))
+	for _, n := range nodes {
+		if n.IsComplete() {
+			docs = append(docs, search.EmbeddingDocument{NodeID: n.ID, Title: n.Title, Text: embeddingText(n)})
+		}
+	}
+	return docs
+}
+
+// withEmbeddingIndex は埋め込みインデックスを (初回はファイルから) 読み込み、fn を実行します。
+// インデックスの読み書きは embeddingMutex で1つずつ行います。
+func (a *App) withEmbeddingIndex(fn func(ix *search.EmbeddingIndex) error) error {
+	a.embeddingMutex.Lock()
+	defer a.embeddingMutex.Unlock()
+	if a.embeddingIndex == nil {
+		ix, err := search.LoadEmbeddingIndex(embeddingIndexPath())
+		if err != nil {
+			return err
+		}
+		a.embeddingIndex = ix
+	}
+	return fn(a.embeddingIndex)
+}
+
+// updateEmbeddingIndex はプロジェクトのノードの変更を埋め込みインデックスに反映し、変更があればファイルに保存します。
+// UIスレッド以外から呼び出します。
+func (a *App) updateEmbeddingIndex(ctx context.Context, projectID string, projectName string, docs []search.EmbeddingDocument) error {
+	provider := a.embeddingProvider
+	if provider == nil {
+		return ai_client.ErrEmbeddingUnsupported
+	}
+	embed := func(ctx context.Context, texts []string) ([][]float32, error) {
+		return ai_client.EmbedTexts(ctx, provider, texts)
+	}
+	return a.withEmbeddingIndex(func(ix *search.EmbeddingIndex) error {
+		embedded, changed, err := ix.UpdateProject(ctx, ai_client.EmbeddingModelOf(provider), projectID, projectName, docs, embed)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+		log.Printf("Embedding index updated for project %s (%d nodes embedded)", projectID, embedded)
+		return ix.Save(embeddingIndexPath())
+	})
+}
+
+// embeddingRefresh は埋め込みインデックスへの反映を待っているプロジェクトのスナップショットです。
+type embeddingRefresh struct {
+	projectName string
+	docs        []search.EmbeddingDocument
+}
+
+// refreshEmbeddingIndex は保存したプロジェクトを埋め込みインデックスにバックグラウンドで反映します。
+// 反映はプロジェクトごとに1つのワーカーが順に行い、反映待ちの間に保存されたスナップショットは最新のものだけを残します。
+// 失敗しても保存には影響しないため、エラーはログに記録するだけです。
+func (a *App) refreshEmbeddingIndex(projectID string, projectName string, docs []search.EmbeddingDocument) {
+	if !config.Cfg.EmbeddingIndex || a.embeddingProvider == nil {
+		return
+	}
+	a.embeddingQueueMutex.Lock()
+	defer a.embeddingQueueMutex.Unlock()
+	if a.embeddingPending == nil {
+		a.embeddingPending = make(map[string]*embeddingRefresh)
+		a.embeddingWorkers = make(map[string]bool)
+	}
+	a.embeddingPending[projectID] = &embeddingRefresh{projectName: projectName, docs: docs}
+	if a.embeddingWorkers[projectID] {
+		return
+	}
+	a.embeddingWorkers[projectID] = true
+	go a.runEmbeddingRefresh(projectID)
+}
+
+// runEmbeddingRefresh はプロジェクトの反映待ちのスナップショットがなくなるまで埋め込みインデックスに反映します。
+func (a *App) runEmbeddingRefresh(projectID string) {
+	for {
+		a.embeddingQueueMutex.Lock()
+		next, ok := a.embeddingPending[projectID]
+		if !ok {
+			delete(a.embeddingWorkers, projectID)
+			a.embeddingQueueMutex.Unlock()
+			return
+		}
+		delete(a.embeddingPending, projectID)
+		a.embeddingQueueMutex.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		if err := a.updateEmbeddingIndex(ctx, projectID, next.projectName, next.docs); err != nil {
+			log.Printf("埋め込みインデックスの更新に失敗しました (%s): %v", projectID, err)
+		}
+		cancel()
+	}
+}
+
+// readProjectDocuments は保存されたプロジェクトを開かずに読み込み、プロジェクト名と埋め込み用の文書を返します。
+func readProjectDocuments(projectID string) (string, []search.EmbeddingDocument, error) {
+	projectDataPath := filepath.Join(projectsBaseDir, projectID)
+	yamlData, err := os.ReadFile(filepath.Join(projectDataPath, yamlFileName))
+	if err != nil {
+		return "", nil, err
+	}
+	var tree TreeData
+	if err := yaml.Unmarshal(yamlData, &tree); err != nil {
+		return "", nil, err
+	}
+	nodes := make([]*ui.NodeData, 0, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		mdPath := filepath.Join(projectDataPath, mdNodesDirName, node.ID+".md")
+		q, ans, err := parseMarkdown(mdPath)
+		if err != nil {
+			log.Printf("Markdownファイル読み込みエラー (%s): %v", mdPath, err)
+			continue
+		}
+		node.Question = q
+		node.Answer = ans
+		nodes = append(nodes, node)
+	}
+	return tree.ProjectName, embeddingDocuments(nodes), nil
+}
+
+// rebuildEmbeddingIndex は projects/ 以下のすべてのプロジェクトを埋め込みインデックスに反映し、
+// 削除されたプロジェクトをインデックスから取り除きます。変更のないノードは埋め込み直しません。
+func (a *App) rebuildEmbeddingIndex() {
+	if a.embeddingProvider == nil {
+		dialog.ShowInformation("埋め込みインデックス", "埋め込みに対応したプロバイダがありません。embedding_provider を設定してください。", a.window)
+		return
+	}
+	entries, err := os.ReadDir(projectsBaseDir)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("プロジェクトの読み込みに失敗しました: %w", err), a.window)
+		return
+	}
+	var projectIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			projectIDs = append(projectIDs, entry.Name())
+		}
+	}
+	ctx, cancel, ok := a.beginRequest("埋め込みインデックスを更新中...")
+	if !ok {
+		dialog.ShowInformation("情報", "他のAIリクエストが実行中です。", a.window)
+		return
+	}
+
+	go func() {
+		status := "埋め込みインデックスを更新しました"
+		defer func() {
+			a.finishRequest(cancel, status)
+		}()
+		existing := make(map[string]bool, len(projectIDs))
+		failed := 0
+		for i, projectID := range projectIDs {
+			existing[projectID] = true
+			progress := fmt.Sprintf("埋め込みインデックスを更新中... (%d/%d)", i+1, len(projectIDs))
+			fyne.Do(func() {
+				a.statusLabel.SetText(progress)
+			})
+			name, docs, err := readProjectDocuments(projectID)
+			if err == nil {
+				err = a.updateEmbeddingIndex(ctx, projectID, name, docs)
+			}
+			if ctx.Err() != nil {
+				status = "埋め込みインデックスの更新をキャンセルしました"
+				return
+			}
+			if err != nil {
+				log.Printf("埋め込みインデックスの更新に失敗しました (%s): %v", projectID, err)
+				failed++
+			}
+		}
+		err := a.withEmbeddingIndex(func(ix *search.EmbeddingIndex) error {
+			stale := make(map[string]bool)
+			for _, e := range ix.Entries {
+				if !existing[e.ProjectID] {
+					stale[e.ProjectID] = true
+				}
+			}
+			for projectID := range stale {
+				log.Printf("Removing deleted project %s from the embedding index", projectID)
+				ix.RemoveProject(projectID)
+			}
+			return ix.Save(embeddingIndexPath())
+		})
+		if err != nil {
+			log.Printf("埋め込みインデックスの保存に失敗しました: %v", err)
+			failed++
+		}
+		if failed > 0 {
+			status = fmt.Sprintf("埋め込みインデックスを更新しました (%d件失敗、詳細はログを参照)", failed)
+		}
+	}()
+}
+
+// showFindSimilarDialog はすべてのプロジェクトから query に意味の近いノードを探すダイアログを表示します。
+// 結果を選ぶと、そのプロジェクトを開いてノードを中央に表示します。excludeNodeID のノードは結果から除きます。
+func (a *App) showFindSimilarDialog(query string, excludeNodeID string) {
+	if a.embeddingProvider == nil {
+		dialog.ShowInformation("類似ノードを検索", "埋め込みに対応したプロバイダがありません。embedding_provider を設定してください。", a.window)
+		return
+	}
+	queryEntry := widget.NewMultiLineEntry()
+	queryEntry.SetPlaceHolder("探したい内容を入力してください")
+	queryEntry.SetMinRowsVisible(3)
+	queryEntry.SetText(query)
+	statusLabel := widget.NewLabel("")
+
+	var matches []search.EmbeddingMatch
+	resultList := widget.NewList(
+		func() int {
+			return len(matches)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("template")
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			m := matches[i]
+			o.(*widget.Label).SetText(fmt.Sprintf("%.2f  %s / %s", m.Score,
+				utils.TruncateText(m.ProjectName, nodeTitleMaxLength), utils.TruncateText(m.Title, nodeTitleMaxLength)))
+		},
+	)
+
+	var d dialog.Dialog
+	resultList.OnSelected = func(id widget.ListItemID) {
+		m := matches[id]
+		d.Hide()
+		a.openNode(m.ProjectID, m.NodeID)
+	}
+
+	var searchButton *widget.Button
+	searchButton = widget.NewButtonWithIcon("検索", theme.SearchIcon(), func() {
+		text := strings.TrimSpace(queryEntry.Text)
+		if text == "" {
+			return
+		}
+		searchButton.Disable()
+		statusLabel.SetText("検索中...")
+		provider := a.embeddingProvider
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			var found []search.EmbeddingMatch
+			vectors, err := ai_client.EmbedTexts(ctx, provider, []string{utils.TruncateText(text, embeddingTextMaxLength)})
+			if err == nil {
+				err = a.withEmbeddingIndex(func(ix *search.EmbeddingIndex) error {
+					if ix.Model != ai_client.EmbeddingModelOf(provider) {
+						return fmt.Errorf("インデックスのモデル (%s) が現在の埋め込みモデルと異なります。「埋め込みインデックスを更新」を実行してください", ix.Model)
+					}
+					for _, m := range ix.Search(vectors[0], similarResultLimit+1) {
+						if m.NodeID != excludeNodeID && len(found) < similarResultLimit {
+							found = append(found, m)
+						}
+					}
+					return nil
+				})
+			}
+			fyne.Do(func() {
+				searchButton.Enable()
+				if err != nil {
+					log.Printf("類似ノードの検索に失敗しました: %v", err)
+					statusLabel.SetText("検索に失敗しました: " + err.Error())
+					return
+				}
+				matches = found
+				resultList.UnselectAll()
+				resultList.Refresh()
+				if len(found) == 0 {
+					statusLabel.SetText("一致するノードはありません。インデックスが空の場合は「埋め込みインデックスを更新」を実行してください。")
+				} else {
+					statusLabel.SetText(fmt.Sprintf("%d件 (類似度の高い順)", len(found)))
+				}
+			})
+		}()
+	})
+
+	top := container.NewVBox(queryEntry, container.NewBorder(nil, nil, nil, searchButton, statusLabel))
+	d = dialog.NewCustom("類似ノードを検索", "閉じる", container.NewBorder(top, nil, nil, nil, resultList), a.window)
+	d.Resize(fyne.NewSize(760, 520))
+	d.Show()
+	if query != "" {
+		searchButton.OnTapped()
+	}
+}
+
+// openNode は指定プロジェクトを (開いていなければ) 開き、ノードを表示領域の中央に表示します。
+func (a *App) openNode(projectID string, nodeID string) {
+	if projectID != a.currentProjectID {
+		if a.isRequestRunning() {
+			dialog.ShowInformation("情報", "AIリクエストの実行中は別のプロジェクトを開けません。", a.window)
+			return
+		}
+		a.loadProjectData(projectID)
+		if a.currentProjectID != projectID {
+			return
+		}
+	}
+	if !a.dialogCanvas.CenterOnNode(nodeID) {
+		dialog.ShowInformation("類似ノードを検索", "ノードが見つかりません。削除された可能性があります。", a.window)
+	}
+}
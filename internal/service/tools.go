@@ -0,0 +1,215 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"AI-Dialogue-Map/internal/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxToolFileSize は read_project_file で読み込むファイルサイズの上限です。
+	maxToolFileSize = 100 * 1024
+	// maxSearchResults は search_nodes が返すノード数の上限です。
+	maxSearchResults = 5
+	// searchSnippetLength は search_nodes の結果に含める回答の抜粋の文字数です。
+	searchSnippetLength = 200
+	// toolRecordMaxLength はノードに保存するツールの実行結果の最大文字数です。
+	toolRecordMaxLength = 2000
+)
+
+// builtinTools はモデルが回答の生成中に呼び出せる組み込みのツールを返します。
+// read_project_file は projectID のプロジェクトのファイルを読み込みます。tools_enabled が false の場合はnilを返します。
+func (a *App) builtinTools(projectID string) []*ai_client.Tool {
+	if !config.Cfg.ToolsEnabled {
+		return nil
+	}
+	return []*ai_client.Tool{
+		{
+			Name:        "read_project_file",
+			Description: fmt.Sprintf("現在のプロジェクトのディレクトリにあるファイルをテキストとして読み込みます。ノードの木は %s、各ノードの質問と回答は %s/<ノードID>.md、添付ファイルは %s/<ノードID>/ にあります。", yamlFileName, mdNodesDirName, attachmentsDirName),
+			Parameters: &ai_client.Schema{
+				Type: ai_client.SchemaObject,
+				Properties: map[string]*ai_client.Schema{
+					"path": {Type: ai_client.SchemaString, Description: fmt.Sprintf("プロジェクトのディレクトリからの相対パス (例: %s、%s/<ノードID>.md)", yamlFileName, mdNodesDirName)},
+				},
+				Required: []string{"path"},
+			},
+			Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+				return readProjectFile(projectID, stringArg(args, "path"))
+			},
+		},
+		{
+			Name:        "search_nodes",
+			Description: "このプロジェクトの既存のノードのタイトル・質問・回答からキーワードを検索し、一致したノードのIDとタイトル、回答の抜粋を返します。",
+			Parameters: &ai_client.Schema{
+				Type: ai_client.SchemaObject,
+				Properties: map[string]*ai_client.Schema{
+					"query": {Type: ai_client.SchemaString, Description: "検索するキーワード"},
+				},
+				Required: []string{"query"},
+			},
+			Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+				return a.searchNodes(stringArg(args, "query"))
+			},
+		},
+		{
+			Name:        "calculate",
+			Description: "数式を計算します。+ - * / % ^ と括弧が使えます。",
+			Parameters: &ai_client.Schema{
+				Type: ai_client.SchemaObject,
+				Properties: map[string]*ai_client.Schema{
+					"expression": {Type: ai_client.SchemaString, Description: "計算する数式 (例: (1.5 + 2) * 3^2)"},
+				},
+				Required: []string{"expression"},
+			},
+			Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+				v, err := utils.EvaluateExpression(stringArg(args, "expression"))
+				if err != nil {
+					return "", err
+				}
+				return strconv.FormatFloat(v, 'g', -1, 64), nil
+			},
+		},
+		{
+			Name:        "get_node_markdown",
+			Description: "ノードIDを指定して、そのノードの質問と回答をMarkdownで取得します。",
+			Parameters: &ai_client.Schema{
+				Type: ai_client.SchemaObject,
+				Properties: map[string]*ai_client.Schema{
+					"node_id": {Type: ai_client.SchemaString, Description: "ノードID (search_nodes の結果に含まれます)"},
+				},
+				Required: []string{"node_id"},
+			},
+			Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+				a.nodesMutex.RLock()
+				defer a.nodesMutex.RUnlock()
+				id := stringArg(args, "node_id")
+				n, ok := a.nodeDataMapLocked()[id]
+				if !ok {
+					return "", fmt.Errorf("node %q not found", id)
+				}
+				return questionAnswerMarkdown(n.Question, n.Answer), nil
+			},
+		},
+	}
+}
+
+// stringArg はツールの引数から文字列の値を取り出します。
+func stringArg(args map[string]interface{}, name string) string {
+	if v, ok := args[name].(string); ok {
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+// readProjectFile はプロジェクトのディレクトリ内のファイルを読み込みます。
+// 絶対パスやディレクトリの外を指すパス (シンボリックリンクの解決後を含む)、テキストでないファイルはエラーになります。
+func readProjectFile(projectID string, path string) (string, error) {
+	if projectID == "" {
+		return "", fmt.Errorf("project has not been saved yet")
+	}
+	return readFileUnder(filepath.Join(projectsBaseDir, projectID), path)
+}
+
+// readFileUnder は root からの相対パス path のファイルを読み込みます。
+// シンボリックリンクを解決した結果が root の外を指す場合はエラーになります。
+func readFileUnder(root string, path string) (string, error) {
+	if path == "" || filepath.IsAbs(path) {
+		return "", fmt.Errorf("path must be relative to the project directory")
+	}
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if isOutside(clean) {
+		return "", fmt.Errorf("path must not leave the project directory")
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(resolvedRoot, clean))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(resolvedRoot, resolved); err != nil || isOutside(rel) {
+		return "", fmt.Errorf("path must not leave the project directory")
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxToolFileSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxToolFileSize {
+		return "", fmt.Errorf("file is larger than %d bytes", maxToolFileSize)
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("file is not a UTF-8 text file")
+	}
+	return string(data), nil
+}
+
+// isOutside は相対パス rel が基準のディレクトリの外を指すかどうかを返します。
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// searchNodes はノードのタイトル・質問・回答から query を大文字小文字を区別せずに検索します。
+func (a *App) searchNodes(query string) (string, error) {
+	if query == "" {
+		return "", fmt.Errorf("query is empty")
+	}
+	q := strings.ToLower(query)
+	a.nodesMutex.RLock()
+	defer a.nodesMutex.RUnlock()
+	var b strings.Builder
+	found := 0
+	for _, n := range a.nodes {
+		if !strings.Contains(strings.ToLower(n.Title), q) &&
+			!strings.Contains(strings.ToLower(n.Question), q) &&
+			!strings.Contains(strings.ToLower(n.Answer), q) {
+			continue
+		}
+		fmt.Fprintf(&b, "- id: %s\n  title: %s\n  answer: %s\n", n.ID, n.Title,
+			strings.ReplaceAll(utils.TruncateText(n.Answer, searchSnippetLength), "\n", " "))
+		found++
+		if found >= maxSearchResults {
+			break
+		}
+	}
+	if found == 0 {
+		return "一致するノードはありません。", nil
+	}
+	return b.String(), nil
+}
+
+// toolCallRecorder はツール呼び出しをノードに記録する関数を返します。
+func (a *App) toolCallRecorder(nodeData *ui.NodeData) ai_client.ToolCallObserver {
+	return func(call ai_client.ToolCall, result ai_client.ToolResult) {
+		record := ui.ToolCallRecord{Name: call.Name}
+		if len(call.Args) > 0 {
+			if args, err := json.Marshal(call.Args); err == nil {
+				record.Arguments = string(args)
+			}
+		}
+		if result.IsError {
+			record.Error = result.Content
+		} else {
+			record.Result = utils.TruncateText(result.Content, toolRecordMaxLength)
+		}
+		a.updateNode(nodeData, func() {
+			nodeData.ToolCalls = append(nodeData.ToolCalls, record)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFileUnder(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "project")
+	if err := os.MkdirAll(filepath.Join(root, mdNodesDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, mdNodesDirName, "n1.md"), []byte("# 質問"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(base, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "link.txt")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+	if err := os.Symlink(base, filepath.Join(root, "outside")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(mdNodesDirName, "n1.md"), filepath.Join(root, "inside.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{mdNodesDirName + "/n1.md", "inside.md"} {
+		got, err := readFileUnder(root, path)
+		if err != nil || got != "# 質問" {
+			t.Errorf("readFileUnder(%q) = %q, %v; want the node markdown", path, got, err)
+		}
+	}
+	for _, path := range []string{"", secret, "../secret.txt", "nodes/../../secret.txt", "link.txt", "outside/secret.txt"} {
+		if got, err := readFileUnder(root, path); err == nil {
+			t.Errorf("readFileUnder(%q) = %q; want an error", path, got)
+		}
+	}
+}
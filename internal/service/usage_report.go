@@ -0,0 +1,140 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"AI-Dialogue-Map/internal/utils"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// usageRecorderFor はトークン使用量をノードとプロジェクトの累計に記録する関数を返します。
+// nodeData がnilの場合はプロジェクトの累計にのみ記録します。
+func (a *App) usageRecorderFor(nodeData *ui.NodeData) ai_client.UsageRecorder {
+	return func(info ai_client.ModelInfo, usage ai_client.Usage) {
+		log.Printf("Token usage (%s): prompt=%d response=%d", info, usage.PromptTokens, usage.ResponseTokens)
+		fyne.Do(func() {
+			a.projectUsage = ui.AddUsage(a.projectUsage, info.Provider, info.Model, usage.PromptTokens, usage.ResponseTokens)
+			if nodeData != nil {
+				a.withNodesLocked(func() {
+					nodeData.Usage = ui.AddUsage(nodeData.Usage, info.Provider, info.Model, usage.PromptTokens, usage.ResponseTokens)
+				})
+				a.dialogCanvas.RefreshNode(nodeData.ID)
+			}
+		})
+	}
+}
+
+// modelPrice は設定の単価表からモデルの単価を探します。
+func modelPrice(model string) (config.ModelPrice, bool) {
+	model = strings.TrimPrefix(model, "models/")
+	for _, price := range config.Cfg.Prices {
+		if strings.EqualFold(strings.TrimPrefix(price.Model, "models/"), model) {
+			return price, true
+		}
+	}
+	return config.ModelPrice{}, false
+}
+
+// usageCost は使用量の費用を計算します。単価が設定されていないモデルの場合は ok=false を返します。
+func usageCost(usages []ui.ModelUsage) (cost float64, ok bool) {
+	ok = true
+	for _, u := range usages {
+		price, found := modelPrice(u.Model)
+		if !found {
+			ok = false
+			continue
+		}
+		cost += float64(u.PromptTokens)/1e6*price.InputPerMillion + float64(u.ResponseTokens)/1e6*price.OutputPerMillion
+	}
+	return cost, ok
+}
+
+// formatCost は費用を通貨付きで表示用に整形します。単価が不明な場合は "-" を返します。
+func formatCost(usages []ui.ModelUsage) string {
+	cost, ok := usageCost(usages)
+	if !ok && cost == 0 {
+		return "-"
+	}
+	text := fmt.Sprintf("%.4f %s", cost, config.Cfg.PriceCurrency)
+	if !ok {
+		text += " (一部単価未設定)"
+	}
+	return text
+}
+
+// showUsageReport はプロジェクトのモデル別・ノード別のトークン使用量と費用を表示します。
+func (a *App) showUsageReport() {
+	if len(a.projectUsage) == 0 {
+		dialog.ShowInformation("使用量レポート", "このプロジェクトにはまだトークン使用量の記録がありません。", a.window)
+		return
+	}
+
+	modelGrid := container.NewGridWithColumns(5,
+		boldLabel("モデル"), boldLabel("リクエスト"), boldLabel("入力トークン"), boldLabel("出力トークン"), boldLabel("費用"))
+	for _, u := range a.projectUsage {
+		name := u.Model
+		if u.Provider != "" {
+			name = u.Provider + "/" + u.Model
+		}
+		modelGrid.Add(widget.NewLabel(name))
+		modelGrid.Add(widget.NewLabel(fmt.Sprintf("%d", u.Requests)))
+		modelGrid.Add(widget.NewLabel(fmt.Sprintf("%d", u.PromptTokens)))
+		modelGrid.Add(widget.NewLabel(fmt.Sprintf("%d", u.ResponseTokens)))
+		modelGrid.Add(widget.NewLabel(formatCost([]ui.ModelUsage{u})))
+	}
+	prompt, response := ui.TotalUsage(a.projectUsage)
+	total := widget.NewLabel(fmt.Sprintf("合計: 入力 %d / 出力 %d トークン、費用 %s", prompt, response, formatCost(a.projectUsage)))
+	total.TextStyle = fyne.TextStyle{Bold: true}
+
+	a.nodesMutex.RLock()
+	nodes := make([]*ui.NodeData, 0, len(a.nodes))
+	for _, n := range a.nodes {
+		if len(n.Usage) > 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	a.nodesMutex.RUnlock()
+	sort.SliceStable(nodes, func(i, j int) bool {
+		pi, ri := ui.TotalUsage(nodes[i].Usage)
+		pj, rj := ui.TotalUsage(nodes[j].Usage)
+		return pi+ri > pj+rj
+	})
+	nodeGrid := container.NewGridWithColumns(4,
+		boldLabel("ノード"), boldLabel("入力トークン"), boldLabel("出力トークン"), boldLabel("費用"))
+	for _, n := range nodes {
+		p, r := ui.TotalUsage(n.Usage)
+		nodeGrid.Add(widget.NewLabel(utils.TruncateText(n.Title, nodeTitleMaxLength)))
+		nodeGrid.Add(widget.NewLabel(fmt.Sprintf("%d", p)))
+		nodeGrid.Add(widget.NewLabel(fmt.Sprintf("%d", r)))
+		nodeGrid.Add(widget.NewLabel(formatCost(n.Usage)))
+	}
+
+	content := container.NewVBox(
+		total,
+		widget.NewSeparator(),
+		widget.NewLabel("モデル別"),
+		modelGrid,
+		widget.NewSeparator(),
+		widget.NewLabel("ノード別 (削除済みのノードは含みません)"),
+		nodeGrid,
+	)
+	d := dialog.NewCustom("使用量レポート", "閉じる", container.NewVScroll(content), a.window)
+	d.Resize(fyne.NewSize(760, 520))
+	d.Show()
+}
+
+// boldLabel は表の見出し用の太字ラベルを作成します。
+func boldLabel(text string) *widget.Label {
+	label := widget.NewLabel(text)
+	label.TextStyle = fyne.TextStyle{Bold: true}
+	return label
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	ai_client "AI-Dialogue-Map/internal/ai"
+	"AI-Dialogue-Map/internal/config"
+	"AI-Dialogue-Map/internal/ui"
+	"reflect"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestUsageIsRecordedPerNodeAndProject(t *testing.T) {
+	useConfig(t, config.Config{})
+	fake := newFakeProvider("openai", "gpt-x")
+	fake.usage = ai_client.Usage{PromptTokens: 100, ResponseTokens: 20}
+	// 使用量はアプリと同じく、プロバイダを包む RetryingProvider が記録します。
+	provider := ai_client.NewRetryingProvider(fake, ai_client.RetryPolicy{}, nil)
+	a := newTestApp(t, provider)
+
+	fyne.DoAndWait(func() {
+		a.chatInput.SetText("最初の質問")
+		a.sendQuestion()
+	})
+	waitForRequest(t, a)
+	node := childrenOf(a, "")[0]
+
+	// 回答とタイトルの生成の2リクエスト分が、ノードとプロジェクトの両方に記録されます。
+	want := []ui.ModelUsage{{Provider: "openai", Model: "gpt-x", Requests: 2, PromptTokens: 200, ResponseTokens: 40}}
+	if !reflect.DeepEqual(node.Usage, want) || !reflect.DeepEqual(a.projectUsage, want) {
+		t.Fatalf("after send: node %+v, project %+v, want %+v", node.Usage, a.projectUsage, want)
+	}
+
+	fyne.DoAndWait(func() {
+		a.regenerateNode(node.ID)
+	})
+	waitForRequest(t, a)
+	want[0] = ui.ModelUsage{Provider: "openai", Model: "gpt-x", Requests: 3, PromptTokens: 300, ResponseTokens: 60}
+	if !reflect.DeepEqual(node.Usage, want) || !reflect.DeepEqual(a.projectUsage, want) {
+		t.Fatalf("after regenerate: node %+v, project %+v, want %+v", node.Usage, a.projectUsage, want)
+	}
+
+	// 使用量はプロジェクトとともに保存され、ノードを削除してもプロジェクトの累計には残ります。
+	projectID := a.currentProjectID
+	fyne.DoAndWait(func() {
+		a.loadProjectData(projectID)
+	})
+	if loaded := a.findNodeData(node.ID); loaded == nil || !reflect.DeepEqual(loaded.Usage, want) || !reflect.DeepEqual(a.projectUsage, want) {
+		t.Fatalf("after reload: node %+v, project %+v", loaded, a.projectUsage)
+	}
+	a.requestNodeDeletion(node.ID)
+	if len(a.nodes) != 0 || !reflect.DeepEqual(a.projectUsage, want) {
+		t.Errorf("after deleting the node: %d nodes, project usage %+v", len(a.nodes), a.projectUsage)
+	}
+}
+
+func TestFormatCost(t *testing.T) {
+	useConfig(t, config.Config{
+		Prices: []config.ModelPrice{
+			{Model: "gpt-x", InputPerMillion: 1, OutputPerMillion: 4},
+			{Model: "models/gemini-x", InputPerMillion: 2, OutputPerMillion: 8},
+		},
+		PriceCurrency: "USD",
+	})
+	priced := []ui.ModelUsage{
+		{Provider: "openai", Model: "GPT-X", PromptTokens: 1000000, ResponseTokens: 500000},
+		{Provider: "gemini", Model: "gemini-x", PromptTokens: 500000, ResponseTokens: 0},
+	}
+	unpriced := ui.ModelUsage{Provider: "ollama", Model: "llama-x", PromptTokens: 1000, ResponseTokens: 1000}
+
+	cases := []struct {
+		name   string
+		usages []ui.ModelUsage
+		want   string
+	}{
+		{"priced models", priced, "4.0000 USD"},
+		{"partly priced", append(append([]ui.ModelUsage{}, priced...), unpriced), "4.0000 USD (一部単価未設定)"},
+		{"no prices", []ui.ModelUsage{unpriced}, "-"},
+	}
+	for _, c := range cases {
+		if got := formatCost(c.usages); got != c.want {
+			t.Errorf("%s: formatCost = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
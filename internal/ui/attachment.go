@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"AI-Dialogue-Map/internal/utils"
+)
+
+// attachmentNameMaxLength はノード上のチップに表示するファイル名の最大文字数です。
+const attachmentNameMaxLength = 16
+
+// Attachment は質問に添付したファイルです。
+// ファイル本体はプロジェクトディレクトリの attachments 以下に保存され、YAMLには含めません。
+type Attachment struct {
+	Name     string `yaml:"name"`
+	MIMEType string `yaml:"mime_type"`
+	Data     []byte `yaml:"-"`
+}
+
+// IsImage は画像ファイルかどうかを返します。画像以外はテキストとして扱います。
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.MIMEType, "image/")
+}
+
+// icon は添付ファイルの種類に応じたアイコンを返します。
+func (a Attachment) icon() fyne.Resource {
+	if a.IsImage() {
+		return theme.FileImageIcon()
+	}
+	return theme.FileTextIcon()
+}
+
+// NewAttachmentChips は添付ファイルをチップとして横に並べたコンテナを作成します。
+// onRemove を指定した場合、チップを押すとその添付ファイルを取り除く操作になります。
+func NewAttachmentChips(attachments []Attachment, onRemove func(index int)) *fyne.Container {
+	chips := container.NewHBox()
+	for i, a := range attachments {
+		if onRemove != nil {
+			index := i
+			chip := widget.NewButtonWithIcon(a.Name, theme.CancelIcon(), func() { onRemove(index) })
+			chip.Importance = widget.LowImportance
+			chips.Add(chip)
+			continue
+		}
+		label := widget.NewLabel(utils.TruncateText(a.Name, attachmentNameMaxLength))
+		label.SizeName = theme.SizeNameCaptionText
+		chips.Add(container.NewHBox(widget.NewIcon(a.icon()), label))
+	}
+	return chips
+}
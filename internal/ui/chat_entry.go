@@ -0,0 +1,31 @@
+package ui
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+)
+
+// ChatEntry は質問入力用の複数行入力欄です。
+// フォーカス中にEscキーが押されると OnEscape を呼び出します。
+type ChatEntry struct {
+	widget.Entry
+	OnEscape func()
+}
+
+// NewChatEntry は新しいChatEntryのインスタンスを作成します。
+func NewChatEntry() *ChatEntry {
+	e := &ChatEntry{}
+	e.MultiLine = true
+	e.Wrapping = fyne.TextWrapWord
+	e.ExtendBaseWidget(e)
+	return e
+}
+
+// TypedKey はEscキーを OnEscape に振り分け、それ以外のキーは通常の入力として処理します。
+func (e *ChatEntry) TypedKey(key *fyne.KeyEvent) {
+	if key.Name == fyne.KeyEscape && e.OnEscape != nil {
+		e.OnEscape()
+		return
+	}
+	e.Entry.TypedKey(key)
+}
@@ -27,6 +27,15 @@ type DialogCanvas struct {
 	viewOffset             fyne.Position
 	zoomFactor             float32
 	onNodeDeleted          func(nodeID string)
+	nodeMenuProvider       func(data *NodeData) []*fyne.MenuItem
+	onNodeChanged          func(data *NodeData)
+	onVersionSwitch        func(data *NodeData, index int) bool
+	onNodeRetry            func(data *NodeData)
+	onBranchSourceChanged  func(nodeID string)
+	ghosts                 []*GhostNode
+	selectedIDs            []string // 統合のために複数選択されたノード (選択順)
+	onSelectionChanged     func(nodeIDs []string)
+	onSuggestionSelected   func(parentID string, question string) bool
 }
 
 // NewDialogCanvas は新しいDialogCanvasのインスタンスを作成します。
@@ -56,6 +65,201 @@ func (dc *DialogCanvas) CreateRenderer() fyne.WidgetRenderer {
 	}
 }
 
+// SetNodeMenuProvider はノードの「…」ボタンで表示するメニュー項目の生成関数を設定します。
+func (dc *DialogCanvas) SetNodeMenuProvider(provider func(data *NodeData) []*fyne.MenuItem) {
+	dc.nodeMenuProvider = provider
+}
+
+// SetOnNodeChanged はノード上の操作でNodeDataが変更されたときに呼び出す関数を設定します。
+func (dc *DialogCanvas) SetOnNodeChanged(onNodeChanged func(data *NodeData)) {
+	dc.onNodeChanged = onNodeChanged
+}
+
+// SetOnVersionSwitch はノードの回答バージョンを切り替える関数を設定します。
+// NodeDataを他のゴルーチンと共有する場合、切り替えをその排他制御の下で行うために使用します。
+func (dc *DialogCanvas) SetOnVersionSwitch(onVersionSwitch func(data *NodeData, index int) bool) {
+	dc.onVersionSwitch = onVersionSwitch
+}
+
+// SetOnBranchSourceChanged は分岐元が変更されたときに呼び出す関数を設定します。
+func (dc *DialogCanvas) SetOnBranchSourceChanged(onBranchSourceChanged func(nodeID string)) {
+	dc.onBranchSourceChanged = onBranchSourceChanged
+}
+
+// SetOnNodeRetry は生成に失敗したノードの「再試行」ボタンが押されたときに呼び出す関数を設定します。
+func (dc *DialogCanvas) SetOnNodeRetry(onNodeRetry func(data *NodeData)) {
+	dc.onNodeRetry = onNodeRetry
+}
+
+// SetOnSelectionChanged は統合用の複数選択が変更されたときに呼び出す関数を設定します。
+func (dc *DialogCanvas) SetOnSelectionChanged(onSelectionChanged func(nodeIDs []string)) {
+	dc.onSelectionChanged = onSelectionChanged
+}
+
+// nodeTapped はノードのクリックを処理します。Ctrlキーが押されている場合は複数選択を切り替え、
+// それ以外の場合は選択を解除してノードを分岐元にします。
+func (dc *DialogCanvas) nodeTapped(data *NodeData) {
+	if controlKeyPressed() {
+		dc.ToggleSelection(data.ID)
+		return
+	}
+	dc.ClearSelection()
+	dc.SetBranchSource(data.ID)
+}
+
+// controlKeyPressed はCtrlキー (macOSではCmdキー) が押されているかどうかを返します。
+func controlKeyPressed() bool {
+	deskDrv, ok := fyne.CurrentApp().Driver().(desktop.Driver)
+	if !ok {
+		return false
+	}
+	return deskDrv.CurrentKeyModifiers()&(fyne.KeyModifierControl|fyne.KeyModifierSuper) != 0
+}
+
+// ToggleSelection はノードの複数選択を切り替えます。
+func (dc *DialogCanvas) ToggleSelection(nodeID string) {
+	dc.nodesMutex.Lock()
+	nw := dc.nodeMap[nodeID]
+	if nw == nil {
+		dc.nodesMutex.Unlock()
+		return
+	}
+	nw.data.IsSelected = !nw.data.IsSelected
+	if nw.data.IsSelected {
+		dc.selectedIDs = append(dc.selectedIDs, nodeID)
+	} else {
+		dc.selectedIDs = removeID(dc.selectedIDs, nodeID)
+	}
+	selected := append([]string{}, dc.selectedIDs...)
+	dc.nodesMutex.Unlock()
+	nw.Refresh()
+	if dc.onSelectionChanged != nil {
+		dc.onSelectionChanged(selected)
+	}
+}
+
+// SelectedNodeIDs は複数選択されているノードのIDを選択順に返します。
+func (dc *DialogCanvas) SelectedNodeIDs() []string {
+	dc.nodesMutex.RLock()
+	defer dc.nodesMutex.RUnlock()
+	return append([]string{}, dc.selectedIDs...)
+}
+
+// ClearSelection は複数選択を解除します。
+func (dc *DialogCanvas) ClearSelection() {
+	dc.nodesMutex.Lock()
+	if len(dc.selectedIDs) == 0 {
+		dc.nodesMutex.Unlock()
+		return
+	}
+	for _, id := range dc.selectedIDs {
+		if nw := dc.nodeMap[id]; nw != nil {
+			nw.data.IsSelected = false
+			nw.Refresh()
+		}
+	}
+	dc.selectedIDs = nil
+	dc.nodesMutex.Unlock()
+	if dc.onSelectionChanged != nil {
+		dc.onSelectionChanged(nil)
+	}
+}
+
+func removeID(ids []string, id string) []string {
+	kept := ids[:0]
+	for _, v := range ids {
+		if v != id {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// SetOnSuggestionSelected は提案された質問 (ゴーストノード) がクリックされたときに呼び出す関数を設定します。
+// 関数が true を返した場合、質問は送信されたものとしてゴーストノードを取り除きます。
+func (dc *DialogCanvas) SetOnSuggestionSelected(onSuggestionSelected func(parentID string, question string) bool) {
+	dc.onSuggestionSelected = onSuggestionSelected
+}
+
+// ShowSuggestions は parentID のノードの子として、提案された質問をゴーストノードで表示します。
+// 同じノードに以前の提案がある場合は置き換えます。ゴーストノードは既存の子ノードの下に並べます。
+func (dc *DialogCanvas) ShowSuggestions(parentID string, questions []string) {
+	dc.ClearSuggestions(parentID)
+
+	dc.nodesMutex.Lock()
+	parent := dc.nodeMap[parentID]
+	if parent == nil {
+		dc.nodesMutex.Unlock()
+		log.Printf("ShowSuggestions: parent node %s not found", parentID)
+		return
+	}
+	parentPos := parent.data.Position
+	offset := fyne.NewPos(parent.MinSize().Width+nodeSpacing, 0)
+	for _, n := range dc.nodes {
+		if n.data.ParentID == parentID {
+			bottom := n.data.Position.Y + n.MinSize().Height - parentPos.Y + ghostNodeVerticalSpacing
+			if bottom > offset.Y {
+				offset.Y = bottom
+			}
+		}
+	}
+	for _, question := range questions {
+		g := newGhostNode(parentID, question, offset)
+		g.onSelected = func(g *GhostNode) {
+			if dc.onSuggestionSelected != nil && dc.onSuggestionSelected(g.ParentID, g.Question) {
+				dc.removeGhost(g)
+			}
+		}
+		g.onDismissed = dc.removeGhost
+		dc.ghosts = append(dc.ghosts, g)
+		dc.content.Add(g)
+		offset.Y += ghostNodeHeight + ghostNodeVerticalSpacing
+	}
+	dc.nodesMutex.Unlock()
+	log.Printf("Showing %d suggested follow-up questions for node %s", len(questions), parentID)
+	dc.Refresh()
+}
+
+// ClearSuggestions は parentID のノードに表示しているゴーストノードを取り除きます。parentID が空の場合はすべて取り除きます。
+func (dc *DialogCanvas) ClearSuggestions(parentID string) {
+	dc.nodesMutex.Lock()
+	dc.removeGhostsLocked(func(g *GhostNode) bool { return parentID == "" || g.ParentID == parentID })
+	dc.nodesMutex.Unlock()
+	dc.Refresh()
+}
+
+// HasSuggestions は parentID のノードにゴーストノードを表示しているかどうかを返します。
+func (dc *DialogCanvas) HasSuggestions(parentID string) bool {
+	dc.nodesMutex.RLock()
+	defer dc.nodesMutex.RUnlock()
+	for _, g := range dc.ghosts {
+		if g.ParentID == parentID {
+			return true
+		}
+	}
+	return false
+}
+
+func (dc *DialogCanvas) removeGhost(target *GhostNode) {
+	dc.nodesMutex.Lock()
+	dc.removeGhostsLocked(func(g *GhostNode) bool { return g == target })
+	dc.nodesMutex.Unlock()
+	dc.Refresh()
+}
+
+// removeGhostsLocked は remove が true を返すゴーストノードを取り除きます。nodesMutex を保持した状態で呼び出します。
+func (dc *DialogCanvas) removeGhostsLocked(remove func(g *GhostNode) bool) {
+	kept := dc.ghosts[:0]
+	for _, g := range dc.ghosts {
+		if remove(g) {
+			dc.content.Remove(g)
+		} else {
+			kept = append(kept, g)
+		}
+	}
+	dc.ghosts = kept
+}
+
 // AddNode は新しいノードをキャンバスに追加します。
 func (dc *DialogCanvas) AddNode(data *NodeData) {
 	log.Printf("DialogCanvas.AddNode START - ID: %s, ParentID: %s, Title: %s", data.ID, data.ParentID, data.Title)
@@ -76,7 +280,9 @@ func (dc *DialogCanvas) AddNode(data *NodeData) {
 	}
 	nodeWidget.onDeleteRequested = dc.onNodeDeleted
 
-	if data.ParentID != "" {
+	if data.IsMerge() && dc.placeMergeNodeLocked(data, nodeWidget) {
+		// 統合ノードはすべての親の右側、親の縦位置の平均に配置します。
+	} else if data.ParentID != "" {
 		parent := dc.nodeMap[data.ParentID]
 		if parent != nil {
 			parentModelPos := parent.data.Position
@@ -125,27 +331,46 @@ func (dc *DialogCanvas) AddNode(data *NodeData) {
 	log.Printf("DialogCanvas.AddNode END - ID: %s, ModelPos: %v", data.ID, data.Position)
 }
 
+// placeMergeNodeLocked は統合ノードの位置を、すべての親の右側で親の縦位置の平均に設定します。
+// 親が1つも表示されていない場合は false を返します。nodesMutex を保持した状態で呼び出します。
+func (dc *DialogCanvas) placeMergeNodeLocked(data *NodeData, nodeWidget *NodeWidget) bool {
+	var maxRight, sumCenterY float32
+	count := 0
+	for _, id := range data.ParentIDs() {
+		parent := dc.nodeMap[id]
+		if parent == nil {
+			continue
+		}
+		size := parent.MinSize()
+		if right := parent.data.Position.X + size.Width; right > maxRight {
+			maxRight = right
+		}
+		sumCenterY += parent.data.Position.Y + size.Height/2
+		count++
+	}
+	if count == 0 {
+		return false
+	}
+	data.Position = fyne.NewPos(maxRight+nodeSpacing, sumCenterY/float32(count)-nodeWidget.MinSize().Height/2)
+	return true
+}
+
 // RemoveNodeAndDescendants removes the node with the given ID and all its descendants.
+// Merge nodes are descendants of every node they merge, so they are removed with any of their parents.
 // It returns a slice of IDs of all nodes that were actually removed.
 func (dc *DialogCanvas) RemoveNodeAndDescendants(nodeID string) []string {
 	log.Printf("DialogCanvas.RemoveNodeAndDescendants START - ID: %s", nodeID)
 
 	nodesToDeleteIDs := make(map[string]bool)
-	var collectDescendants func(currentID string)
 
 	dc.nodesMutex.RLock()
-	collectDescendants = func(currentID string) {
-		if nodesToDeleteIDs[currentID] {
-			return
-		}
-		nodesToDeleteIDs[currentID] = true
-		for _, nWidget := range dc.nodes {
-			if nWidget.data.ParentID == currentID {
-				collectDescendants(nWidget.data.ID)
-			}
-		}
+	datas := make([]*NodeData, 0, len(dc.nodes))
+	for _, nWidget := range dc.nodes {
+		datas = append(datas, nWidget.data)
+	}
+	for _, id := range DescendantIDs(datas, nodeID) {
+		nodesToDeleteIDs[id] = true
 	}
-	collectDescendants(nodeID)
 	dc.nodesMutex.RUnlock()
 
 	log.Printf("Nodes to delete (IDs): %v", nodesToDeleteIDs)
@@ -171,11 +396,26 @@ func (dc *DialogCanvas) RemoveNodeAndDescendants(nodeID string) []string {
 		}
 	}
 	dc.nodes = newNodes
+	for id := range nodesToDeleteIDs {
+		dc.selectedIDs = removeID(dc.selectedIDs, id)
+	}
+	dc.removeGhostsLocked(func(g *GhostNode) bool { return nodesToDeleteIDs[g.ParentID] })
 
 	log.Printf("DialogCanvas.RemoveNodeAndDescendants END, deleted count: %d", len(actuallyDeletedIDs))
 	return actuallyDeletedIDs
 }
 
+// RefreshNode は指定されたノードのウィジェットを再描画します。
+// ストリーミング中の回答の更新など、NodeData が変更された後に呼び出します。
+func (dc *DialogCanvas) RefreshNode(nodeID string) {
+	nw := dc.findNodeWidgetByID(nodeID)
+	if nw == nil {
+		return
+	}
+	nw.Refresh()
+	dc.Refresh()
+}
+
 func (dc *DialogCanvas) findNodeWidgetByID(id string) *NodeWidget {
 	dc.nodesMutex.RLock()
 	defer dc.nodesMutex.RUnlock()
@@ -198,12 +438,16 @@ func (dc *DialogCanvas) updateNodeConnections() {
 		if childNode == nil || childNode.data == nil {
 			continue
 		}
-		if childNode.data.ParentID != "" {
-			parentNode := dc.nodeMap[childNode.data.ParentID]
+		for i, parentID := range childNode.data.ParentIDs() {
+			parentNode := dc.nodeMap[parentID]
 
 			if parentNode != nil && parentNode.data != nil {
 				line := canvas.NewLine(theme.Color(theme.ColorNameForeground))
 				line.StrokeWidth = 1.5
+				if i > 0 {
+					// 統合ノードの2つ目以降の親からの線は色を変えて区別します。
+					line.StrokeColor = theme.Color(theme.ColorNamePrimary)
+				}
 
 				parentScreenPos := parentNode.Position()
 				parentScreenSize := parentNode.Size()
@@ -217,6 +461,35 @@ func (dc *DialogCanvas) updateNodeConnections() {
 				dc.lineLayer.Add(line)
 			}
 		}
+		// 出典として参照した関連ノードとの間には、ノードの中心同士を結ぶ細い線を引きます。
+		for _, refID := range childNode.data.References {
+			refNode := dc.nodeMap[refID]
+			if refNode == nil || refNode == childNode {
+				continue
+			}
+			line := canvas.NewLine(theme.Color(theme.ColorNameHyperlink))
+			line.StrokeWidth = 1
+			refPos, refSize := refNode.Position(), refNode.Size()
+			childPos, childSize := childNode.Position(), childNode.Size()
+			line.Position1 = fyne.NewPos(refPos.X+refSize.Width/2, refPos.Y+refSize.Height/2)
+			line.Position2 = fyne.NewPos(childPos.X+childSize.Width/2, childPos.Y+childSize.Height/2)
+			dc.lines = append(dc.lines, line)
+			dc.lineLayer.Add(line)
+		}
+	}
+	for _, g := range dc.ghosts {
+		parentNode := dc.nodeMap[g.ParentID]
+		if parentNode == nil {
+			continue
+		}
+		line := canvas.NewLine(theme.Color(theme.ColorNamePlaceHolder))
+		line.StrokeWidth = 1
+		parentScreenPos := parentNode.Position()
+		parentScreenSize := parentNode.Size()
+		line.Position1 = fyne.NewPos(parentScreenPos.X+parentScreenSize.Width, parentScreenPos.Y+parentScreenSize.Height/2)
+		line.Position2 = fyne.NewPos(g.Position().X, g.Position().Y+g.Size().Height/2)
+		dc.lines = append(dc.lines, line)
+		dc.lineLayer.Add(line)
 	}
 	dc.lineLayer.Refresh()
 	log.Println("DialogCanvas.updateNodeConnections END")
@@ -234,7 +507,12 @@ func (dc *DialogCanvas) clearConnections() {
 
 func (dc *DialogCanvas) SetBranchSource(nodeID string) {
 	dc.nodesMutex.Lock()
-	defer dc.nodesMutex.Unlock()
+	defer func() {
+		dc.nodesMutex.Unlock()
+		if dc.onBranchSourceChanged != nil {
+			dc.onBranchSourceChanged(nodeID)
+		}
+	}()
 
 	previousSourceID := dc.selectedBranchSourceID
 	dc.selectedBranchSourceID = nodeID
@@ -301,6 +579,25 @@ func (dc *DialogCanvas) Scrolled(ev *fyne.ScrollEvent) { // Zooming
 	dc.Refresh()
 }
 
+// CenterOnNode は指定ノードが表示領域の中央に来るように表示位置を移動し、そのノードを分岐元にします。
+// ノードが見つからない場合は false を返します。
+func (dc *DialogCanvas) CenterOnNode(nodeID string) bool {
+	dc.nodesMutex.RLock()
+	nw := dc.nodeMap[nodeID]
+	dc.nodesMutex.RUnlock()
+	if nw == nil || nw.data == nil {
+		return false
+	}
+	size := nw.MinSize()
+	center := nw.data.Position.Add(fyne.NewPos(size.Width/2, size.Height/2))
+	canvasSize := dc.Size()
+	dc.viewOffset = fyne.NewPos(canvasSize.Width/2-center.X*dc.zoomFactor, canvasSize.Height/2-center.Y*dc.zoomFactor)
+	log.Printf("Center on node %s: Offset: %v", nodeID, dc.viewOffset)
+	dc.SetBranchSource(nodeID)
+	dc.Refresh()
+	return true
+}
+
 func (dc *DialogCanvas) Clear() {
 	dc.nodesMutex.Lock()
 	defer dc.nodesMutex.Unlock()
@@ -309,6 +606,8 @@ func (dc *DialogCanvas) Clear() {
 	}
 	dc.nodes = []*NodeWidget{}
 	dc.nodeMap = make(map[string]*NodeWidget) // Clear the map
+	dc.removeGhostsLocked(func(*GhostNode) bool { return true })
+	dc.selectedIDs = nil
 	dc.clearConnections()
 	dc.content.Refresh()
 }
@@ -341,6 +640,12 @@ func (r *dialogCanvasRenderer) Layout(size fyne.Size) {
 	r.canvas.nodesMutex.RLock()
 	nodesCopy := make([]*NodeWidget, len(r.canvas.nodes))
 	copy(nodesCopy, r.canvas.nodes)
+	ghostsCopy := make([]*GhostNode, len(r.canvas.ghosts))
+	copy(ghostsCopy, r.canvas.ghosts)
+	ghostParents := make(map[*GhostNode]*NodeWidget, len(ghostsCopy))
+	for _, g := range ghostsCopy {
+		ghostParents[g] = r.canvas.nodeMap[g.ParentID]
+	}
 	r.canvas.nodesMutex.RUnlock()
 
 	for _, nw := range nodesCopy {
@@ -356,6 +661,15 @@ func (r *dialogCanvasRenderer) Layout(size fyne.Size) {
 		nw.Resize(screenSize)
 		nw.Refresh()
 	}
+	for _, g := range ghostsCopy {
+		parent := ghostParents[g]
+		if parent == nil {
+			continue
+		}
+		modelPos := parent.data.Position.Add(g.offset)
+		g.Move(fyne.NewPos(modelPos.X*r.canvas.zoomFactor, modelPos.Y*r.canvas.zoomFactor).Add(r.canvas.viewOffset))
+		g.Resize(fyne.NewSize(g.MinSize().Width*r.canvas.zoomFactor, g.MinSize().Height*r.canvas.zoomFactor))
+	}
 	// r.canvas.updateNodeConnections() // Moved to Refresh to avoid potential loops
 	log.Println("DialogCanvasRenderer.Layout END")
 }
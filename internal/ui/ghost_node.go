@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"AI-Dialogue-Map/internal/utils"
+)
+
+const (
+	ghostNodeHeight          float32 = 72
+	ghostQuestionMaxLength           = 60
+	ghostNodeVerticalSpacing float32 = 12
+)
+
+// GhostNode はAIが提案したフォローアップ質問を、親ノードの子として半透明に表示するウィジェットです。
+// クリックすると質問として送信され、保存されるのは送信後のノードだけです。
+type GhostNode struct {
+	widget.BaseWidget
+	ParentID string
+	Question string
+
+	offset      fyne.Position // 親ノードの位置からの相対位置 (モデル座標)
+	onSelected  func(g *GhostNode)
+	onDismissed func(g *GhostNode)
+}
+
+// newGhostNode は新しいGhostNodeを作成します。
+func newGhostNode(parentID string, question string, offset fyne.Position) *GhostNode {
+	g := &GhostNode{ParentID: parentID, Question: question, offset: offset}
+	g.ExtendBaseWidget(g)
+	return g
+}
+
+// CreateRenderer is a private method to Fyne which links this widget to its renderer
+func (g *GhostNode) CreateRenderer() fyne.WidgetRenderer {
+	rect := canvas.NewRectangle(theme.Color(theme.ColorNameHover))
+	rect.StrokeColor = theme.Color(theme.ColorNamePlaceHolder)
+	rect.StrokeWidth = 1
+	rect.CornerRadius = theme.Size(theme.SizeNamePadding)
+
+	label := widget.NewLabel(utils.TruncateText(g.Question, ghostQuestionMaxLength))
+	label.Wrapping = fyne.TextWrapWord
+	label.TextStyle = fyne.TextStyle{Italic: true}
+	label.Importance = widget.LowImportance
+
+	dismissButton := widget.NewButtonWithIcon("", theme.CancelIcon(), func() {
+		if g.onDismissed != nil {
+			g.onDismissed(g)
+		}
+	})
+	dismissButton.Importance = widget.LowImportance
+
+	return widget.NewSimpleRenderer(container.NewStack(rect, container.NewBorder(nil, nil, nil, container.NewVBox(dismissButton), label)))
+}
+
+// MinSize returns the minimum size of the widget.
+func (g *GhostNode) MinSize() fyne.Size {
+	return fyne.NewSize(nodeWidthCollapsed, ghostNodeHeight)
+}
+
+// Tapped は提案された質問を選択します。
+func (g *GhostNode) Tapped(*fyne.PointEvent) {
+	if g.onSelected != nil {
+		g.onSelected(g)
+	}
+}
+
+// Cursor はクリックできることを示すポインタカーソルを返します。
+func (g *GhostNode) Cursor() desktop.Cursor {
+	return desktop.PointerCursor
+}
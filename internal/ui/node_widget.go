@@ -3,6 +3,8 @@ package ui
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -25,35 +27,266 @@ const (
 	nodeTitleMaxLength              = 25
 )
 
+// GenerationSettings は回答の生成に使用したモデルと生成パラメータを保持します。
+// nilの項目はプロバイダの既定値を使用したことを表します。
+type GenerationSettings struct {
+	Provider        string   `yaml:"provider,omitempty"`
+	Model           string   `yaml:"model,omitempty"`
+	Temperature     *float32 `yaml:"temperature,omitempty"`
+	TopP            *float32 `yaml:"top_p,omitempty"`
+	TopK            *int32   `yaml:"top_k,omitempty"`
+	MaxOutputTokens *int32   `yaml:"max_output_tokens,omitempty"`
+	// CacheHit は回答が応答キャッシュから返されたかどうかです。
+	CacheHit bool `yaml:"cache_hit,omitempty"`
+	// FinishReason は生成が正常に終了しなかった場合の理由 (max_tokens、other など) です。正常終了の場合は空です。
+	FinishReason string `yaml:"finish_reason,omitempty"`
+	// SafetyRatings は有害性が medium 以上と評価されたカテゴリです。
+	SafetyRatings []SafetyRating `yaml:"safety_ratings,omitempty"`
+}
+
+// SafetyRating はカテゴリごとの有害性の評価です。
+type SafetyRating struct {
+	Category    string `yaml:"category"`
+	Probability string `yaml:"probability"`
+}
+
+// IsTruncated は回答の生成が正常に終了せず、途中で打ち切られたかどうかを返します。
+func (gs *GenerationSettings) IsTruncated() bool {
+	return gs != nil && gs.FinishReason != ""
+}
+
+// FinishNotice は回答が途中で打ち切られた場合などに、回答の下に表示する注意書きを返します。正常に終了した場合は空文字列です。
+func (gs *GenerationSettings) FinishNotice() string {
+	if gs == nil {
+		return ""
+	}
+	var notice string
+	switch gs.FinishReason {
+	case "":
+	case "max_tokens":
+		notice = "最大出力トークン数に達したため、回答は途中で打ち切られています。"
+	case "safety":
+		notice = "安全性フィルタにより、回答は途中で打ち切られています。"
+	case "recitation":
+		notice = "著作物の引用と判定されたため、回答は途中で打ち切られています。"
+	default:
+		notice = fmt.Sprintf("回答の生成が途中で終了しました (理由: %s)。", gs.FinishReason)
+	}
+	if len(gs.SafetyRatings) > 0 {
+		ratings := make([]string, len(gs.SafetyRatings))
+		for i, r := range gs.SafetyRatings {
+			ratings[i] = r.Category + ": " + r.Probability
+		}
+		if notice != "" {
+			notice += "\n\n"
+		}
+		notice += "安全性評価: " + strings.Join(ratings, ", ")
+	}
+	return notice
+}
+
+// Summary はノード上に表示する短い説明 (例: "gemini/gemini-1.5-flash · T=0.7 · max=1024") を返します。
+func (gs *GenerationSettings) Summary() string {
+	if gs == nil {
+		return ""
+	}
+	parts := []string{}
+	if gs.Provider != "" && gs.Model != "" {
+		parts = append(parts, gs.Provider+"/"+gs.Model)
+	} else if gs.Model != "" {
+		parts = append(parts, gs.Model)
+	}
+	if gs.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("T=%g", *gs.Temperature))
+	}
+	if gs.TopP != nil {
+		parts = append(parts, fmt.Sprintf("P=%g", *gs.TopP))
+	}
+	if gs.TopK != nil {
+		parts = append(parts, fmt.Sprintf("K=%d", *gs.TopK))
+	}
+	if gs.MaxOutputTokens != nil {
+		parts = append(parts, fmt.Sprintf("max=%d", *gs.MaxOutputTokens))
+	}
+	if gs.CacheHit {
+		parts = append(parts, "キャッシュ")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// AnswerVersion は1つのノードに保存された回答のバージョンです。
+// 回答本文はノードのMarkdownファイルと同様に別ファイルに保存されます。
+type AnswerVersion struct {
+	Answer     string              `yaml:"-"`
+	Generation *GenerationSettings `yaml:"generation,omitempty"`
+	CreatedAt  time.Time           `yaml:"created_at,omitempty"`
+}
+
+// NodeStatus はノードの回答の生成状態です。
+type NodeStatus string
+
+const (
+	NodeStatusPending  NodeStatus = "pending"  // 回答を生成中
+	NodeStatusFailed   NodeStatus = "failed"   // 回答の生成に失敗 (Error に理由を保持)
+	NodeStatusComplete NodeStatus = "complete" // 回答の生成が完了
+)
+
 // NodeData はノードのデータを保持します。
 // This struct is now defined here and used by other files in the 'main' package.
 type NodeData struct {
-	ID             string        `yaml:"id"`
-	Title          string        `yaml:"title"`
-	Question       string        `yaml:"-"`
-	Answer         string        `yaml:"-"`
-	Position       fyne.Position `yaml:"position"`
-	Expanded       bool          `yaml:"expanded"`
-	ParentID       string        `yaml:"parent_id,omitempty"`
-	IsBranchSource bool          `yaml:"-"`
+	ID             string              `yaml:"id"`
+	Title          string              `yaml:"title"`
+	Question       string              `yaml:"-"`
+	Answer         string              `yaml:"-"`
+	Position       fyne.Position       `yaml:"position"`
+	Expanded       bool                `yaml:"expanded"`
+	ParentID       string              `yaml:"parent_id,omitempty"`
+	MergeParentIDs []string            `yaml:"merge_parent_ids,omitempty"` // 統合ノードの2つ目以降の親 (1つ目は ParentID)
+	SystemPrompt   string              `yaml:"system_prompt,omitempty"`    // このノード以下のサブツリーに適用するシステムプロンプト
+	Generation     *GenerationSettings `yaml:"generation,omitempty"`       // 回答の生成に使用したモデルと生成パラメータ
+	Versions       []AnswerVersion     `yaml:"versions,omitempty"`         // 再生成で作成された回答のバージョン (2つ以上ある場合のみ)
+	ActiveVersion  int                 `yaml:"active_version,omitempty"`   // 子ノードの文脈として使用するバージョンのインデックス
+	Attachments    []Attachment        `yaml:"attachments,omitempty"`      // 質問に添付したファイル
+	Summary        string              `yaml:"summary,omitempty"`          // ルートからこのノードまでの会話の要約 (深い分岐の文脈として使用)
+	SummaryHash    string              `yaml:"summary_hash,omitempty"`     // 要約したときの会話内容のハッシュ。一致しない場合は要約を作り直します
+	Usage          []ModelUsage        `yaml:"usage,omitempty"`            // このノードの生成 (再生成・タイトル生成・要約を含む) で消費したトークン数
+	Status         NodeStatus          `yaml:"status,omitempty"`           // 回答の生成状態。空の場合は完了として扱います
+	Error          string              `yaml:"error,omitempty"`            // 生成に失敗した理由 (Status が failed の場合)
+	ToolCalls      []ToolCallRecord    `yaml:"tool_calls,omitempty"`       // 回答の生成中に呼び出したツールと結果
+	References     []string            `yaml:"references,omitempty"`       // 質問に出典として加えた関連ノードのID (順序が出典番号 [1], [2]... に対応)
+	IsBranchSource bool                `yaml:"-"`
+	IsSelected     bool                `yaml:"-"` // 統合のために複数選択されているかどうか
+}
+
+// ParentIDs は統合ノードの2つ目以降の親を含む、すべての親のIDを返します。
+func (nd *NodeData) ParentIDs() []string {
+	if nd.ParentID == "" {
+		return nd.MergeParentIDs
+	}
+	return append([]string{nd.ParentID}, nd.MergeParentIDs...)
+}
+
+// DescendantIDs は nodes のうち、nodeID のノード自身とその子孫のIDを nodes の順に返します。
+// 統合ノードは統合したすべての分岐に依存するため、2つ目以降の親を含むいずれかの親が削除対象であれば子孫として扱います。
+func DescendantIDs(nodes []*NodeData, nodeID string) []string {
+	descendants := map[string]bool{nodeID: true}
+	for changed := true; changed; {
+		changed = false
+		for _, n := range nodes {
+			if descendants[n.ID] {
+				continue
+			}
+			for _, parentID := range n.ParentIDs() {
+				if descendants[parentID] {
+					descendants[n.ID] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	ids := make([]string, 0, len(descendants))
+	for _, n := range nodes {
+		if descendants[n.ID] {
+			ids = append(ids, n.ID)
+		}
+	}
+	return ids
+}
+
+// IsMerge は複数の分岐を統合したノードかどうかを返します。
+func (nd *NodeData) IsMerge() bool {
+	return len(nd.MergeParentIDs) > 0
+}
+
+// UsageSummary はノード上に表示するトークン使用量 (例: "入力1.2k 出力350") を返します。記録がない場合は空文字列です。
+func (nd *NodeData) UsageSummary() string {
+	if len(nd.Usage) == 0 {
+		return ""
+	}
+	prompt, response := TotalUsage(nd.Usage)
+	return fmt.Sprintf("入力%s 出力%s", formatTokenCount(prompt), formatTokenCount(response))
+}
+
+// IsComplete は回答の生成が完了しているかどうかを返します。
+// Status を持たない以前の形式のノードは完了として扱います。
+func (nd *NodeData) IsComplete() bool {
+	return nd.Status == "" || nd.Status == NodeStatusComplete
+}
+
+// IsFailed は回答の生成に失敗したノードかどうかを返します。
+func (nd *NodeData) IsFailed() bool {
+	return nd.Status == NodeStatusFailed
+}
+
+// MarkFailed はノードを生成失敗の状態にします。回答は保持せず、エラーの内容のみを記録します。
+func (nd *NodeData) MarkFailed(err error) {
+	nd.Status = NodeStatusFailed
+	nd.Error = err.Error()
+}
+
+// MarkComplete はノードを生成完了の状態にし、以前のエラーを消去します。
+func (nd *NodeData) MarkComplete() {
+	nd.Status = NodeStatusComplete
+	nd.Error = ""
+}
+
+// VersionCount は回答のバージョン数を返します。再生成していないノードは1です。
+func (nd *NodeData) VersionCount() int {
+	if len(nd.Versions) == 0 {
+		return 1
+	}
+	return len(nd.Versions)
+}
+
+// AddVersion は新しい回答をバージョンとして追加し、アクティブなバージョンにします。
+// 初回の追加時は現在の回答を最初のバージョンとして保存します。
+func (nd *NodeData) AddVersion(answer string, generation *GenerationSettings) {
+	if len(nd.Versions) == 0 {
+		nd.Versions = []AnswerVersion{{Answer: nd.Answer, Generation: nd.Generation}}
+	}
+	nd.Versions = append(nd.Versions, AnswerVersion{Answer: answer, Generation: generation, CreatedAt: time.Now()})
+	nd.SetActiveVersion(len(nd.Versions) - 1)
+}
+
+// SetActiveVersion は指定したバージョンをアクティブにし、Answer と Generation に反映します。
+// 範囲外のインデックスの場合は false を返します。
+func (nd *NodeData) SetActiveVersion(index int) bool {
+	if index < 0 || index >= len(nd.Versions) {
+		return false
+	}
+	nd.ActiveVersion = index
+	nd.Answer = nd.Versions[index].Answer
+	nd.Generation = nd.Versions[index].Generation
+	return true
 }
 
 // NodeWidget はキャンバス上の単一ノードを表すウィジェットです。
 // This struct is now defined here.
 type NodeWidget struct {
 	widget.BaseWidget
-	data              *NodeData
-	rect              *canvas.Rectangle
-	titleLabel        *widget.Label
-	answerDisplay     *widget.RichText
-	answerScroll      *container.Scroll
-	expandButton      *widget.Button
-	branchButton      *widget.Button
-	deleteButton      *widget.Button
-	onDragChanged     func()
-	onBranchRequested func(*NodeData)
-	onDeleteRequested func(nodeID string)
-	dialogCanvas      *DialogCanvas // Reference to the parent canvas (DialogCanvas defined in dialog_canvas.go)
+	data               *NodeData
+	rect               *canvas.Rectangle
+	titleLabel         *widget.Label
+	answerDisplay      *widget.RichText
+	answerScroll       *container.Scroll
+	toolCallsDisplay   *widget.RichText
+	toolCallsItem      *widget.AccordionItem
+	toolCallsAccordion *widget.Accordion
+	expandButton       *widget.Button
+	branchButton       *widget.Button
+	deleteButton       *widget.Button
+	menuButton         *widget.Button
+	retryButton        *widget.Button
+	modelLabel         *widget.Label
+	prevVersionButton  *widget.Button
+	nextVersionButton  *widget.Button
+	versionLabel       *widget.Label
+	versionBox         *fyne.Container
+	onDragChanged      func()
+	onBranchRequested  func(*NodeData)
+	onDeleteRequested  func(nodeID string)
+	dialogCanvas       *DialogCanvas // Reference to the parent canvas (DialogCanvas defined in dialog_canvas.go)
 }
 
 // NewNodeWidget は新しいNodeWidgetのインスタンスを作成します。
@@ -79,7 +312,24 @@ func (nw *NodeWidget) CreateRenderer() fyne.WidgetRenderer {
 
 	nw.answerDisplay = widget.NewRichTextFromMarkdown("")
 	nw.answerDisplay.Wrapping = fyne.TextWrapWord
-	nw.answerScroll = container.NewScroll(nw.answerDisplay)
+	nw.toolCallsDisplay = widget.NewRichTextFromMarkdown("")
+	nw.toolCallsDisplay.Wrapping = fyne.TextWrapWord
+	nw.toolCallsItem = widget.NewAccordionItem("", nw.toolCallsDisplay)
+	nw.toolCallsAccordion = widget.NewAccordion(nw.toolCallsItem)
+	nw.answerScroll = container.NewScroll(container.NewVBox(nw.toolCallsAccordion, nw.answerDisplay))
+
+	nw.modelLabel = widget.NewLabel("")
+	nw.modelLabel.SizeName = theme.SizeNameCaptionText
+	nw.modelLabel.Importance = widget.LowImportance
+	nw.modelLabel.Truncation = fyne.TextTruncateEllipsis
+
+	nw.prevVersionButton = widget.NewButtonWithIcon("", theme.NavigateBackIcon(), func() { nw.switchVersion(-1) })
+	nw.prevVersionButton.Importance = widget.LowImportance
+	nw.nextVersionButton = widget.NewButtonWithIcon("", theme.NavigateNextIcon(), func() { nw.switchVersion(1) })
+	nw.nextVersionButton.Importance = widget.LowImportance
+	nw.versionLabel = widget.NewLabel("")
+	nw.versionLabel.SizeName = theme.SizeNameCaptionText
+	nw.versionBox = container.NewHBox(nw.prevVersionButton, nw.versionLabel, nw.nextVersionButton)
 
 	nw.expandButton = widget.NewButtonWithIcon("", theme.MoreVerticalIcon(), func() {
 		nw.data.Expanded = !nw.data.Expanded
@@ -122,14 +372,27 @@ func (nw *NodeWidget) CreateRenderer() fyne.WidgetRenderer {
 	})
 	nw.deleteButton.Importance = widget.LowImportance
 
+	nw.retryButton = widget.NewButtonWithIcon("再試行", theme.ViewRefreshIcon(), func() {
+		if nw.dialogCanvas != nil && nw.dialogCanvas.onNodeRetry != nil {
+			nw.dialogCanvas.onNodeRetry(nw.data)
+		}
+	})
+	nw.retryButton.Importance = widget.DangerImportance
+
+	nw.menuButton = widget.NewButtonWithIcon("", theme.MoreHorizontalIcon(), nw.showNodeMenu)
+	nw.menuButton.Importance = widget.LowImportance
+
 	nw.expandButton.Importance = widget.LowImportance
 	nw.branchButton.Importance = widget.LowImportance
 
-	titleBar := container.NewBorder(nil, nil, nil, nw.deleteButton, nw.titleLabel)
+	var header fyne.CanvasObject = container.NewBorder(nil, nil, nil, container.NewHBox(nw.menuButton, nw.deleteButton), nw.titleLabel)
+	if len(nw.data.Attachments) > 0 {
+		header = container.NewVBox(header, container.NewHScroll(NewAttachmentChips(nw.data.Attachments, nil)))
+	}
 
 	mainContentArea := container.NewBorder(
-		titleBar,
-		container.NewHBox(layout.NewSpacer(), nw.expandButton),
+		header,
+		container.NewBorder(nil, nil, container.NewHBox(nw.retryButton, nw.versionBox), nw.expandButton, nw.modelLabel),
 		nil,
 		nil,
 		nw.answerScroll,
@@ -158,6 +421,52 @@ func (nw *NodeWidget) CreateRenderer() fyne.WidgetRenderer {
 	return r
 }
 
+// switchVersion はアクティブな回答バージョンを delta だけ移動します。
+func (nw *NodeWidget) switchVersion(delta int) {
+	index := nw.data.ActiveVersion + delta
+	var switched bool
+	if nw.dialogCanvas != nil && nw.dialogCanvas.onVersionSwitch != nil {
+		switched = nw.dialogCanvas.onVersionSwitch(nw.data, index)
+	} else {
+		switched = nw.data.SetActiveVersion(index)
+	}
+	if !switched {
+		return
+	}
+	nw.Refresh()
+	if nw.dialogCanvas != nil {
+		if nw.dialogCanvas.onNodeChanged != nil {
+			nw.dialogCanvas.onNodeChanged(nw.data)
+		}
+		nw.dialogCanvas.Refresh()
+	}
+}
+
+// showNodeMenu はDialogCanvasから取得したノード操作メニューをボタンの位置に表示します。
+func (nw *NodeWidget) showNodeMenu() {
+	if nw.dialogCanvas == nil || nw.dialogCanvas.nodeMenuProvider == nil {
+		return
+	}
+	items := nw.dialogCanvas.nodeMenuProvider(nw.data)
+	if len(items) == 0 {
+		return
+	}
+	c := fyne.CurrentApp().Driver().CanvasForObject(nw.menuButton)
+	if c == nil {
+		return
+	}
+	pos := fyne.CurrentApp().Driver().AbsolutePositionForObject(nw.menuButton)
+	pos = pos.Add(fyne.NewPos(0, nw.menuButton.Size().Height))
+	widget.ShowPopUpMenuAtPosition(fyne.NewMenu("", items...), c, pos)
+}
+
+// Tapped はノードを分岐元にします。Ctrlキーを押しながらのクリックでは統合用の複数選択を切り替えます。
+func (nw *NodeWidget) Tapped(*fyne.PointEvent) {
+	if nw.dialogCanvas != nil {
+		nw.dialogCanvas.nodeTapped(nw.data)
+	}
+}
+
 // Dragged is called when a drag event occurs on the widget.
 func (nw *NodeWidget) Dragged(e *fyne.DragEvent) {
 	if nw.dialogCanvas != nil && nw.dialogCanvas.zoomFactor != 0 {
@@ -216,6 +525,11 @@ func (nw *NodeWidget) MinSize() fyne.Size {
 	expandButtonHeight := defaultIconSize + padding*2
 	branchButtonWidth := defaultIconSize + padding*2
 	deleteButtonWidth := defaultIconSize + padding*2
+	menuButtonWidth := defaultIconSize + padding*2
+	var attachmentRowHeight float32
+	if len(nw.data.Attachments) > 0 {
+		attachmentRowHeight = defaultIconSize + padding*2
+	}
 
 	var answerContentHeight float32
 	if nw.data.Expanded {
@@ -242,8 +556,8 @@ func (nw *NodeWidget) MinSize() fyne.Size {
 	}
 
 	if nw.data.Expanded {
-		targetWidth = nodeWidthExpanded + branchButtonWidth + deleteButtonWidth + padding*2
-		targetHeight = titleTextHeight + answerContentHeight + expandButtonHeight + padding*4
+		targetWidth = nodeWidthExpanded + branchButtonWidth + deleteButtonWidth + menuButtonWidth + padding*2
+		targetHeight = titleTextHeight + attachmentRowHeight + answerContentHeight + expandButtonHeight + padding*4
 		if maxNodeHeightExpanded > 0 && targetHeight > maxNodeHeightExpanded {
 			targetHeight = maxNodeHeightExpanded
 		}
@@ -251,8 +565,8 @@ func (nw *NodeWidget) MinSize() fyne.Size {
 			targetHeight = nodeHeightCollapsed
 		}
 	} else {
-		targetWidth = nodeWidthCollapsed + branchButtonWidth + deleteButtonWidth + padding*2
-		targetHeight = nodeHeightCollapsed
+		targetWidth = nodeWidthCollapsed + branchButtonWidth + deleteButtonWidth + menuButtonWidth + padding*2
+		targetHeight = nodeHeightCollapsed + attachmentRowHeight
 	}
 	return fyne.NewSize(targetWidth, targetHeight)
 }
@@ -303,19 +617,84 @@ func (r *nodeWidgetRenderer) Refresh() {
 }
 
 func (r *nodeWidgetRenderer) updateAppearance() {
+	if r.widget.data.IsSelected {
+		r.rect.FillColor = theme.Color(theme.ColorNameSelection)
+	} else {
+		r.rect.FillColor = theme.Color(theme.ColorNameInputBackground)
+	}
 	if r.widget.data.IsBranchSource {
 		r.rect.StrokeColor = theme.Color(theme.ColorNamePrimary)
 		r.rect.StrokeWidth = 2
+	} else if r.widget.data.IsFailed() {
+		r.rect.StrokeColor = theme.Color(theme.ColorNameError)
+		r.rect.StrokeWidth = 2
+	} else if r.widget.data.IsComplete() && r.widget.data.Generation.IsTruncated() {
+		r.rect.StrokeColor = theme.Color(theme.ColorNameWarning)
+		r.rect.StrokeWidth = 2
 	} else {
 		r.rect.StrokeColor = theme.Color(theme.ColorNameInputBorder)
 		r.rect.StrokeWidth = 1
 	}
 	r.widget.titleLabel.SetText(utils.TruncateText(r.widget.data.Title, nodeTitleMaxLength))
+	modelText := r.widget.data.Generation.Summary()
+	if usage := r.widget.data.UsageSummary(); usage != "" {
+		if modelText != "" {
+			modelText += " · "
+		}
+		modelText += usage
+	}
+	if n := len(r.widget.data.ToolCalls); n > 0 {
+		if modelText != "" {
+			modelText += " · "
+		}
+		modelText += fmt.Sprintf("ツール%d回", n)
+	}
+	if n := len(r.widget.data.References); n > 0 {
+		if modelText != "" {
+			modelText += " · "
+		}
+		modelText += fmt.Sprintf("参照%d件", n)
+	}
+	r.widget.modelLabel.SetText(modelText)
+	if count := r.widget.data.VersionCount(); count > 1 {
+		r.widget.versionLabel.SetText(fmt.Sprintf("%d/%d", r.widget.data.ActiveVersion+1, count))
+		if r.widget.data.ActiveVersion > 0 {
+			r.widget.prevVersionButton.Enable()
+		} else {
+			r.widget.prevVersionButton.Disable()
+		}
+		if r.widget.data.ActiveVersion < count-1 {
+			r.widget.nextVersionButton.Enable()
+		} else {
+			r.widget.nextVersionButton.Disable()
+		}
+		r.widget.versionBox.Show()
+	} else {
+		r.widget.versionBox.Hide()
+	}
+	answer := r.widget.data.Answer
+	if r.widget.data.IsFailed() {
+		answer = "**回答の生成に失敗しました**\n\n" + r.widget.data.Error
+		r.widget.retryButton.Show()
+	} else {
+		r.widget.retryButton.Hide()
+		if notice := r.widget.data.Generation.FinishNotice(); notice != "" && r.widget.data.IsComplete() {
+			answer += "\n\n---\n\n⚠ " + notice
+		}
+	}
+	if n := len(r.widget.data.ToolCalls); n > 0 && r.widget.data.Expanded {
+		r.widget.toolCallsItem.Title = fmt.Sprintf("ツール呼び出し (%d)", n)
+		r.widget.toolCallsDisplay.ParseMarkdown(ToolCallsMarkdown(r.widget.data.ToolCalls))
+		r.widget.toolCallsAccordion.Refresh()
+		r.widget.toolCallsAccordion.Show()
+	} else {
+		r.widget.toolCallsAccordion.Hide()
+	}
 	if r.widget.data.Expanded {
-		r.widget.answerDisplay.ParseMarkdown(r.widget.data.Answer)
+		r.widget.answerDisplay.ParseMarkdown(answer)
 		r.widget.expandButton.SetIcon(theme.MenuExpandIcon())
 	} else {
-		r.widget.answerDisplay.ParseMarkdown(utils.TruncateTextWithEllipsis(r.widget.data.Answer, 200, maxAnswerLinesCollapsed))
+		r.widget.answerDisplay.ParseMarkdown(utils.TruncateTextWithEllipsis(answer, 200, maxAnswerLinesCollapsed))
 		r.widget.expandButton.SetIcon(theme.MoreVerticalIcon())
 	}
 	r.rect.Refresh()
@@ -324,4 +703,5 @@ func (r *nodeWidgetRenderer) updateAppearance() {
 	r.widget.answerScroll.Refresh()
 	r.widget.expandButton.Refresh()
 	r.widget.deleteButton.Refresh()
+	r.widget.menuButton.Refresh()
 }
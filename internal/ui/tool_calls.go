@@ -0,0 +1,36 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"AI-Dialogue-Map/internal/utils"
+)
+
+// toolResultMaxLength はノード上に表示するツールの実行結果の最大文字数です。
+const toolResultMaxLength = 400
+
+// ToolCallRecord は回答の生成中にモデルが呼び出したツールと、その実行結果の記録です。
+type ToolCallRecord struct {
+	Name      string `yaml:"name"`
+	Arguments string `yaml:"arguments,omitempty"` // 引数 (JSON)
+	Result    string `yaml:"result,omitempty"`
+	Error     string `yaml:"error,omitempty"`
+}
+
+// ToolCallsMarkdown はツール呼び出しの記録をノードに表示するMarkdownに整形します。
+func ToolCallsMarkdown(records []ToolCallRecord) string {
+	var b strings.Builder
+	for i, rec := range records {
+		fmt.Fprintf(&b, "**%d. %s**\n\n", i+1, rec.Name)
+		if rec.Arguments != "" {
+			fmt.Fprintf(&b, "```\n%s\n```\n\n", rec.Arguments)
+		}
+		if rec.Error != "" {
+			fmt.Fprintf(&b, "エラー: %s\n\n", rec.Error)
+		} else {
+			fmt.Fprintf(&b, "```\n%s\n```\n\n", utils.TruncateText(rec.Result, toolResultMaxLength))
+		}
+	}
+	return b.String()
+}
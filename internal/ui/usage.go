@@ -0,0 +1,48 @@
+package ui
+
+import "fmt"
+
+// ModelUsage はモデルごとのトークン使用量の累計です。
+type ModelUsage struct {
+	Provider       string `yaml:"provider,omitempty"`
+	Model          string `yaml:"model"`
+	Requests       int    `yaml:"requests"`
+	PromptTokens   int    `yaml:"prompt_tokens"`
+	ResponseTokens int    `yaml:"response_tokens"`
+}
+
+// AddUsage は usages の該当するモデルの累計に1リクエスト分の使用量を加えます。該当がない場合は追加します。
+func AddUsage(usages []ModelUsage, provider string, model string, promptTokens int, responseTokens int) []ModelUsage {
+	for i := range usages {
+		if usages[i].Provider == provider && usages[i].Model == model {
+			usages[i].Requests++
+			usages[i].PromptTokens += promptTokens
+			usages[i].ResponseTokens += responseTokens
+			return usages
+		}
+	}
+	return append(usages, ModelUsage{
+		Provider:       provider,
+		Model:          model,
+		Requests:       1,
+		PromptTokens:   promptTokens,
+		ResponseTokens: responseTokens,
+	})
+}
+
+// TotalUsage はすべてのモデルの入力・出力トークン数の合計を返します。
+func TotalUsage(usages []ModelUsage) (promptTokens int, responseTokens int) {
+	for _, u := range usages {
+		promptTokens += u.PromptTokens
+		responseTokens += u.ResponseTokens
+	}
+	return promptTokens, responseTokens
+}
+
+// formatTokenCount はトークン数を "1.2k" のような短い表記にします。
+func formatTokenCount(n int) string {
+	if n >= 1000 {
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprintf("%d", n)
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "10 - 4 - 3", want: 3},
+		{expr: "24 / 4 / 2", want: 3},
+		{expr: "7 % 3 + 1", want: 2},
+		{expr: "2 * 3 ^ 2", want: 18},
+		{expr: "2 ^ 3 ^ 2", want: 512},
+		{expr: "-3 + 5", want: 2},
+		{expr: "-2 ^ 2", want: -4},
+		{expr: "(-2) ^ 2", want: 4},
+		{expr: "2 ^ -1", want: 0.5},
+		{expr: "--3", want: 3},
+		{expr: "+4 - -1", want: 5},
+		{expr: "3 * -(1 + 1)", want: -6},
+		{expr: " 1.5*4 ", want: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvaluateExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("EvaluateExpression(%q): %v", tt.expr, err)
+			}
+			if got != tt.want {
+				t.Errorf("EvaluateExpression(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "1 / 0", wantErr: "division by zero"},
+		{expr: "10 / (5 - 5)", wantErr: "division by zero"},
+		{expr: "5 % 0", wantErr: "division by zero"},
+		{expr: "2 ^ 2000", wantErr: "not a finite number"},
+		{expr: "", wantErr: "unexpected end of expression"},
+		{expr: "1 +", wantErr: "unexpected end of expression"},
+		{expr: "(1 + 2", wantErr: "missing closing parenthesis"},
+		{expr: "2 * )", wantErr: `unexpected ")" at position 5`},
+		{expr: "1 2", wantErr: `unexpected "2" at position 3`},
+		{expr: "abc", wantErr: `unexpected "a" at position 1`},
+		{expr: "1e3", wantErr: `unexpected "e" at position 2`},
+		{expr: "1..2", wantErr: `invalid number "1..2"`},
+		{expr: "１２", wantErr: `invalid number "１２"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvaluateExpression(tt.expr)
+			if err == nil {
+				t.Fatalf("EvaluateExpression(%q) = %v, want an error", tt.expr, got)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("EvaluateExpression(%q) error = %q, want %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}